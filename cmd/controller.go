@@ -3,15 +3,22 @@ package cmd
 import (
 	"context"
 	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/component-base/config"
 	"k8s.io/component-base/config/options"
 	"k8s.io/component-base/config/validation"
@@ -26,6 +33,19 @@ import (
 var (
 	controllerEndpoint   string
 	allowedVolumeGroups  []string
+	metricsAddress       string
+	overprovisionRatio   float64
+	monitorInterval      time.Duration
+	dataThreshold        float64
+	metadataThreshold    float64
+	raidMonitorInterval  time.Duration
+	raidScrubInterval    time.Duration
+	raidSparePVTag       string
+	orphanInterval       time.Duration
+	orphanGracePeriod    time.Duration
+	deleteOrphans        bool
+	trashTTL             time.Duration
+	trashPurgeInterval   time.Duration
 	leaderElectionConfig = config.LeaderElectionConfiguration{
 		LeaseDuration:     metav1.Duration{Duration: 15 * time.Second},
 		RenewDeadline:     metav1.Duration{Duration: 10 * time.Second},
@@ -42,13 +62,20 @@ var controllerCmd = &cobra.Command{
 	Long:  `Runs the CSI controller plugin.`,
 	PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
 		err := validation.ValidateLeaderElectionConfiguration(&leaderElectionConfig, field.NewPath("leaderElect")).ToAggregate()
+		if err != nil {
+			return err
+		}
+		// the reconciler only deletes orphans after a grace period, so they're never deleted without one
+		if deleteOrphans && orphanGracePeriod <= 0 {
+			return fmt.Errorf("--orphan-grace-period must be positive when --delete-orphans is set")
+		}
 
-		return err
+		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		if !leaderElectionConfig.LeaderElect {
 			klog.Info("leader election is disabled, starting gRPC server directly")
-			runServer()
+			runServer(context.Background())
 			return
 		}
 
@@ -82,7 +109,7 @@ var controllerCmd = &cobra.Command{
 			Callbacks: leaderelection.LeaderCallbacks{
 				OnStartedLeading: func(ctx context.Context) {
 					klog.Info("became leader, starting gRPC server")
-					runServer()
+					runServer(ctx)
 				},
 				OnStoppedLeading: func() {
 					klog.Info("stopped leading")
@@ -101,19 +128,100 @@ var controllerCmd = &cobra.Command{
 	},
 }
 
-func runServer() {
+func runServer(ctx context.Context) {
 	lvmClient := lvm.NewLVM()
-	d := driver.NewDriver(controllerEndpoint, allowedVolumeGroups, lvmClient)
+	opts := []driver.Option{
+		driver.WithEventRecorder(newEventRecorder()),
+		driver.WithOverprovisionRatio(overprovisionRatio),
+		driver.WithThinPoolThresholds(dataThreshold, metadataThreshold),
+		driver.WithRaidMaintenance(raidScrubInterval, raidSparePVTag),
+		driver.WithTrash(trashTTL),
+	}
+	if deleteOrphans {
+		opts = append(opts, driver.WithOrphanDeletion(orphanGracePeriod))
+	}
+	d := driver.NewDriver(controllerEndpoint, allowedVolumeGroups, lvmClient, opts...)
+	if metricsAddress != "" {
+		go runMetricsServer()
+	}
+	if monitorInterval > 0 {
+		go d.RunThinPoolMonitor(ctx, monitorInterval)
+	}
+	if raidMonitorInterval > 0 {
+		go d.RunRaidMonitor(ctx, raidMonitorInterval)
+	}
+	if trashTTL > 0 && trashPurgeInterval > 0 {
+		go d.RunTrashPurger(ctx, trashPurgeInterval)
+	}
+	if orphanInterval > 0 {
+		if lister := newVolumeLister(); lister != nil {
+			go d.RunOrphanReconciler(ctx, orphanInterval, lister)
+		}
+	}
 	s := server.New(d, d, nil)
 	if err := s.Run(controllerEndpoint); err != nil {
 		klog.Fatalf("error running server: %v", err)
 	}
 }
 
+// newEventRecorder returns a recorder that publishes events to the Kubernetes API, or nil if it is not reachable
+func newEventRecorder() record.EventRecorder {
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		klog.ErrorS(err, "Failed to get Kubernetes config, events will not be recorded")
+		return nil
+	}
+	client, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		klog.ErrorS(err, "Failed to create Kubernetes client, events will not be recorded")
+		return nil
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "csi-shared-lvm-controller"})
+}
+
+// newVolumeLister returns a lister of the PersistentVolumes in the Kubernetes API, or nil if it is not reachable
+func newVolumeLister() driver.VolumeLister {
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		klog.ErrorS(err, "Failed to get Kubernetes config, orphaned volumes will not be reconciled")
+		return nil
+	}
+	client, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		klog.ErrorS(err, "Failed to create Kubernetes client, orphaned volumes will not be reconciled")
+		return nil
+	}
+	return driver.NewPersistentVolumeLister(client)
+}
+
+func runMetricsServer() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	klog.InfoS("Serving metrics", "address", metricsAddress)
+	if err := http.ListenAndServe(metricsAddress, mux); err != nil {
+		klog.Fatalf("error running metrics server: %v", err)
+	}
+}
+
 func init() {
 	var fs flag.FlagSet
 	controllerCmd.PersistentFlags().StringVar(&controllerEndpoint, "endpoint", "unix:///tmp/csi.sock", "The endpoint for the CSI driver.")
 	controllerCmd.PersistentFlags().StringSliceVar(&allowedVolumeGroups, "allowed-volume-groups", allowedVolumeGroups, "A comma-separated list of volume groups that the driver is allowed to use. If not specified, all volume groups are allowed.")
+	controllerCmd.PersistentFlags().StringVar(&metricsAddress, "metrics-address", "", "The address to serve Prometheus metrics on (e.g. ':8080'). If not specified, metrics are not served.")
+	controllerCmd.PersistentFlags().Float64Var(&overprovisionRatio, "overprovision-ratio", 0, "The default limit for the sum of virtual sizes of the volumes in a thin pool, as a multiple of the pool size. Can be overridden per StorageClass. 0 means no limit.")
+	controllerCmd.PersistentFlags().DurationVar(&monitorInterval, "thin-pool-monitor-interval", time.Minute, "How often to check the usage of thin pools. 0 disables the monitor.")
+	controllerCmd.PersistentFlags().Float64Var(&dataThreshold, "thin-pool-data-threshold", 80, "The thin pool data usage percentage above which a warning event is emitted. 0 disables the check.")
+	controllerCmd.PersistentFlags().Float64Var(&metadataThreshold, "thin-pool-metadata-threshold", 80, "The thin pool metadata usage percentage above which a warning event is emitted. 0 disables the check.")
+	controllerCmd.PersistentFlags().DurationVar(&raidMonitorInterval, "raid-monitor-interval", 5*time.Minute, "How often to check RAID volumes for failed images and due scrubs. 0 disables the monitor.")
+	controllerCmd.PersistentFlags().DurationVar(&raidScrubInterval, "raid-scrub-interval", 30*24*time.Hour, "How often each RAID volume is checked for mismatches between its images. 0 disables scrubbing.")
+	controllerCmd.PersistentFlags().StringVar(&raidSparePVTag, "raid-spare-pv-tag", "hot-spare", "The tag of the PVs that failed RAID images are replaced on. If empty, degraded RAID volumes are only reported.")
+	controllerCmd.PersistentFlags().DurationVar(&orphanInterval, "orphan-reconcile-interval", 10*time.Minute, "How often to look for volumes that no PersistentVolume refers to. 0 disables the reconciler.")
+	controllerCmd.PersistentFlags().DurationVar(&orphanGracePeriod, "orphan-grace-period", 24*time.Hour, "How long a volume has to be orphaned before it's deleted when --delete-orphans is set.")
+	controllerCmd.PersistentFlags().BoolVar(&deleteOrphans, "delete-orphans", false, "Delete volumes that were orphaned for longer than --orphan-grace-period. If not set, orphans are only reported.")
+	controllerCmd.PersistentFlags().DurationVar(&trashTTL, "trash-ttl", 0, "How long deleted volumes are kept in the trash, where they can be restored, before they're purged. 0 disables the trash and removes volumes right away.")
+	controllerCmd.PersistentFlags().DurationVar(&trashPurgeInterval, "trash-purge-interval", 10*time.Minute, "How often to purge the volumes whose time in the trash passed.")
 	options.BindLeaderElectionFlags(&leaderElectionConfig, controllerCmd.PersistentFlags())
 	ctrl.RegisterFlags(&fs)
 	controllerCmd.PersistentFlags().AddGoFlagSet(&fs)
@@ -10,7 +10,8 @@ import (
 )
 
 var (
-	nodeEndpoint string
+	nodeEndpoint            string
+	nodeAllowedVolumeGroups []string
 )
 
 var nodeCmd = &cobra.Command{
@@ -19,8 +20,11 @@ var nodeCmd = &cobra.Command{
 	Long:  `Runs the CSI node plugin.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		lvmClient := lvm.NewLVM()
-		d := driver.NewDriver(nodeEndpoint, nil, lvmClient)
+		d := driver.NewDriver(nodeEndpoint, nodeAllowedVolumeGroups, lvmClient)
 		s := server.New(d, nil, d)
+		if metricsAddress != "" {
+			go runMetricsServer()
+		}
 		if err := s.Run(nodeEndpoint); err != nil {
 			klog.Fatalf("error running server: %v", err)
 		}
@@ -29,5 +33,7 @@ var nodeCmd = &cobra.Command{
 
 func init() {
 	nodeCmd.PersistentFlags().StringVar(&nodeEndpoint, "endpoint", "unix:///tmp/csi.sock", "The endpoint for the CSI driver.")
+	nodeCmd.PersistentFlags().StringSliceVar(&nodeAllowedVolumeGroups, "allowed-volume-groups", nodeAllowedVolumeGroups, "A comma-separated list of volume groups that the node reports as accessible. If not specified, all volume groups visible to the node are reported.")
+	nodeCmd.PersistentFlags().StringVar(&metricsAddress, "metrics-address", "", "The address to serve Prometheus metrics on (e.g. ':9809'). If not specified, metrics are not served.")
 	rootCmd.AddCommand(nodeCmd)
 }
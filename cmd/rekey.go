@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/klog/v2"
+
+	"github.com/cienijr/csi-shared-lvm/pkg/driver"
+	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
+)
+
+var (
+	rekeyPassphraseFile         string
+	rekeyPreviousPassphraseFile string
+	rekeyAll                    bool
+	rekeyAllowedVolumeGroups    []string
+)
+
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey [volume-id...]",
+	Short: "Rotates the LUKS passphrase of encrypted volumes",
+	Long: `Rotates the LUKS passphrase of encrypted volumes, given by their volume ids (vg/lv) or with --all.
+A key slot is added for the new passphrase, checked to open the volume, and the key slot of the previous passphrase
+is removed. Volumes may stay staged on their nodes while they are rekeyed. Must run on a host with access to the
+volume groups, e.g. in the controller pod.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if rekeyAll == (len(args) > 0) {
+			return fmt.Errorf("either volume ids or --all must be given")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		passphrase, err := os.ReadFile(rekeyPassphraseFile)
+		if err != nil {
+			klog.Fatalf("failed to read passphrase: %v", err)
+		}
+		previous, err := os.ReadFile(rekeyPreviousPassphraseFile)
+		if err != nil {
+			klog.Fatalf("failed to read previous passphrase: %v", err)
+		}
+
+		d := driver.NewDriver("", rekeyAllowedVolumeGroups, lvm.NewLVM())
+		volumeIDs := args
+		if rekeyAll {
+			volumeIDs, err = d.ListEncryptedVolumes()
+			if err != nil {
+				klog.Fatalf("failed to list encrypted volumes: %v", err)
+			}
+		}
+
+		failed := 0
+		for _, volumeID := range volumeIDs {
+			if err := d.RekeyVolume(volumeID, previous, passphrase); err != nil {
+				klog.ErrorS(err, "Failed to rekey volume", "volumeId", volumeID)
+				failed++
+				continue
+			}
+			klog.InfoS("Rekeyed volume", "volumeId", volumeID)
+		}
+		if failed > 0 {
+			klog.Fatalf("failed to rekey %d of %d volumes", failed, len(volumeIDs))
+		}
+	},
+}
+
+func init() {
+	rekeyCmd.Flags().StringVar(&rekeyPassphraseFile, "passphrase-file", "", "The file holding the new passphrase, with the exact content of the 'passphrase' Secret key.")
+	rekeyCmd.Flags().StringVar(&rekeyPreviousPassphraseFile, "previous-passphrase-file", "", "The file holding the passphrase that is replaced.")
+	rekeyCmd.Flags().BoolVar(&rekeyAll, "all", false, "Rekey all encrypted volumes in the allowed volume groups.")
+	rekeyCmd.Flags().StringSliceVar(&rekeyAllowedVolumeGroups, "allowed-volume-groups", rekeyAllowedVolumeGroups, "A comma-separated list of volume groups that the command is allowed to use. If not specified, all volume groups are allowed.")
+	_ = rekeyCmd.MarkFlagRequired("passphrase-file")
+	_ = rekeyCmd.MarkFlagRequired("previous-passphrase-file")
+	rootCmd.AddCommand(rekeyCmd)
+}
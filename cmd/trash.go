@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog/v2"
+
+	"github.com/cienijr/csi-shared-lvm/pkg/driver"
+	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
+)
+
+var (
+	trashAllowedVolumeGroups []string
+	trashPurgeAll            bool
+)
+
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "Manages the volumes in the trash",
+	Long: `Manages the volumes that were deleted while the trash of the controller was enabled (--trash-ttl), until they're
+purged. Must run on a host with access to the volume groups, e.g. in the controller pod.`,
+}
+
+var trashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the volumes in the trash",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		d := driver.NewDriver("", trashAllowedVolumeGroups, lvm.NewLVM())
+		volumes, err := d.ListTrashedVolumes()
+		if err != nil {
+			klog.Fatalf("failed to list trashed volumes: %v", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "VOLUME ID\tNAME\tSIZE\tDELETED")
+		for _, volume := range volumes {
+			size := resource.NewQuantity(volume.Size, resource.BinarySI)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", volume.VolumeID, volume.Name, size, volume.DeletedAt.UTC().Format(time.RFC3339))
+		}
+		_ = w.Flush()
+	},
+}
+
+var trashRestoreCmd = &cobra.Command{
+	Use:   "restore volume-id [name]",
+	Short: "Restores a volume from the trash",
+	Long: `Restores a volume from the trash, given by its id in the trash (vg/lv), under the given LV name or the one it had
+before it was deleted. The restored volume can then be used by a new PersistentVolume whose volumeHandle is the
+printed volume id.`,
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var name string
+		if len(args) > 1 {
+			name = args[1]
+		}
+
+		d := driver.NewDriver("", trashAllowedVolumeGroups, lvm.NewLVM())
+		volumeID, err := d.RestoreVolume(args[0], name)
+		if err != nil {
+			klog.Fatalf("failed to restore volume: %v", err)
+		}
+		klog.InfoS("Restored volume", "trashedVolumeId", args[0], "volumeId", volumeID)
+		fmt.Println(volumeID)
+	},
+}
+
+var trashPurgeCmd = &cobra.Command{
+	Use:   "purge [volume-id...]",
+	Short: "Removes volumes from the trash for good",
+	Long:  `Removes volumes from the trash for good, given by their ids in the trash (vg/lv) or with --all.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if trashPurgeAll == (len(args) > 0) {
+			return fmt.Errorf("either volume ids or --all must be given")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		d := driver.NewDriver("", trashAllowedVolumeGroups, lvm.NewLVM())
+		volumeIDs := args
+		if trashPurgeAll {
+			volumes, err := d.ListTrashedVolumes()
+			if err != nil {
+				klog.Fatalf("failed to list trashed volumes: %v", err)
+			}
+			volumeIDs = nil
+			for _, volume := range volumes {
+				volumeIDs = append(volumeIDs, volume.VolumeID)
+			}
+		}
+
+		failed := 0
+		for _, volumeID := range volumeIDs {
+			if err := d.PurgeTrashedVolume(volumeID); err != nil {
+				klog.ErrorS(err, "Failed to purge volume", "volumeId", volumeID)
+				failed++
+				continue
+			}
+			klog.InfoS("Purged volume", "volumeId", volumeID)
+		}
+		if failed > 0 {
+			klog.Fatalf("failed to purge %d of %d volumes", failed, len(volumeIDs))
+		}
+	},
+}
+
+func init() {
+	trashCmd.PersistentFlags().StringSliceVar(&trashAllowedVolumeGroups, "allowed-volume-groups", trashAllowedVolumeGroups, "A comma-separated list of volume groups that the command is allowed to use. If not specified, all volume groups are allowed.")
+	trashPurgeCmd.Flags().BoolVar(&trashPurgeAll, "all", false, "Purge all volumes in the trash of the allowed volume groups.")
+	trashCmd.AddCommand(trashListCmd, trashRestoreCmd, trashPurgeCmd)
+	rootCmd.AddCommand(trashCmd)
+}
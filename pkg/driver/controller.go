@@ -3,18 +3,93 @@ package driver
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/klog/v2"
 
 	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
 )
 
 const (
-	volumeGroupKey = "volumeGroup"
+	volumeGroupKey            = "volumeGroup"
+	volumeGroupsKey           = "volumeGroups"
+	placementKey              = "placement"
+	thinPoolKey               = "thinPool"
+	lvTypeKey                 = "lvType"
+	stripesKey                = "stripes"
+	stripeSizeKey             = "stripeSize"
+	pvTagsKey                 = "pvTags"
+	allocPolicyKey            = "allocPolicy"
+	cachePVTagsKey            = "cachePVTags"
+	cacheSizeKey              = "cacheSize"
+	vdoCompressionKey         = "vdoCompression"
+	vdoDeduplicationKey       = "vdoDeduplication"
+	vdoPhysicalSizePercentKey = "vdoPhysicalSizePercent"
+	snapshotSizePercentKey    = "snapshotSizePercent"
+	overprovisionRatioKey     = "overprovisionRatio"
+	encryptedKey              = "encrypted"
+	integrityKey              = "integrity"
+
+	// mutable parameters, set through a VolumeAttributesClass
+	tagsKey      = "tags"
+	readAheadKey = "readAhead"
+	cacheModeKey = "cacheMode"
+	raidTypeKey  = "raidType"
+	mirrorsKey   = "mirrors"
+	// encryptionKeyIDKey identifies the passphrase of an encrypted volume, changing it rotates the LUKS key
+	encryptionKeyIDKey = "encryptionKeyID"
+
+	// overprovisionRatioTagPrefix keeps the ratio a thin volume was created with, so that it also applies on expansion
+	overprovisionRatioTagPrefix = lvm.OwnershipTag + "/overprovision-ratio="
+	// stripesTagPrefix and stripeSizeTagPrefix keep the geometry of a striped volume, so that it's also extended striped
+	stripesTagPrefix    = lvm.OwnershipTag + "/stripes="
+	stripeSizeTagPrefix = lvm.OwnershipTag + "/stripe-size="
+	// pvTagTagPrefix and allocPolicyTagPrefix keep the allocation constraints of a volume, so that it's also extended
+	// on the same PVs
+	pvTagTagPrefix       = lvm.OwnershipTag + "/pv-tag="
+	allocPolicyTagPrefix = lvm.OwnershipTag + "/alloc-policy="
+	// cacheModeTagPrefix keeps the mode of the cache of a volume, so that it can be attached again after an expansion
+	cacheModeTagPrefix = lvm.OwnershipTag + "/cache-mode="
+	// encryptedTag marks volumes that NodeStageVolume has to open with LUKS
+	encryptedTag = lvm.OwnershipTag + "/encrypted"
+	// integrityTag marks volumes with a standalone dm-integrity layer, which NodeStageVolume has to open
+	integrityTag = lvm.OwnershipTag + "/integrity"
+	// raidIntegrityTag marks RAID volumes with integrity, which can't be checked by the RAID monitor
+	raidIntegrityTag = lvm.OwnershipTag + "/raid-integrity"
+	// encryptionKeyIDTagPrefix keeps the id of the passphrase an encrypted volume was last rotated to
+	encryptionKeyIDTagPrefix = lvm.OwnershipTag + "/encryption-key-id="
+	// publishedNodeTagPrefix records each node a volume is published to
+	publishedNodeTagPrefix = lvm.OwnershipTag + "/published-node="
+
+	// publishedNodeKey is set in the publish context, so that NodeStageVolume can check it runs on the published node
+	publishedNodeKey = "publishedNode"
+	// passphraseKey is the node stage and node expand secret holding the LUKS passphrase of encrypted volumes
+	passphraseKey = "passphrase"
+	// previousPassphraseKey holds the passphrase that is replaced while the key of a volume is rotated
+	previousPassphraseKey = "previousPassphrase"
+	// luksDiskFormat and integrityDiskFormat are the types blkid reports for LUKS and dm-integrity devices
+	luksDiskFormat      = "crypto_LUKS"
+	integrityDiskFormat = "DM_integrity"
+
+	defaultSnapshotSizePercent = 100
+	// VDO pools are as large as their volume by default, so that they can't run out of space without any saving
+	defaultVDOPhysicalSizePercent = 100
+
+	// placement policies, choosing among the volume groups of a multi-VG StorageClass
+	placementMostFree   = "most-free"
+	placementRoundRobin = "round-robin"
+	placementFirstFit   = "first-fit"
 )
 
 func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
@@ -27,72 +102,949 @@ func (d *Driver) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest)
 	if req.VolumeCapabilities == nil || len(req.VolumeCapabilities) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "volume capabilities are required")
 	}
-	if req.CapacityRange == nil {
-		return nil, status.Error(codes.InvalidArgument, "capacity range is required")
+	if req.CapacityRange == nil {
+		return nil, status.Error(codes.InvalidArgument, "capacity range is required")
+	}
+
+	params := req.GetParameters()
+	opts, err := parseLayoutParameters(params)
+	if err != nil {
+		return nil, err
+	}
+	cacheOpts, cacheSize, err := parseCacheParameters(params)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := parseEncrypted(params)
+	if err != nil {
+		return nil, err
+	}
+	vg, err := d.selectVolumeGroup(lvName, req, opts)
+	if err != nil {
+		return nil, err
+	}
+	vgName := vg.Name
+	_, dataStripes := parallelAreas(opts)
+	size, err := alignVolumeSize(vg, req.GetCapacityRange(), dataStripes)
+	if err != nil {
+		return nil, err
+	}
+	cacheSize, err = alignVolumeSize(vg, &csi.CapacityRange{RequiredBytes: cacheSize}, 1)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Type == "vdo" {
+		opts.VDOPool = vdoPoolName(lvName)
+		opts.VDOPhysicalSize = physicalSize(vg, size, params)
+	}
+
+	tags := []string{
+		lvm.OwnershipTag,
+	}
+	tags = append(tags, layoutTags(opts)...)
+	if cacheOpts.Mode != "" {
+		tags = append(tags, cacheModeTagPrefix+cacheOpts.Mode)
+	}
+	if encrypted {
+		tags = append(tags, encryptedTag)
+	}
+	// RAID LVs get integrity from LVM, any other LV gets a dm-integrity layer of its own. Validated by
+	// parseLayoutParameters.
+	integrity, _ := parseIntegrity(params)
+	standaloneIntegrity := integrity && !opts.RaidIntegrity
+	if standaloneIntegrity {
+		tags = append(tags, integrityTag)
+	}
+	if opts.RaidIntegrity {
+		tags = append(tags, raidIntegrityTag)
+	}
+	var pool *lvm.LogicalVolume
+	overprovisionRatio := d.overprovisionRatio
+	if opts.ThinPool != "" {
+		pool, err = d.getThinPool(vgName, opts.ThinPool)
+		if err != nil {
+			return nil, err
+		}
+		overprovisionRatio, err = parseOverprovisionRatio(params, d.overprovisionRatio)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := params[overprovisionRatioKey]; ok {
+			tags = append(tags, overprovisionRatioTagPrefix+strconv.FormatFloat(overprovisionRatio, 'f', -1, 64))
+		}
+	}
+
+	modification, err := parseMutableParameters(req.GetMutableParameters())
+	if err != nil {
+		return nil, err
+	}
+	// new volumes are formatted with the current passphrase, so there's nothing to rotate
+	if modification.encryptionKeyID != "" {
+		if !encrypted {
+			return nil, status.Errorf(codes.InvalidArgument, "parameter '%s' requires an encrypted volume", encryptionKeyIDKey)
+		}
+		tags = append(tags, encryptionKeyIDTagPrefix+modification.encryptionKeyID)
+	}
+
+	var sourceLV *lvm.LogicalVolume
+	if contentSource := req.GetVolumeContentSource(); contentSource != nil {
+		var err error
+		sourceLV, err = d.getContentSourceLV(contentSource)
+		if err != nil {
+			return nil, err
+		}
+		if sourceSize := contentSize(sourceLV); size < sourceSize {
+			return nil, status.Errorf(codes.OutOfRange, "requested size %d is smaller than the content source size %d", size, sourceSize)
+		}
+		// copies keep the LUKS header of their source, so they can only be opened as encrypted volumes
+		if sourceLV.HasTag(encryptedTag) && !encrypted {
+			return nil, status.Errorf(codes.InvalidArgument, "content source '%s/%s' is encrypted, so the volume must be encrypted too", sourceLV.VG, sourceLV.Name)
+		}
+		// the same goes for the dm-integrity superblock, which is only opened on volumes with a standalone layer.
+		// Snapshots carry these tags of their origin, see CreateSnapshot.
+		if sourceLV.HasTag(integrityTag) != standaloneIntegrity {
+			return nil, status.Errorf(codes.InvalidArgument, "content source '%s/%s' and the volume must both have or lack a standalone integrity layer", sourceLV.VG, sourceLV.Name)
+		}
+	}
+
+	lv, err := d.lvm.GetLV(vgName, lvName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get lv: %v", err)
+	}
+
+	// a volume that is still being populated was left behind by a failed attempt, so we resume it
+	if lv != nil && !lv.HasTag(lvm.PopulatingTag) {
+		// idempotency
+		limit := req.GetCapacityRange().GetLimitBytes()
+		if lv.Size < size || (limit != 0 && lv.Size > limit) {
+			return nil, status.Errorf(codes.AlreadyExists, "lv '%s' already exists but with a different size", lvName)
+		}
+		// a previous attempt may have failed to attach the cache, in which case we do it now
+		if cacheOpts.Mode == "" || lv.Attr.IsCached() {
+			klog.InfoS("LV already exists and is large enough, returning success", "vg", vgName, "lv", lvName)
+			// a previous attempt may also have failed to deactivate the pool, which only affects this node
+			if pool != nil {
+				if err := d.deactivateThinVolume(vgName, lvName, pool.Name); err != nil {
+					return nil, err
+				}
+			}
+			return &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					VolumeId:           fmt.Sprintf("%s/%s", vgName, lvName),
+					CapacityBytes:      lv.Size,
+					ContentSource:      req.GetVolumeContentSource(),
+					AccessibleTopology: volumeGroupTopology(vgName),
+				},
+			}, nil
+		}
+	}
+
+	// thin sources can be cloned instantly into their own pool, everything else has to be copied
+	thinClone := sourceLV != nil && sourceLV.Pool != "" && sourceLV.VG == vgName && sourceLV.Pool == opts.ThinPool
+
+	// the thin pools are activated on this node to create the volume in them or to copy from them
+	if pool != nil {
+		if err := d.checkThinPoolUnpublished(pool.VG, pool.Name); err != nil {
+			return nil, err
+		}
+	}
+	if sourceLV != nil && sourceLV.Pool != "" && sourceLV.Attr.IsThinVolume() && (pool == nil || sourceLV.VG != pool.VG || sourceLV.Pool != pool.Name) {
+		if err := d.checkThinPoolUnpublished(sourceLV.VG, sourceLV.Pool); err != nil {
+			return nil, err
+		}
+	}
+
+	if lv == nil && pool != nil {
+		if err := d.checkOverprovisioning(pool, overprovisionRatio, size); err != nil {
+			return nil, err
+		}
+	} else if lv == nil {
+		maxSize, err := d.maxVolumeSize(vg, opts)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get free space of vg: %v", err)
+		}
+		if required := physicalSize(vg, size, params); required > maxSize {
+			return nil, status.Errorf(codes.ResourceExhausted, "volume group '%s' does not have enough free extents: %d bytes requested, %d bytes available", vgName, required, maxSize)
+		}
+		if cacheOpts.Mode != "" {
+			cacheSpace, err := d.availableCapacity(vg, lvm.LVOptions{PVTags: cacheOpts.PVTags})
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to get free space of vg: %v", err)
+			}
+			if cacheSize > cacheSpace {
+				return nil, status.Errorf(codes.ResourceExhausted, "pvs with tags '%s' do not have enough free extents for the cache: %d bytes requested, %d bytes available", strings.Join(cacheOpts.PVTags, ","), cacheSize, cacheSpace)
+			}
+		}
+	}
+
+	if lv == nil && thinClone {
+		if err := d.createThinClone(sourceLV, lvName, size, tags); err != nil {
+			return nil, err
+		}
+	} else if lv == nil {
+		klog.InfoS("Creating new LV", "vg", vgName, "lv", lvName, "size", size)
+		if sourceLV != nil {
+			tags = append(tags, lvm.PopulatingTag)
+		}
+		if err := d.lvm.CreateLV(vgName, lvName, size, tags, opts); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to create lv: %v", err)
+		}
+	}
+
+	if cacheOpts.Mode != "" && (lv == nil || !lv.Attr.IsCached()) {
+		if err := d.attachCache(vgName, lvName, cacheSize, cacheOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	if sourceLV != nil && !thinClone && (lv == nil || lv.HasTag(lvm.PopulatingTag)) {
+		if err := d.populateVolume(sourceLV, vgName, lvName); err != nil {
+			return nil, err
+		}
+	}
+
+	// actual volume size may be higher than requested, since LVM rounds up to 4MiB sectors
+	actualLV, err := d.lvm.GetLV(vgName, lvName)
+	if err != nil || actualLV == nil {
+		return nil, status.Errorf(codes.Internal, "failed to get lv after creation: %v", err)
+	}
+
+	if err := d.modifyVolume(actualLV, modification, req.GetSecrets()); err != nil {
+		return nil, err
+	}
+
+	if pool != nil {
+		if err := d.deactivateThinVolume(vgName, lvName, pool.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	actualSize := actualLV.Size
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:           fmt.Sprintf("%s/%s", vgName, lvName),
+			CapacityBytes:      actualSize,
+			ContentSource:      req.GetVolumeContentSource(),
+			AccessibleTopology: volumeGroupTopology(vgName),
+		},
+	}, nil
+}
+
+// selectVolumeGroup returns the volume group a volume is created in: the one set by the StorageClass, or one of its
+// volume groups chosen by the placement policy
+func (d *Driver) selectVolumeGroup(lvName string, req *csi.CreateVolumeRequest, opts lvm.LVOptions) (*lvm.VolumeGroup, error) {
+	params := req.GetParameters()
+	vgName, single := params[volumeGroupKey]
+	vgList, multiple := params[volumeGroupsKey]
+	if single == multiple {
+		return nil, status.Errorf(codes.InvalidArgument, "exactly one of parameters '%s' and '%s' is required", volumeGroupKey, volumeGroupsKey)
+	}
+
+	if single {
+		if !d.isVolumeGroupAllowed(vgName) {
+			return nil, status.Errorf(codes.InvalidArgument, "volume group '%s' is not allowed", vgName)
+		}
+		if !isVolumeGroupAccessible(vgName, req.GetAccessibilityRequirements()) {
+			return nil, status.Errorf(codes.ResourceExhausted, "volume group '%s' is not accessible from the requested topology", vgName)
+		}
+		vg, err := d.lvm.GetVG(vgName)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get vg: %v", err)
+		}
+		if vg == nil {
+			return nil, status.Errorf(codes.InvalidArgument, "volume group '%s' not found", vgName)
+		}
+		return vg, nil
+	}
+
+	if _, ok := params[thinPoolKey]; ok {
+		return nil, status.Errorf(codes.InvalidArgument, "parameter '%s' can't be used with '%s'", thinPoolKey, volumeGroupsKey)
+	}
+	placement := params[placementKey]
+	if placement == "" {
+		placement = placementMostFree
+	}
+	if placement != placementMostFree && placement != placementRoundRobin && placement != placementFirstFit {
+		return nil, status.Errorf(codes.InvalidArgument, "parameter '%s' must be one of %s, %s or %s", placementKey, placementMostFree, placementRoundRobin, placementFirstFit)
+	}
+
+	var candidates []*lvm.VolumeGroup
+	for _, name := range parseList(vgList) {
+		if !d.isVolumeGroupAllowed(name) || !isVolumeGroupAccessible(name, req.GetAccessibilityRequirements()) {
+			continue
+		}
+		vg, err := d.lvm.GetVG(name)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get vg '%s': %v", name, err)
+		}
+		if vg == nil {
+			klog.InfoS("Volume group not found, skipping", "vg", name)
+			continue
+		}
+		// a retried request must land in the VG where a previous attempt already created the volume
+		lv, err := d.lvm.GetLV(name, lvName)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get lv: %v", err)
+		}
+		if lv != nil {
+			return vg, nil
+		}
+		candidates = append(candidates, vg)
+	}
+	if len(candidates) == 0 {
+		return nil, status.Errorf(codes.ResourceExhausted, "none of the volume groups '%s' is allowed and accessible from the requested topology", vgList)
+	}
+
+	_, dataStripes := parallelAreas(opts)
+	var fitting []*lvm.VolumeGroup
+	for _, vg := range candidates {
+		size, err := alignVolumeSize(vg, req.GetCapacityRange(), dataStripes)
+		if err != nil {
+			continue
+		}
+		maxSize, err := d.maxVolumeSize(vg, opts)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get free space of vg '%s': %v", vg.Name, err)
+		}
+		if physicalSize(vg, size, params) <= maxSize {
+			fitting = append(fitting, vg)
+		}
+	}
+	if len(fitting) == 0 {
+		return nil, status.Errorf(codes.ResourceExhausted, "none of the volume groups '%s' has enough free extents for %d bytes", vgList, req.GetCapacityRange().GetRequiredBytes())
+	}
+
+	selected := fitting[0]
+	switch placement {
+	case placementMostFree:
+		for _, vg := range fitting {
+			if vg.FreeSize > selected.FreeSize {
+				selected = vg
+			}
+		}
+	case placementRoundRobin:
+		selected = fitting[d.nextPlacement(vgList)%len(fitting)]
+	}
+	klog.InfoS("Selected volume group", "lv", lvName, "vg", selected.Name, "placement", placement)
+	return selected, nil
+}
+
+// nextPlacement returns the round-robin position of the next volume created with the given list of volume groups
+func (d *Driver) nextPlacement(vgList string) int {
+	d.placementLock.Lock()
+	defer d.placementLock.Unlock()
+	if d.placementCounters == nil {
+		d.placementCounters = make(map[string]int)
+	}
+	next := d.placementCounters[vgList]
+	d.placementCounters[vgList] = next + 1
+	return next
+}
+
+// parseList splits a comma-separated StorageClass parameter, e.g. a list of volume groups
+func parseList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseLayoutParameters returns how the LV of a new volume is allocated, according to its StorageClass
+func parseLayoutParameters(params map[string]string) (lvm.LVOptions, error) {
+	opts := lvm.LVOptions{
+		ThinPool: params[thinPoolKey],
+		Type:     params[lvTypeKey],
+	}
+
+	switch opts.Type {
+	case "":
+		if _, ok := params[mirrorsKey]; ok {
+			return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' requires '%s'", mirrorsKey, lvTypeKey)
+		}
+	case "raid1", "raid5", "raid6", "raid10", "vdo":
+		if opts.ThinPool != "" {
+			return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' can't be used with '%s'", lvTypeKey, thinPoolKey)
+		}
+	default:
+		return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' must be one of raid1, raid5, raid6, raid10 or vdo", lvTypeKey)
+	}
+
+	if opts.Type == "vdo" {
+		// LVM enables both by default
+		opts.VDOCompression, opts.VDODeduplication = true, true
+		for key, value := range map[string]*bool{vdoCompressionKey: &opts.VDOCompression, vdoDeduplicationKey: &opts.VDODeduplication} {
+			if param, ok := params[key]; ok {
+				enabled, err := strconv.ParseBool(param)
+				if err != nil {
+					return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' must be a boolean", key)
+				}
+				*value = enabled
+			}
+		}
+		if _, err := parseVDOPhysicalSizePercent(params); err != nil {
+			return opts, err
+		}
+	} else {
+		for _, key := range []string{vdoCompressionKey, vdoDeduplicationKey, vdoPhysicalSizePercentKey} {
+			if _, ok := params[key]; ok {
+				return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' requires '%s' vdo", key, lvTypeKey)
+			}
+		}
+	}
+
+	// mirrors shares its name with the mutable parameter, but here it sets the initial layout
+	if value, ok := params[mirrorsKey]; ok {
+		mirrors, err := strconv.Atoi(value)
+		if err != nil || mirrors < 1 {
+			return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' must be a positive integer", mirrorsKey)
+		}
+		if opts.Type != "raid1" && opts.Type != "raid10" {
+			return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' is only supported by raid1 and raid10", mirrorsKey)
+		}
+		opts.Mirrors = mirrors
+	}
+	integrity, err := parseIntegrity(params)
+	if err != nil {
+		return opts, err
+	}
+	opts.RaidIntegrity = integrity && strings.HasPrefix(opts.Type, "raid")
+	if value, ok := params[stripesKey]; ok {
+		if opts.Type == "raid1" || opts.Type == "vdo" {
+			return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' is not supported by %s", stripesKey, opts.Type)
+		}
+		if opts.ThinPool != "" {
+			return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' can't be used with '%s'", stripesKey, thinPoolKey)
+		}
+		stripes, err := strconv.Atoi(value)
+		if err != nil || stripes < minStripes(opts.Type) {
+			return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' must be an integer of at least %d", stripesKey, minStripes(opts.Type))
+		}
+		opts.Stripes = stripes
+	}
+	if value, ok := params[stripeSizeKey]; ok {
+		if opts.Stripes == 0 && (opts.Type == "" || minStripes(opts.Type) == 0) {
+			return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' requires a striped volume", stripeSizeKey)
+		}
+		quantity, err := resource.ParseQuantity(value)
+		stripeSize := quantity.Value()
+		if err != nil || stripeSize < 4096 || stripeSize&(stripeSize-1) != 0 {
+			return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' must be a power of two of at least 4Ki", stripeSizeKey)
+		}
+		opts.StripeSize = stripeSize
+	}
+
+	if value, ok := params[pvTagsKey]; ok {
+		if opts.ThinPool != "" {
+			return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' can't be used with '%s'", pvTagsKey, thinPoolKey)
+		}
+		opts.PVTags = parseList(value)
+		for _, tag := range opts.PVTags {
+			if !userTagPattern.MatchString(tag) {
+				return opts, status.Errorf(codes.InvalidArgument, "invalid pv tag '%s'", tag)
+			}
+		}
+		// each image must be able to land on PVs with a tag of its own
+		if images, _ := parallelAreas(opts); opts.Type != "" && len(opts.PVTags) < images {
+			return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' needs at least %d tags to place each of the %d images of a %s volume on distinct PVs", pvTagsKey, images, images, opts.Type)
+		}
+	}
+	if value, ok := params[allocPolicyKey]; ok {
+		if opts.ThinPool != "" {
+			return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' can't be used with '%s'", allocPolicyKey, thinPoolKey)
+		}
+		if value != "contiguous" && value != "cling" && value != "normal" && value != "anywhere" {
+			return opts, status.Errorf(codes.InvalidArgument, "parameter '%s' must be one of contiguous, cling, normal or anywhere", allocPolicyKey)
+		}
+		opts.AllocPolicy = value
+	}
+	return opts, nil
+}
+
+// minStripes returns the minimum number of data stripes of an LV type, or 0 if it can't be striped
+func minStripes(lvType string) int {
+	switch lvType {
+	case "", "raid5", "raid10":
+		return 2
+	case "raid6":
+		return 3
+	default:
+		return 0
+	}
+}
+
+// parallelAreas returns the number of areas of an LV that must be allocated on distinct PVs, i.e. its RAID images or
+// stripes, and how many of them hold distinct data. LVM defaults apply to unset mirrors and stripes.
+func parallelAreas(opts lvm.LVOptions) (areas, dataStripes int) {
+	mirrors := max(opts.Mirrors, 1)
+	stripes := max(opts.Stripes, minStripes(opts.Type))
+	switch opts.Type {
+	case "raid1":
+		return mirrors + 1, 1
+	case "raid5":
+		return stripes + 1, stripes
+	case "raid6":
+		return stripes + 2, stripes
+	case "raid10":
+		return stripes * (mirrors + 1), stripes
+	default:
+		return max(opts.Stripes, 1), max(opts.Stripes, 1)
+	}
+}
+
+// layoutTags returns the tags keeping the parts of a layout that lvextend doesn't preserve by itself: the geometry of
+// a striped volume and the allocation constraints of any volume
+func layoutTags(opts lvm.LVOptions) []string {
+	var tags []string
+	if opts.Type == "" && opts.Stripes > 0 {
+		tags = append(tags, stripesTagPrefix+strconv.Itoa(opts.Stripes))
+		if opts.StripeSize > 0 {
+			tags = append(tags, stripeSizeTagPrefix+strconv.FormatInt(opts.StripeSize, 10))
+		}
+	}
+	for _, tag := range opts.PVTags {
+		tags = append(tags, pvTagTagPrefix+tag)
+	}
+	if opts.AllocPolicy != "" {
+		tags = append(tags, allocPolicyTagPrefix+opts.AllocPolicy)
+	}
+	return tags
+}
+
+// layoutFromTags returns the layout a volume is extended with, as kept by layoutTags when it was created
+func layoutFromTags(lv *lvm.LogicalVolume) lvm.LVOptions {
+	var opts lvm.LVOptions
+	for _, tag := range lv.Tags {
+		if value, ok := strings.CutPrefix(tag, stripesTagPrefix); ok {
+			opts.Stripes, _ = strconv.Atoi(value)
+		}
+		if value, ok := strings.CutPrefix(tag, stripeSizeTagPrefix); ok {
+			opts.StripeSize, _ = strconv.ParseInt(value, 10, 64)
+		}
+		if value, ok := strings.CutPrefix(tag, pvTagTagPrefix); ok {
+			opts.PVTags = append(opts.PVTags, value)
+		}
+		if value, ok := strings.CutPrefix(tag, allocPolicyTagPrefix); ok {
+			opts.AllocPolicy = value
+		}
+	}
+	// lvextend keeps the RAID level, but the images must still be kept on PVs with distinct tags
+	if lv.Attr.IsRaid() {
+		opts.Type = "raid"
+	}
+	return opts
+}
+
+// parseCacheParameters returns the cache of a new volume and its size, according to its StorageClass. The mode of the
+// returned options is empty if the volume isn't cached.
+func parseCacheParameters(params map[string]string) (lvm.CacheOptions, int64, error) {
+	var opts lvm.CacheOptions
+	value, ok := params[cacheSizeKey]
+	if !ok {
+		for _, key := range []string{cachePVTagsKey, cacheModeKey} {
+			if _, ok := params[key]; ok {
+				return opts, 0, status.Errorf(codes.InvalidArgument, "parameter '%s' requires '%s'", key, cacheSizeKey)
+			}
+		}
+		return opts, 0, nil
+	}
+	if params[thinPoolKey] != "" {
+		return opts, 0, status.Errorf(codes.InvalidArgument, "parameter '%s' can't be used with '%s'", cacheSizeKey, thinPoolKey)
+	}
+	if params[lvTypeKey] == "vdo" {
+		return opts, 0, status.Errorf(codes.InvalidArgument, "parameter '%s' can't be used with '%s' vdo", cacheSizeKey, lvTypeKey)
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil || quantity.Value() <= 0 {
+		return opts, 0, status.Errorf(codes.InvalidArgument, "parameter '%s' must be a positive quantity", cacheSizeKey)
+	}
+
+	// the cache is only worth it on faster PVs than the ones of the volume
+	opts.PVTags = parseList(params[cachePVTagsKey])
+	if len(opts.PVTags) == 0 {
+		return opts, 0, status.Errorf(codes.InvalidArgument, "parameter '%s' requires '%s'", cacheSizeKey, cachePVTagsKey)
+	}
+	for _, tag := range opts.PVTags {
+		if !userTagPattern.MatchString(tag) {
+			return opts, 0, status.Errorf(codes.InvalidArgument, "invalid pv tag '%s'", tag)
+		}
+	}
+
+	// cacheMode shares its name with the mutable parameter, but here it also chooses between dm-cache and dm-writecache
+	opts.Mode = params[cacheModeKey]
+	switch opts.Mode {
+	case "":
+		opts.Mode = "writethrough"
+	case "writethrough", "writeback", "writecache":
+	default:
+		return opts, 0, status.Errorf(codes.InvalidArgument, "parameter '%s' must be one of writethrough, writeback or writecache", cacheModeKey)
+	}
+	return opts, quantity.Value(), nil
+}
+
+// cacheLVName returns the name of the LV caching a volume, which is only visible while it's detached
+func cacheLVName(lvName string) string {
+	return lvName + "_cache"
+}
+
+// cacheModeFromTags returns the mode of the cache of a volume, or an empty string if it isn't cached
+func cacheModeFromTags(lv *lvm.LogicalVolume) string {
+	for _, tag := range lv.Tags {
+		if value, ok := strings.CutPrefix(tag, cacheModeTagPrefix); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// attachCache caches a volume, creating its cache LV unless a previous attempt already did. The cache LV must exist
+// if size is 0.
+func (d *Driver) attachCache(vgName, lvName string, size int64, opts lvm.CacheOptions) error {
+	cacheName := cacheLVName(lvName)
+	cache, err := d.lvm.GetLV(vgName, cacheName)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get cache lv: %v", err)
+	}
+	if cache == nil {
+		if size == 0 {
+			return status.Errorf(codes.Internal, "cache lv '%s' of volume '%s/%s' not found", cacheName, vgName, lvName)
+		}
+		klog.InfoS("Creating cache LV", "vg", vgName, "lv", cacheName, "size", size, "mode", opts.Mode)
+		if err := d.lvm.CreateCacheLV(vgName, cacheName, size, opts); err != nil {
+			return status.Errorf(codes.Internal, "failed to create cache lv: %v", err)
+		}
+	}
+	klog.InfoS("Attaching cache", "vg", vgName, "lv", lvName, "cache", cacheName, "mode", opts.Mode)
+	if err := d.lvm.AttachCache(vgName, lvName, cacheName, opts); err != nil {
+		return status.Errorf(codes.Internal, "failed to attach cache: %v", err)
+	}
+	return nil
+}
+
+// parseVDOPhysicalSizePercent returns the physical size of the VDO pool of a volume, in percent of its logical size
+func parseVDOPhysicalSizePercent(params map[string]string) (int64, error) {
+	value, ok := params[vdoPhysicalSizePercentKey]
+	if !ok {
+		return defaultVDOPhysicalSizePercent, nil
+	}
+	percent, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || percent < 1 || percent > 100 {
+		return 0, status.Errorf(codes.InvalidArgument, "parameter '%s' must be an integer between 1 and 100", vdoPhysicalSizePercentKey)
+	}
+	return percent, nil
+}
+
+// parseIntegrity returns whether volumes of a StorageClass checksum their data with dm-integrity
+func parseIntegrity(params map[string]string) (bool, error) {
+	value, ok := params[integrityKey]
+	if !ok {
+		return false, nil
+	}
+	integrity, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "parameter '%s' must be a boolean", integrityKey)
+	}
+	return integrity, nil
+}
+
+// parseEncrypted returns whether volumes of a StorageClass are encrypted with LUKS
+func parseEncrypted(params map[string]string) (bool, error) {
+	value, ok := params[encryptedKey]
+	if !ok {
+		return false, nil
+	}
+	encrypted, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "parameter '%s' must be a boolean", encryptedKey)
+	}
+	return encrypted, nil
+}
+
+// physicalSize returns how much space a volume of the given size allocates in a volume group, which is less than its
+// size for VDO volumes
+func physicalSize(vg *lvm.VolumeGroup, size int64, params map[string]string) int64 {
+	if params[lvTypeKey] != "vdo" {
+		return size
+	}
+	// validated by parseLayoutParameters
+	percent, _ := parseVDOPhysicalSizePercent(params)
+	physical := size * percent / 100
+	if vg.ExtentSize > 0 {
+		physical = (physical + vg.ExtentSize - 1) / vg.ExtentSize * vg.ExtentSize
+	}
+	return physical
+}
+
+// vdoPoolName returns the name of the VDO pool backing a VDO volume
+func vdoPoolName(lvName string) string {
+	return lvName + "_vdopool"
+}
+
+// vdoCondition describes the physical usage of the VDO pool of a volume, which is abnormal once it's full since writes
+// then fail
+func vdoCondition(lv *lvm.LogicalVolume, pool *lvm.VDOPool) *csi.VolumeCondition {
+	if pool == nil {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("vdo pool '%s' of lv '%s' not found", lv.Pool, lv.Name),
+		}
+	}
+	// the usage is only reported by LVM for pools that are active on this node
+	if !pool.UsageKnown {
+		return &csi.VolumeCondition{
+			Abnormal: false,
+			Message:  fmt.Sprintf("physical usage of vdo pool '%s' of lv '%s' is unknown, it is not active on this node", pool.Name, lv.Name),
+		}
+	}
+	usage := fmt.Sprintf("vdo pool '%s' uses %d of %d physical bytes for %d logical bytes, saving %.2f%%", pool.Name, pool.UsedSize, pool.Size, lv.Size, pool.SavingPercent)
+	if pool.UsedSize >= pool.Size {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("lv '%s' is out of physical space: %s", lv.Name, usage),
+		}
+	}
+	return &csi.VolumeCondition{
+		Abnormal: false,
+		Message:  usage,
+	}
+}
+
+// raidState describes the synchronization of the images of a RAID LV
+func raidState(lv *lvm.LogicalVolume) string {
+	// the sync state is only reported by LVM for LVs that are active on this node
+	if !lv.Attr.IsActive() {
+		return fmt.Sprintf("sync state of raid images of lv '%s' is unknown, it is not active on this node", lv.Name)
+	}
+	if lv.SyncPercent >= 100 {
+		return fmt.Sprintf("raid images of lv '%s' are in sync", lv.Name)
+	}
+	return fmt.Sprintf("raid images of lv '%s' are %.2f%% in sync, current action: %s", lv.Name, lv.SyncPercent, lv.RaidSyncAction)
+}
+
+// getThinPool returns the given thin pool, which must exist in the volume group
+func (d *Driver) getThinPool(vgName, poolName string) (*lvm.LogicalVolume, error) {
+	pool, err := d.lvm.GetLV(vgName, poolName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get thin pool: %v", err)
+	}
+	if pool == nil || !pool.Attr.IsThinPool() {
+		return nil, status.Errorf(codes.InvalidArgument, "thin pool '%s' not found in volume group '%s'", poolName, vgName)
+	}
+	return pool, nil
+}
+
+// checkOverprovisioning fails if growing the volumes of a thin pool by additional bytes would make the sum of their
+// virtual sizes exceed ratio times the pool size. A ratio of zero means no limit.
+func (d *Driver) checkOverprovisioning(pool *lvm.LogicalVolume, ratio float64, additional int64) error {
+	if ratio <= 0 {
+		return nil
+	}
+
+	virtualSize, err := d.thinVolumesSize(pool)
+	if err != nil {
+		return err
+	}
+
+	limit := int64(float64(pool.Size) * ratio)
+	if virtualSize+additional > limit {
+		return status.Errorf(codes.ResourceExhausted, "thin pool '%s/%s' would be overprovisioned: %d bytes requested, %d of %d bytes already allocated", pool.VG, pool.Name, additional, virtualSize, limit)
+	}
+	return nil
+}
+
+// thinVolumesSize returns the sum of the virtual sizes of the thin volumes of a pool
+func (d *Driver) thinVolumesSize(pool *lvm.LogicalVolume) (int64, error) {
+	volumes, err := d.lvm.ListThinVolumes(pool.VG, pool.Name)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "failed to list thin volumes: %v", err)
+	}
+	var virtualSize int64
+	for _, volume := range volumes {
+		virtualSize += volume.Size
+	}
+	return virtualSize, nil
+}
+
+// parseOverprovisionRatio returns the overprovisioning ratio of a StorageClass, or defaultRatio if it sets none
+func parseOverprovisionRatio(params map[string]string, defaultRatio float64) (float64, error) {
+	value, ok := params[overprovisionRatioKey]
+	if !ok {
+		return defaultRatio, nil
+	}
+	ratio, err := strconv.ParseFloat(value, 64)
+	if err != nil || ratio <= 0 {
+		return 0, status.Errorf(codes.InvalidArgument, "parameter '%s' must be a positive number", overprovisionRatioKey)
+	}
+	return ratio, nil
+}
+
+// overprovisionRatioFromTags returns the overprovisioning ratio stored on a thin volume, or the driver default
+func (d *Driver) overprovisionRatioFromTags(lv *lvm.LogicalVolume) float64 {
+	for _, tag := range lv.Tags {
+		if value, ok := strings.CutPrefix(tag, overprovisionRatioTagPrefix); ok {
+			ratio, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				klog.ErrorS(err, "Ignoring invalid overprovisioning ratio tag", "vg", lv.VG, "lv", lv.Name, "tag", tag)
+				break
+			}
+			return ratio
+		}
+	}
+	return d.overprovisionRatio
+}
+
+// thinPoolNodes returns the nodes that the volumes of a thin pool are published to
+func (d *Driver) thinPoolNodes(vgName, poolName string) ([]string, error) {
+	volumes, err := d.lvm.ListThinVolumes(vgName, poolName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list thin volumes: %v", err)
+	}
+	var nodes []string
+	for _, volume := range volumes {
+		for _, node := range publishedNodes(volume) {
+			if !slices.Contains(nodes, node) {
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	return nodes, nil
+}
+
+// checkThinPoolUnpublished fails if any volume of a thin pool is published. Without lvmlockd, a thin pool may only be
+// active on one host at a time, and creating volumes or snapshots in it activates it on this node.
+func (d *Driver) checkThinPoolUnpublished(vgName, poolName string) error {
+	nodes, err := d.thinPoolNodes(vgName, poolName)
+	if err != nil {
+		return err
+	}
+	if len(nodes) > 0 {
+		return status.Errorf(codes.FailedPrecondition, "thin pool '%s/%s' is in use on node '%s'", vgName, poolName, nodes[0])
+	}
+	return nil
+}
+
+// deactivateThinVolume deactivates a thin volume and its pool after they were created or written to on this node, so
+// that the pool can be activated by the node the volume is published to
+func (d *Driver) deactivateThinVolume(vgName, lvName, poolName string) error {
+	for _, name := range []string{lvName, poolName} {
+		if err := d.lvm.DeactivateLV(vgName, name); err != nil {
+			return status.Errorf(codes.Internal, "failed to deactivate lv '%s/%s': %v", vgName, name, err)
+		}
+	}
+	return nil
+}
+
+// createThinClone creates a new volume as a thin snapshot of source, which shares its blocks until they're overwritten
+func (d *Driver) createThinClone(source *lvm.LogicalVolume, lvName string, size int64, tags []string) error {
+	klog.InfoS("Creating thin clone", "vg", source.VG, "lv", lvName, "source", source.Name)
+	if err := d.lvm.CreateSnapshot(source.VG, lvName, source.Name, 0, tags); err != nil {
+		return status.Errorf(codes.Internal, "failed to create thin clone: %v", err)
 	}
 
-	params := req.GetParameters()
-	vgName, ok := params[volumeGroupKey]
-	if !ok {
-		return nil, status.Errorf(codes.InvalidArgument, "parameter '%s' is required", volumeGroupKey)
+	if size > source.Size {
+		klog.InfoS("Resizing thin clone", "vg", source.VG, "lv", lvName, "size", size)
+		if err := d.lvm.ResizeLV(source.VG, lvName, size, lvm.LVOptions{}); err != nil {
+			return status.Errorf(codes.Internal, "failed to resize thin clone: %v", err)
+		}
 	}
+	return nil
+}
 
-	if len(d.allowedVolumeGroups) > 0 {
-		allowed := false
-		for _, vg := range d.allowedVolumeGroups {
-			if vg == vgName {
-				allowed = true
-				break
-			}
+// getContentSourceLV returns the LV backing the content source of a new volume
+func (d *Driver) getContentSourceLV(contentSource *csi.VolumeContentSource) (*lvm.LogicalVolume, error) {
+	switch {
+	case contentSource.GetSnapshot() != nil:
+		snapshotID := contentSource.GetSnapshot().GetSnapshotId()
+		lv, err := d.getAllowedLV(snapshotID)
+		if err != nil {
+			return nil, err
 		}
-		if !allowed {
-			return nil, status.Errorf(codes.InvalidArgument, "volume group '%s' is not allowed", vgName)
+		if lv == nil || !lv.HasTag(lvm.SnapshotTag) {
+			return nil, status.Errorf(codes.NotFound, "snapshot '%s' not found", snapshotID)
+		}
+		return lv, nil
+	case contentSource.GetVolume() != nil:
+		volumeID := contentSource.GetVolume().GetVolumeId()
+		lv, err := d.getAllowedLV(volumeID)
+		if err != nil {
+			return nil, err
+		}
+		if lv == nil || lv.HasTag(lvm.SnapshotTag) {
+			return nil, status.Errorf(codes.NotFound, "volume '%s' not found", volumeID)
 		}
+		if lv.HasTag(lvm.PopulatingTag) {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume '%s' is still being populated", volumeID)
+		}
+		return lv, nil
+	default:
+		return nil, status.Error(codes.InvalidArgument, "unsupported volume content source")
 	}
+}
 
-	size := req.GetCapacityRange().GetRequiredBytes()
+// getAllowedLV returns the LV with the given id, as long as it belongs to an allowed volume group
+func (d *Driver) getAllowedLV(id string) (*lvm.LogicalVolume, error) {
+	vgName, lvName, err := getVGAndLVNames(id)
+	if err != nil {
+		return nil, err
+	}
+	if !d.isVolumeGroupAllowed(vgName) {
+		return nil, status.Errorf(codes.InvalidArgument, "volume group '%s' is not allowed", vgName)
+	}
 
 	lv, err := d.lvm.GetLV(vgName, lvName)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to get lv: %v", err)
 	}
+	return lv, nil
+}
 
-	if lv != nil {
-		// idempotency
-		if lv.Size >= size {
-			klog.InfoS("LV already exists and is large enough, returning success", "vg", vgName, "lv", lvName)
-			return &csi.CreateVolumeResponse{
-				Volume: &csi.Volume{
-					VolumeId:      fmt.Sprintf("%s/%s", vgName, lvName),
-					CapacityBytes: lv.Size,
-				},
-			}, nil
+// populateVolume copies the content of source into an existing volume, which must be at least as large as source.
+// Both LVs are activated on the controller node for the duration of the copy, and they may belong to different VGs.
+func (d *Driver) populateVolume(source *lvm.LogicalVolume, vgName, lvName string) error {
+	if !source.Attr.IsActive() {
+		klog.InfoS("Activating content source", "vg", source.VG, "lv", source.Name)
+		if err := d.lvm.ActivateLV(source.VG, source.Name); err != nil {
+			return status.Errorf(codes.Internal, "failed to activate content source: %v", err)
 		}
-		return nil, status.Errorf(codes.AlreadyExists, "lv '%s' already exists but with a different size", lvName)
+		defer func() {
+			if err := d.lvm.DeactivateLV(source.VG, source.Name); err != nil {
+				klog.ErrorS(err, "Failed to deactivate content source", "vg", source.VG, "lv", source.Name)
+			}
+		}()
 	}
 
-	klog.InfoS("Creating new LV", "vg", vgName, "lv", lvName, "size", size)
-	tags := []string{
-		lvm.OwnershipTag,
-	}
-	if err := d.lvm.CreateLV(vgName, lvName, size, tags); err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to create lv: %v", err)
+	if err := d.lvm.ActivateLV(vgName, lvName); err != nil {
+		return status.Errorf(codes.Internal, "failed to activate lv: %v", err)
 	}
+	defer func() {
+		if err := d.lvm.DeactivateLV(vgName, lvName); err != nil {
+			klog.ErrorS(err, "Failed to deactivate LV", "vg", vgName, "lv", lvName)
+		}
+	}()
 
-	// actual volume size may be higher than requested, since LVM rounds up to 4MiB sectors
-	actualLV, err := d.lvm.GetLV(vgName, lvName)
-	if err != nil || actualLV == nil {
-		return nil, status.Errorf(codes.Internal, "failed to get lv after creation: %v", err)
+	sourcePath := fmt.Sprintf("/dev/%s/%s", source.VG, source.Name)
+	targetPath := fmt.Sprintf("/dev/%s/%s", vgName, lvName)
+	klog.InfoS("Copying volume content", "source", sourcePath, "target", targetPath)
+	if err := d.copier.Copy(sourcePath, targetPath); err != nil {
+		return status.Errorf(codes.Internal, "failed to copy volume content: %v", err)
 	}
 
-	actualSize := actualLV.Size
+	if err := d.lvm.UpdateLVTags(vgName, lvName, nil, []string{lvm.PopulatingTag}); err != nil {
+		return status.Errorf(codes.Internal, "failed to update lv tags: %v", err)
+	}
+	return nil
+}
 
-	return &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      fmt.Sprintf("%s/%s", vgName, lvName),
-			CapacityBytes: actualSize,
-		},
-	}, nil
+// contentSize returns the minimum size of a volume created from the given LV
+func contentSize(lv *lvm.LogicalVolume) int64 {
+	// the size of a snapshot LV is the size of its COW area, the size of its content is the size of its origin
+	if lv.OriginSize > 0 {
+		return lv.OriginSize
+	}
+	return lv.Size
 }
 
 func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
@@ -108,27 +1060,225 @@ func (d *Driver) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest)
 	}
 	vgName, lvName := parts[0], parts[1]
 
-	if err := d.lvm.DeleteLV(vgName, lvName); err != nil {
+	// removing an origin also removes all of its COW snapshots, so we refuse to do it.
+	// thin snapshots don't depend on their origin and are left alone, unless the origin would be trashed: renaming it
+	// changes the source volume id of its snapshots.
+	snapshots, err := d.lvm.ListSnapshots(vgName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list snapshots: %v", err)
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Origin == lvName && (!snapshot.Attr.IsThinVolume() || d.trashTTL > 0) {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume '%s' has snapshots", req.VolumeId)
+		}
+	}
+
+	lv, err := d.lvm.GetLV(vgName, lvName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get lv: %v", err)
+	}
+
+	if d.trashTTL > 0 && lv != nil {
+		if err := d.trashVolume(lv, time.Now()); err != nil {
+			return nil, err
+		}
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	if err := d.removeVolume(vgName, lvName, lv); err != nil {
+		return nil, err
+	}
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// removeVolume removes the LV of a volume along with its cache or VDO pool. lv may be nil if it's already gone.
+func (d *Driver) removeVolume(vgName, lvName string, lv *lvm.LogicalVolume) error {
+	if err := d.deleteCache(vgName, lvName, lv); err != nil {
+		return err
+	}
+
+	// removing a VDO pool also removes its VDO LV
+	target := lvName
+	if lv != nil && lv.Attr.IsVDO() {
+		target = lv.Pool
+	}
+	if err := d.lvm.DeleteLV(vgName, target); err != nil {
 		// idempotency
 		if strings.Contains(err.Error(), "not found") {
 			klog.InfoS("LV not found, assuming it's already deleted", "vg", vgName, "lv", lvName)
-			return &csi.DeleteVolumeResponse{}, nil
+			return nil
 		}
-		return nil, status.Errorf(codes.Internal, "failed to delete lv: %v", err)
+		return status.Errorf(codes.Internal, "failed to delete lv: %v", err)
 	}
 
 	klog.InfoS("LV deleted successfully", "vg", vgName, "lv", lvName)
-	return &csi.DeleteVolumeResponse{}, nil
+	return nil
+}
+
+// deleteCache removes the cache of a volume, whether it's attached or was left detached by a failed create or expand
+func (d *Driver) deleteCache(vgName, lvName string, lv *lvm.LogicalVolume) error {
+	if lv != nil && lv.Attr.IsCached() {
+		klog.InfoS("Removing cache", "vg", vgName, "lv", lvName)
+		if err := d.lvm.Uncache(vgName, lvName); err != nil {
+			return status.Errorf(codes.Internal, "failed to uncache lv: %v", err)
+		}
+		return nil
+	}
+
+	// a detached cache isn't renamed along with a volume that is moved to the trash
+	name := lvName
+	if lv != nil && isTrashed(lv) {
+		name = trashedName(lv)
+	}
+	cacheName := cacheLVName(name)
+	cache, err := d.lvm.GetLV(vgName, cacheName)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get cache lv: %v", err)
+	}
+	// volumes are owned by the driver, cache LVs are not
+	if cache != nil && !cache.HasTag(lvm.OwnershipTag) {
+		klog.InfoS("Removing detached cache LV", "vg", vgName, "lv", cacheName)
+		if err := d.lvm.DeleteLV(vgName, cacheName); err != nil {
+			return status.Errorf(codes.Internal, "failed to delete cache lv: %v", err)
+		}
+	}
+	return nil
 }
 
 func (d *Driver) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
 	klog.InfoS("ControllerPublishVolume called", "req", req)
-	return nil, status.Error(codes.Unimplemented, "")
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+	if req.NodeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "node id is required")
+	}
+	if req.VolumeCapability == nil {
+		return nil, status.Error(codes.InvalidArgument, "volume capability is required")
+	}
+
+	// publishing is a read-modify-write of the LV tags, so concurrent calls for different nodes must not interleave
+	d.publishLock.Lock()
+	defer d.publishLock.Unlock()
+
+	lv, err := d.getAllowedLV(req.VolumeId)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil || lv.HasTag(lvm.SnapshotTag) {
+		return nil, status.Errorf(codes.NotFound, "volume '%s' not found", req.VolumeId)
+	}
+
+	resp := &csi.ControllerPublishVolumeResponse{
+		PublishContext: map[string]string{
+			publishedNodeKey: req.NodeId,
+		},
+	}
+
+	nodes := publishedNodes(lv)
+	if slices.Contains(nodes, req.NodeId) {
+		// idempotency
+		klog.InfoS("Volume is already published to node, returning success", "vg", lv.VG, "lv", lv.Name, "node", req.NodeId)
+		return resp, nil
+	}
+	// only filesystems are destroyed by being mounted on two nodes, coordinating the writes to a raw block device is up
+	// to its users
+	if len(nodes) > 0 && req.VolumeCapability.GetMount() != nil && isSingleNodeMode(req.VolumeCapability.GetAccessMode().GetMode()) {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume '%s' is already published to node '%s'", req.VolumeId, nodes[0])
+	}
+	// the pool of a thin volume is activated along with it, and may only be active on one node
+	if lv.Pool != "" && lv.Attr.IsThinVolume() {
+		poolNodes, err := d.thinPoolNodes(lv.VG, lv.Pool)
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range poolNodes {
+			if node != req.NodeId {
+				return nil, status.Errorf(codes.FailedPrecondition, "thin pool '%s/%s' of volume '%s' is in use on node '%s'", lv.VG, lv.Pool, req.VolumeId, node)
+			}
+		}
+	}
+	// a check started by the RAID monitor keeps the volume active on this node, which must end before another node
+	// activates it
+	if lv.HasTag(scrubActivatedTag) {
+		if err := d.abortRaidScrub(lv); err != nil {
+			return nil, err
+		}
+	}
+
+	klog.InfoS("Publishing volume to node", "vg", lv.VG, "lv", lv.Name, "node", req.NodeId)
+	if err := d.lvm.UpdateLVTags(lv.VG, lv.Name, []string{publishedNodeTag(req.NodeId)}, nil); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to update lv tags: %v", err)
+	}
+	return resp, nil
 }
 
 func (d *Driver) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
 	klog.InfoS("ControllerUnpublishVolume called", "req", req)
-	return nil, status.Error(codes.Unimplemented, "")
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+
+	d.publishLock.Lock()
+	defer d.publishLock.Unlock()
+
+	lv, err := d.getAllowedLV(req.VolumeId)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil {
+		// idempotency
+		klog.InfoS("LV not found, assuming it's already unpublished", "volumeId", req.VolumeId)
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+
+	// an empty node id means the volume must be unpublished from all nodes
+	var remove []string
+	for _, node := range publishedNodes(lv) {
+		if req.NodeId == "" || node == req.NodeId {
+			remove = append(remove, publishedNodeTag(node))
+		}
+	}
+	if len(remove) == 0 {
+		klog.InfoS("Volume is not published to node, returning success", "vg", lv.VG, "lv", lv.Name, "node", req.NodeId)
+		return &csi.ControllerUnpublishVolumeResponse{}, nil
+	}
+
+	klog.InfoS("Unpublishing volume from node", "vg", lv.VG, "lv", lv.Name, "node", req.NodeId)
+	if err := d.lvm.UpdateLVTags(lv.VG, lv.Name, nil, remove); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to update lv tags: %v", err)
+	}
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
+
+func publishedNodeTag(nodeID string) string {
+	return publishedNodeTagPrefix + nodeID
+}
+
+// publishedNodes returns the nodes a volume is currently published to
+func publishedNodes(lv *lvm.LogicalVolume) []string {
+	var nodes []string
+	for _, tag := range lv.Tags {
+		if node, ok := strings.CutPrefix(tag, publishedNodeTagPrefix); ok {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// isSingleNodeMode returns true if a volume with the given access mode may only be published to a single node at a time
+func isSingleNodeMode(mode csi.VolumeCapability_AccessMode_Mode) bool {
+	switch mode {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:
+		return true
+	default:
+		return false
+	}
 }
 
 func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
@@ -182,16 +1332,126 @@ func (d *Driver) ValidateVolumeCapabilities(ctx context.Context, req *csi.Valida
 		}, nil
 	}
 
-	return &csi.ValidateVolumeCapabilitiesResponse{
+	resp := &csi.ValidateVolumeCapabilitiesResponse{
 		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
 			VolumeCapabilities: req.VolumeCapabilities,
 		},
-	}, nil
+	}
+	if lv.Attr.IsRaid() {
+		resp.Message = raidState(lv)
+	}
+	return resp, nil
 }
 
 func (d *Driver) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
 	klog.InfoS("ListVolumes called", "req", req)
-	return nil, status.Error(codes.Unimplemented, "")
+
+	entries := make(map[string]*lvm.LogicalVolume)
+	var ids []string
+	for _, vgName := range d.volumeGroupsToList() {
+		lvs, err := d.lvm.ListOwnedLVs(vgName)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to list lvs: %v", err)
+		}
+		for _, lv := range lvs {
+			// snapshots are owned by the driver as well, but they're listed by ListSnapshots, and trashed volumes are
+			// deleted as far as the CO is concerned
+			if lv.HasTag(lvm.SnapshotTag) || isTrashed(lv) {
+				continue
+			}
+			id := fmt.Sprintf("%s/%s", lv.VG, lv.Name)
+			entries[id] = lv
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	start, end, nextToken, err := paginate(ids, req.StartingToken, req.MaxEntries)
+	if err != nil {
+		return nil, err
+	}
+	resp := &csi.ListVolumesResponse{
+		NextToken: nextToken,
+	}
+	vgs := make(map[string]*lvm.VolumeGroup)
+	for _, id := range ids[start:end] {
+		lv := entries[id]
+		vg, ok := vgs[lv.VG]
+		if !ok {
+			var err error
+			vg, err = d.lvm.GetVG(lv.VG)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to get vg '%s': %v", lv.VG, err)
+			}
+			vgs[lv.VG] = vg
+		}
+		resp.Entries = append(resp.Entries, &csi.ListVolumesResponse_Entry{
+			Volume: &csi.Volume{
+				VolumeId:      id,
+				CapacityBytes: lv.Size,
+			},
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				VolumeCondition: volumeCondition(lv, vg),
+			},
+		})
+	}
+	return resp, nil
+}
+
+// volumeHealth returns the condition of a volume, including the physical usage of its VDO pool if it has one
+func (d *Driver) volumeHealth(volumeID string, lv *lvm.LogicalVolume) (*csi.VolumeCondition, error) {
+	if lv == nil || lv.HasTag(lvm.SnapshotTag) {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("lv of volume '%s' not found", volumeID),
+		}, nil
+	}
+
+	vg, err := d.lvm.GetVG(lv.VG)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get vg '%s': %v", lv.VG, err)
+	}
+	condition := volumeCondition(lv, vg)
+	if !condition.Abnormal && lv.Attr.IsVDO() {
+		pool, err := d.lvm.GetVDOPool(lv.VG, lv.Pool)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get vdo pool '%s': %v", lv.Pool, err)
+		}
+		condition = vdoCondition(lv, pool)
+	}
+	return condition, nil
+}
+
+// volumeCondition reports whether a volume is abnormal, based on the health of its LV and of the VG it belongs to
+func volumeCondition(lv *lvm.LogicalVolume, vg *lvm.VolumeGroup) *csi.VolumeCondition {
+	if vg != nil && vg.Attr.IsPartial() {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("volume group '%s' is partial, one or more of its physical volumes are missing", vg.Name),
+		}
+	}
+	if problem := lv.Attr.HealthProblem(); problem != "" {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("lv '%s' is unhealthy: %s", lv.Name, problem),
+		}
+	}
+	if lv.IntegrityMismatches > 0 {
+		return &csi.VolumeCondition{
+			Abnormal: true,
+			Message:  fmt.Sprintf("%d integrity mismatches were detected on lv '%s'", lv.IntegrityMismatches, lv.Name),
+		}
+	}
+	if lv.Attr.IsRaid() {
+		return &csi.VolumeCondition{
+			Abnormal: false,
+			Message:  raidState(lv),
+		}
+	}
+	return &csi.VolumeCondition{
+		Abnormal: false,
+		Message:  "volume is healthy",
+	}
 }
 
 func (d *Driver) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
@@ -200,19 +1460,25 @@ func (d *Driver) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (
 	var vgsToQuery []string
 	params := req.GetParameters()
 	if vgName, ok := params[volumeGroupKey]; ok {
-		if len(d.allowedVolumeGroups) > 0 {
-			allowed := false
-			for _, allowedVG := range d.allowedVolumeGroups {
-				if allowedVG == vgName {
-					allowed = true
-					break
-				}
-			}
-			if !allowed {
-				return nil, status.Errorf(codes.InvalidArgument, "volume group '%s' is not allowed", vgName)
-			}
+		if !d.isVolumeGroupAllowed(vgName) {
+			return nil, status.Errorf(codes.InvalidArgument, "volume group '%s' is not allowed", vgName)
+		}
+		if !isVolumeGroupInTopology(vgName, req.GetAccessibleTopology()) {
+			// the VG is not visible from the nodes of this segment, so nothing can be provisioned there
+			return &csi.GetCapacityResponse{
+				AvailableCapacity: 0,
+			}, nil
+		}
+		if poolName, ok := params[thinPoolKey]; ok {
+			return d.getThinPoolCapacity(vgName, poolName, params)
 		}
 		vgsToQuery = []string{vgName}
+	} else if vgList, ok := params[volumeGroupsKey]; ok {
+		for _, vgName := range parseList(vgList) {
+			if d.isVolumeGroupAllowed(vgName) {
+				vgsToQuery = append(vgsToQuery, vgName)
+			}
+		}
 	} else {
 		if len(d.allowedVolumeGroups) > 0 {
 			vgsToQuery = d.allowedVolumeGroups
@@ -223,24 +1489,237 @@ func (d *Driver) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (
 		}
 	}
 
-	var totalAvailableCapacity int64
+	opts, err := parseLayoutParameters(params)
+	if err != nil {
+		return nil, err
+	}
+	// VDO volumes are larger than the space they allocate, by the inverse of the physical size percent
+	physicalPercent := int64(100)
+	if opts.Type == "vdo" {
+		physicalPercent, _ = parseVDOPhysicalSizePercent(params)
+	}
+
+	var totalAvailableCapacity, maximumVolumeSize, minimumVolumeSize int64
 	for _, vgName := range vgsToQuery {
+		if !isVolumeGroupInTopology(vgName, req.GetAccessibleTopology()) {
+			continue
+		}
 		vg, err := d.lvm.GetVG(vgName)
+		if err == nil && vg != nil {
+			var available, maxSize int64
+			available, err = d.availableCapacity(vg, opts)
+			if err == nil {
+				maxSize, err = d.maxVolumeSize(vg, opts)
+			}
+			if err == nil {
+				totalAvailableCapacity += available * 100 / physicalPercent
+				maximumVolumeSize = max(maximumVolumeSize, maxSize*100/physicalPercent)
+				if minimumVolumeSize == 0 || vg.ExtentSize < minimumVolumeSize {
+					minimumVolumeSize = vg.ExtentSize
+				}
+			}
+		}
+		if err != nil {
+			if params[volumeGroupKey] != "" {
+				return nil, status.Errorf(codes.Internal, "failed to get capacity of vg '%s': %v", vgName, err)
+			}
+			klog.ErrorS(err, "Failed to get VG capacity", "vg", vgName)
+		}
+	}
+
+	resp := &csi.GetCapacityResponse{
+		AvailableCapacity: totalAvailableCapacity,
+	}
+	// only reported when positive, since the provisioner treats an explicit zero differently from an unset value
+	if maximumVolumeSize > 0 {
+		resp.MaximumVolumeSize = wrapperspb.Int64(maximumVolumeSize)
+	}
+	if minimumVolumeSize > 0 {
+		resp.MinimumVolumeSize = wrapperspb.Int64(minimumVolumeSize)
+	}
+	return resp, nil
+}
+
+// alignVolumeSize rounds the required size of a capacity range up to a whole number of extents per data stripe, as
+// LVM does, and fails if the result exceeds its limit
+func alignVolumeSize(vg *lvm.VolumeGroup, capacityRange *csi.CapacityRange, stripes int) (int64, error) {
+	size := capacityRange.GetRequiredBytes()
+	limit := capacityRange.GetLimitBytes()
+	if size < 0 || limit < 0 {
+		return 0, status.Error(codes.InvalidArgument, "capacity range must not be negative")
+	}
+	if alignment := vg.ExtentSize * int64(max(stripes, 1)); alignment > 0 {
+		size = (size + alignment - 1) / alignment * alignment
+	}
+	if limit > 0 && size > limit {
+		return 0, status.Errorf(codes.OutOfRange, "required %d bytes are %d bytes once aligned to the %d bytes extents of volume group '%s', above the %d bytes limit", capacityRange.GetRequiredBytes(), size, vg.ExtentSize, vg.Name, limit)
+	}
+	return size, nil
+}
+
+// maxVolumeSize returns the size of the largest LV with the given layout that can be allocated in a volume group. LVs
+// may span several PVs, unless the allocation policy of the layout, or else of the VG, is contiguous, in which case
+// they must fit in a single free area of a PV. Only PVs with one of the tags of the layout are considered, if it has
+// any. The stripes or RAID images of an LV must each fit in distinct PVs, or in PVs with distinct tags for RAID
+// images, unless the allocation policy is anywhere.
+func (d *Driver) maxVolumeSize(vg *lvm.VolumeGroup, opts lvm.LVOptions) (int64, error) {
+	policy := opts.AllocPolicy
+	if policy == "" {
+		policy = vg.Attr.AllocationPolicy()
+	}
+	contiguous := policy == "contiguous"
+	areas, dataStripes := parallelAreas(opts)
+	if !contiguous && len(opts.PVTags) == 0 && areas == 1 {
+		return vg.FreeSize, nil
+	}
+
+	pvs, err := d.lvm.ListPVs(vg.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	// groups of PVs that can each hold one of the parallel areas
+	var groups [][]*lvm.PhysicalVolume
+	switch {
+	case policy == "anywhere":
+		// parallel areas may share PVs, so they split the free space of all of them
+		var free int64
+		for _, pv := range pvs {
+			if hasPVTag(pv, opts.PVTags) {
+				free += pv.FreeSize
+			}
+		}
+		areaSize := free / int64(areas)
+		if vg.ExtentSize > 0 {
+			areaSize = areaSize / vg.ExtentSize * vg.ExtentSize
+		}
+		if opts.Type != "" {
+			areaSize -= vg.ExtentSize
+		}
+		return max(areaSize, 0) * int64(dataStripes), nil
+	case areas == 1:
+		var group []*lvm.PhysicalVolume
+		for _, pv := range pvs {
+			if hasPVTag(pv, opts.PVTags) {
+				group = append(group, pv)
+			}
+		}
+		groups = append(groups, group)
+	case opts.Type != "" && len(opts.PVTags) > 0:
+		for _, tag := range opts.PVTags {
+			var group []*lvm.PhysicalVolume
+			for _, pv := range pvs {
+				if hasPVTag(pv, []string{tag}) {
+					group = append(group, pv)
+				}
+			}
+			groups = append(groups, group)
+		}
+	default:
+		for _, pv := range pvs {
+			if hasPVTag(pv, opts.PVTags) {
+				groups = append(groups, []*lvm.PhysicalVolume{pv})
+			}
+		}
+	}
+	if len(groups) < areas {
+		return 0, nil
+	}
+
+	spaces := make([]int64, len(groups))
+	for i, group := range groups {
+		for _, pv := range group {
+			if contiguous {
+				spaces[i] = max(spaces[i], pv.LargestFreeSegment)
+			} else {
+				spaces[i] += pv.FreeSize
+			}
+		}
+	}
+	slices.Sort(spaces)
+	slices.Reverse(spaces)
+	areaSize := spaces[areas-1]
+	if opts.Type != "" {
+		// every RAID image also needs a metadata subvolume of one extent
+		areaSize -= vg.ExtentSize
+	}
+	return max(areaSize, 0) * int64(dataStripes), nil
+}
+
+// hasPVTag returns true if a PV has one of the given tags, or if there are none
+func hasPVTag(pv *lvm.PhysicalVolume, tags []string) bool {
+	return len(tags) == 0 || slices.ContainsFunc(pv.Tags, func(tag string) bool { return slices.Contains(tags, tag) })
+}
+
+// availableCapacity returns the free space of a volume group that volumes with the given layout can be allocated on
+func (d *Driver) availableCapacity(vg *lvm.VolumeGroup, opts lvm.LVOptions) (int64, error) {
+	if len(opts.PVTags) == 0 {
+		return vg.FreeSize, nil
+	}
+	pvs, err := d.lvm.ListPVs(vg.Name)
+	if err != nil {
+		return 0, err
+	}
+	var free int64
+	for _, pv := range pvs {
+		if hasPVTag(pv, opts.PVTags) {
+			free += pv.FreeSize
+		}
+	}
+	return free, nil
+}
+
+// getThinPoolCapacity returns the data space still available in a thin pool. Thin volumes only consume pool space
+// as they're written to, so this is the space that is actually left, not a limit for new volume sizes. New volumes are
+// only limited by the overprovisioning ratio, if any.
+//
+// LVM only reports the usage of pools that are active on this node, which they usually aren't on the controller. The
+// space that isn't allocated to thin volumes yet, or the overprovisioning limit if set, is reported for those instead.
+func (d *Driver) getThinPoolCapacity(vgName, poolName string, params map[string]string) (*csi.GetCapacityResponse, error) {
+	pool, err := d.lvm.GetLV(vgName, poolName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get thin pool '%s': %v", poolName, err)
+	}
+	if pool == nil || !pool.Attr.IsThinPool() {
+		return nil, status.Errorf(codes.InvalidArgument, "thin pool '%s' not found in volume group '%s'", poolName, vgName)
+	}
+
+	ratio, err := parseOverprovisionRatio(params, d.overprovisionRatio)
+	if err != nil {
+		return nil, err
+	}
+	free, known := pool.ThinPoolFreeSize()
+	var virtualSize int64
+	if ratio > 0 || !known {
+		virtualSize, err = d.thinVolumesSize(pool)
 		if err != nil {
-			if params[volumeGroupKey] != "" {
-				return nil, status.Errorf(codes.Internal, "failed to get vg '%s': %v", vgName, err)
-			}
-			klog.ErrorS(err, "Failed to get VG", "vg", vgName)
-			continue
-		}
-		if vg != nil {
-			totalAvailableCapacity += vg.FreeSize
+			return nil, err
 		}
 	}
+	limit := pool.Size
+	if ratio > 0 {
+		limit = int64(float64(pool.Size) * ratio)
+	}
+	if !known {
+		free = max(limit-virtualSize, 0)
+	}
 
-	return &csi.GetCapacityResponse{
-		AvailableCapacity: totalAvailableCapacity,
-	}, nil
+	resp := &csi.GetCapacityResponse{
+		AvailableCapacity: free,
+	}
+
+	vg, err := d.lvm.GetVG(vgName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get vg '%s': %v", vgName, err)
+	}
+	if vg != nil {
+		resp.MinimumVolumeSize = wrapperspb.Int64(vg.ExtentSize)
+	}
+
+	if ratio > 0 {
+		resp.MaximumVolumeSize = wrapperspb.Int64(max(limit-virtualSize, 0))
+	}
+	return resp, nil
 }
 
 func (d *Driver) ControllerGetCapabilities(ctx context.Context, req *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
@@ -254,6 +1733,13 @@ func (d *Driver) ControllerGetCapabilities(ctx context.Context, req *csi.Control
 					},
 				},
 			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+					},
+				},
+			},
 			{
 				Type: &csi.ControllerServiceCapability_Rpc{
 					Rpc: &csi.ControllerServiceCapability_RPC{
@@ -268,23 +1754,256 @@ func (d *Driver) ControllerGetCapabilities(ctx context.Context, req *csi.Control
 					},
 				},
 			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_GET_VOLUME,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+					},
+				},
+			},
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
+					},
+				},
+			},
 		},
 	}, nil
 }
 
 func (d *Driver) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
 	klog.InfoS("CreateSnapshot called", "req", req)
-	return nil, status.Error(codes.Unimplemented, "")
+
+	snapName := req.Name
+	if snapName == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	if req.SourceVolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "source volume id is required")
+	}
+
+	vgName, lvName, err := getVGAndLVNames(req.SourceVolumeId)
+	if err != nil {
+		return nil, err
+	}
+	if !d.isVolumeGroupAllowed(vgName) {
+		return nil, status.Errorf(codes.InvalidArgument, "volume group '%s' is not allowed", vgName)
+	}
+
+	sizePercent := int64(defaultSnapshotSizePercent)
+	if value, ok := req.GetParameters()[snapshotSizePercentKey]; ok {
+		sizePercent, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || sizePercent < 1 || sizePercent > 100 {
+			return nil, status.Errorf(codes.InvalidArgument, "parameter '%s' must be an integer between 1 and 100", snapshotSizePercentKey)
+		}
+	}
+
+	source, err := d.lvm.GetLV(vgName, lvName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get lv: %v", err)
+	}
+	if source == nil {
+		return nil, status.Errorf(codes.NotFound, "source volume '%s' not found", req.SourceVolumeId)
+	}
+	if source.HasTag(lvm.SnapshotTag) {
+		return nil, status.Errorf(codes.InvalidArgument, "source volume '%s' is a snapshot", req.SourceVolumeId)
+	}
+
+	snapshot, err := d.lvm.GetLV(vgName, snapName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get snapshot: %v", err)
+	}
+
+	if snapshot != nil {
+		// idempotency
+		if snapshot.HasTag(lvm.SnapshotTag) && snapshot.Origin == lvName {
+			klog.InfoS("Snapshot already exists, returning success", "vg", vgName, "snapshot", snapName)
+			return &csi.CreateSnapshotResponse{
+				Snapshot: newCSISnapshot(snapshot),
+			}, nil
+		}
+		return nil, status.Errorf(codes.AlreadyExists, "snapshot '%s' already exists but with a different source", snapName)
+	}
+
+	// a COW snapshot taken on this node doesn't see the writes of a node that has the origin active, and a thin
+	// snapshot needs the pool active on this node
+	if source.Attr.IsThinVolume() {
+		if err := d.checkThinPoolUnpublished(vgName, source.Pool); err != nil {
+			return nil, err
+		}
+	} else if nodes := publishedNodes(source); len(nodes) > 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "source volume '%s' is published to node '%s', unpublish it before taking a snapshot", req.SourceVolumeId, nodes[0])
+	}
+
+	// the COW area only has to hold the blocks changed after the snapshot is taken, so it may be smaller than the origin.
+	// thin snapshots allocate from the pool of their origin instead.
+	size := (source.Size*sizePercent + 99) / 100
+	if source.Attr.IsThinVolume() {
+		size = 0
+	}
+
+	klog.InfoS("Creating new snapshot", "vg", vgName, "snapshot", snapName, "origin", lvName, "size", size)
+	tags := []string{
+		lvm.OwnershipTag,
+		lvm.SnapshotTag,
+	}
+	// snapshots keep the LUKS header and dm-integrity superblock of their origin, which volumes restored from them
+	// have to be created for
+	for _, tag := range []string{encryptedTag, integrityTag} {
+		if source.HasTag(tag) {
+			tags = append(tags, tag)
+		}
+	}
+	if err := d.lvm.CreateSnapshot(vgName, snapName, lvName, size, tags); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create snapshot: %v", err)
+	}
+	if source.Attr.IsThinVolume() {
+		if err := d.lvm.DeactivateLV(vgName, source.Pool); err != nil {
+			klog.ErrorS(err, "Failed to deactivate thin pool", "vg", vgName, "pool", source.Pool)
+		}
+	}
+
+	snapshot, err = d.lvm.GetLV(vgName, snapName)
+	if err != nil || snapshot == nil {
+		return nil, status.Errorf(codes.Internal, "failed to get snapshot after creation: %v", err)
+	}
+
+	return &csi.CreateSnapshotResponse{
+		Snapshot: newCSISnapshot(snapshot),
+	}, nil
 }
 
 func (d *Driver) DeleteSnapshot(ctx context.Context, req *csi.DeleteSnapshotRequest) (*csi.DeleteSnapshotResponse, error) {
 	klog.InfoS("DeleteSnapshot called", "req", req)
-	return nil, status.Error(codes.Unimplemented, "")
+
+	if req.SnapshotId == "" {
+		return nil, status.Error(codes.InvalidArgument, "snapshot id is required")
+	}
+
+	vgName, snapName, err := getVGAndLVNames(req.SnapshotId)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := d.lvm.GetLV(vgName, snapName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get snapshot: %v", err)
+	}
+	if snapshot == nil {
+		// idempotency
+		klog.InfoS("Snapshot not found, assuming it's already deleted", "vg", vgName, "snapshot", snapName)
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+	if !snapshot.HasTag(lvm.SnapshotTag) {
+		return nil, status.Errorf(codes.InvalidArgument, "'%s' is not a snapshot", req.SnapshotId)
+	}
+
+	if err := d.lvm.DeleteSnapshot(vgName, snapName); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete snapshot: %v", err)
+	}
+
+	klog.InfoS("Snapshot deleted successfully", "vg", vgName, "snapshot", snapName)
+	return &csi.DeleteSnapshotResponse{}, nil
 }
 
 func (d *Driver) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
 	klog.InfoS("ListSnapshots called", "req", req)
-	return nil, status.Error(codes.Unimplemented, "")
+
+	var snapshots []*lvm.LogicalVolume
+	if req.SnapshotId != "" {
+		vgName, snapName, err := getVGAndLVNames(req.SnapshotId)
+		if err != nil {
+			// an id we could never have generated can't match any snapshot
+			return &csi.ListSnapshotsResponse{}, nil
+		}
+		snapshot, err := d.lvm.GetLV(vgName, snapName)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get snapshot: %v", err)
+		}
+		if snapshot != nil && snapshot.HasTag(lvm.SnapshotTag) && d.isVolumeGroupAllowed(vgName) {
+			snapshots = append(snapshots, snapshot)
+		}
+	} else {
+		for _, vgName := range d.volumeGroupsToList() {
+			vgSnapshots, err := d.lvm.ListSnapshots(vgName)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to list snapshots: %v", err)
+			}
+			snapshots = append(snapshots, vgSnapshots...)
+		}
+	}
+
+	entries := make(map[string]*csi.Snapshot)
+	var ids []string
+	for _, snapshot := range snapshots {
+		csiSnapshot := newCSISnapshot(snapshot)
+		if req.SourceVolumeId != "" && csiSnapshot.SourceVolumeId != req.SourceVolumeId {
+			continue
+		}
+		entries[csiSnapshot.SnapshotId] = csiSnapshot
+		ids = append(ids, csiSnapshot.SnapshotId)
+	}
+	sort.Strings(ids)
+
+	start, end, nextToken, err := paginate(ids, req.StartingToken, req.MaxEntries)
+	if err != nil {
+		return nil, err
+	}
+	resp := &csi.ListSnapshotsResponse{
+		NextToken: nextToken,
+	}
+	for _, id := range ids[start:end] {
+		resp.Entries = append(resp.Entries, &csi.ListSnapshotsResponse_Entry{
+			Snapshot: entries[id],
+		})
+	}
+	return resp, nil
+}
+
+func newCSISnapshot(snapshot *lvm.LogicalVolume) *csi.Snapshot {
+	return &csi.Snapshot{
+		SnapshotId:     fmt.Sprintf("%s/%s", snapshot.VG, snapshot.Name),
+		SourceVolumeId: fmt.Sprintf("%s/%s", snapshot.VG, snapshot.Origin),
+		SizeBytes:      contentSize(snapshot),
+		CreationTime:   timestamppb.New(snapshot.CreationTime),
+		ReadyToUse:     true,
+	}
 }
 
 func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
@@ -311,20 +2030,97 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 		return nil, status.Errorf(codes.NotFound, "volume '%s' not found", req.VolumeId)
 	}
 
-	size := req.GetCapacityRange().GetRequiredBytes()
+	vg, err := d.lvm.GetVG(vgName)
+	if err != nil || vg == nil {
+		return nil, status.Errorf(codes.Internal, "failed to get vg: %v", err)
+	}
+	layout := layoutFromTags(lv)
+	_, dataStripes := parallelAreas(layout)
+	size, err := alignVolumeSize(vg, req.GetCapacityRange(), dataStripes)
+	if err != nil {
+		return nil, err
+	}
+
+	// cached LVs can't be extended, so their cache is detached during the expansion
+	cacheMode := cacheModeFromTags(lv)
+	cached := cacheMode != "" && lv.Attr.IsCached()
 
 	if lv.Size >= size {
+		// a previous attempt may have failed to attach the cache again
+		if cacheMode != "" && !cached {
+			if err := d.attachCache(vgName, lvName, 0, lvm.CacheOptions{Mode: cacheMode}); err != nil {
+				return nil, err
+			}
+		}
 		klog.InfoS("LV is already large enough, returning success", "vg", vgName, "lv", lvName)
 		return &csi.ControllerExpandVolumeResponse{
 			CapacityBytes: lv.Size,
 		}, nil
 	}
 
+	// VDO pools grow along with their volume, so that the ratio of physical to logical size is kept
+	var vdoPhysicalSize int64
+	if lv.Pool != "" && lv.Attr.IsVDO() {
+		pool, err := d.lvm.GetVDOPool(vgName, lv.Pool)
+		if err != nil || pool == nil {
+			return nil, status.Errorf(codes.Internal, "failed to get vdo pool '%s': %v", lv.Pool, err)
+		}
+		vdoPhysicalSize = pool.Size
+		if lv.Size > 0 {
+			vdoPhysicalSize = int64(float64(size) * float64(pool.Size) / float64(lv.Size))
+			if vg.ExtentSize > 0 {
+				vdoPhysicalSize = (vdoPhysicalSize + vg.ExtentSize - 1) / vg.ExtentSize * vg.ExtentSize
+			}
+		}
+		maxSize, err := d.maxVolumeSize(vg, layout)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get free space of vg: %v", err)
+		}
+		if vdoPhysicalSize-pool.Size > maxSize {
+			return nil, status.Errorf(codes.ResourceExhausted, "volume group '%s' does not have enough free extents: %d bytes requested, %d bytes available", vgName, vdoPhysicalSize-pool.Size, maxSize)
+		}
+		if vdoPhysicalSize > pool.Size {
+			klog.InfoS("Resizing VDO pool", "vg", vgName, "pool", lv.Pool, "size", vdoPhysicalSize)
+			if err := d.lvm.ResizeLV(vgName, lv.Pool, vdoPhysicalSize, layout); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to resize vdo pool: %v", err)
+			}
+		}
+	} else if lv.Pool != "" {
+		pool, err := d.getThinPool(vgName, lv.Pool)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.checkOverprovisioning(pool, d.overprovisionRatioFromTags(lv), size-lv.Size); err != nil {
+			return nil, err
+		}
+	} else {
+		maxSize, err := d.maxVolumeSize(vg, layout)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get free space of vg: %v", err)
+		}
+		if size-lv.Size > maxSize {
+			return nil, status.Errorf(codes.ResourceExhausted, "volume group '%s' does not have enough free extents: %d bytes requested, %d bytes available", vgName, size-lv.Size, maxSize)
+		}
+	}
+
+	if cached {
+		klog.InfoS("Detaching cache before resizing", "vg", vgName, "lv", lvName)
+		if err := d.lvm.SplitCache(vgName, lvName); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to split cache: %v", err)
+		}
+	}
+
 	klog.InfoS("Resizing LV", "vg", vgName, "lv", lvName, "size", size)
-	if err := d.lvm.ResizeLV(vgName, lvName, size); err != nil {
+	if err := d.lvm.ResizeLV(vgName, lvName, size, layout); err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to resize lv: %v", err)
 	}
 
+	if cacheMode != "" {
+		if err := d.attachCache(vgName, lvName, 0, lvm.CacheOptions{Mode: cacheMode}); err != nil {
+			return nil, err
+		}
+	}
+
 	// actual volume size may be higher than requested, since LVM rounds up to 4MiB sectors
 	resizedLV, err := d.lvm.GetLV(vgName, lvName)
 	if err != nil || resizedLV == nil {
@@ -340,10 +2136,210 @@ func (d *Driver) ControllerExpandVolume(ctx context.Context, req *csi.Controller
 
 func (d *Driver) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
 	klog.InfoS("ControllerGetVolume called", "req", req)
-	return nil, status.Error(codes.Unimplemented, "")
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+
+	lv, err := d.getAllowedLV(req.VolumeId)
+	if err != nil {
+		return nil, err
+	}
+
+	// a volume whose LV is gone is reported as abnormal, so that the health monitor raises an event for its PVC
+	condition, err := d.volumeHealth(req.VolumeId, lv)
+	if err != nil {
+		return nil, err
+	}
+	resp := &csi.ControllerGetVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId: req.VolumeId,
+		},
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			VolumeCondition: condition,
+		},
+	}
+	if lv != nil && !lv.HasTag(lvm.SnapshotTag) {
+		resp.Volume.CapacityBytes = lv.Size
+	}
+	return resp, nil
 }
 
 func (d *Driver) ControllerModifyVolume(ctx context.Context, req *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
 	klog.InfoS("ControllerModifyVolume called", "req", req)
-	return nil, status.Error(codes.Unimplemented, "")
+
+	if req.VolumeId == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is required")
+	}
+
+	modification, err := parseMutableParameters(req.GetMutableParameters())
+	if err != nil {
+		return nil, err
+	}
+
+	lv, err := d.getAllowedLV(req.VolumeId)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil || lv.HasTag(lvm.SnapshotTag) {
+		return nil, status.Errorf(codes.NotFound, "volume '%s' not found", req.VolumeId)
+	}
+
+	if err := d.modifyVolume(lv, modification, req.GetSecrets()); err != nil {
+		return nil, err
+	}
+	return &csi.ControllerModifyVolumeResponse{}, nil
+}
+
+// volumeModification holds the validated mutable parameters of a volume
+type volumeModification struct {
+	// tags are the user tags of the volume, only applied if setTags is true so that they can also be cleared
+	tags    []string
+	setTags bool
+	changes lvm.LVChanges
+	// mirrors is the number of additional raid1 images, or zero to keep the current layout
+	mirrors int
+	// encryptionKeyID is the id of the passphrase of an encrypted volume, or empty to keep its key
+	encryptionKeyID string
+}
+
+// userTagPattern matches the characters LVM allows in tags
+var userTagPattern = regexp.MustCompile(`^[A-Za-z0-9_+.\-/=!:&#]+$`)
+
+func parseMutableParameters(params map[string]string) (*volumeModification, error) {
+	modification := &volumeModification{}
+	var raidType string
+	for key, value := range params {
+		switch key {
+		case tagsKey:
+			modification.setTags = true
+			for _, tag := range strings.Split(value, ",") {
+				tag = strings.TrimSpace(tag)
+				if tag == "" {
+					continue
+				}
+				if !userTagPattern.MatchString(tag) || strings.HasPrefix(tag, lvm.OwnershipTag) {
+					return nil, status.Errorf(codes.InvalidArgument, "invalid tag '%s'", tag)
+				}
+				modification.tags = append(modification.tags, tag)
+			}
+		case readAheadKey:
+			if _, err := strconv.ParseUint(value, 10, 32); err != nil && value != "auto" {
+				return nil, status.Errorf(codes.InvalidArgument, "parameter '%s' must be 'auto' or a number of sectors", readAheadKey)
+			}
+			modification.changes.ReadAhead = value
+		case cacheModeKey:
+			if value != "writethrough" && value != "writeback" && value != "passthrough" {
+				return nil, status.Errorf(codes.InvalidArgument, "parameter '%s' must be one of writethrough, writeback or passthrough", cacheModeKey)
+			}
+			modification.changes.CacheMode = value
+		case raidTypeKey:
+			if value != "raid1" {
+				return nil, status.Errorf(codes.InvalidArgument, "parameter '%s' only supports raid1", raidTypeKey)
+			}
+			raidType = value
+		case mirrorsKey:
+			mirrors, err := strconv.Atoi(value)
+			if err != nil || mirrors < 1 {
+				return nil, status.Errorf(codes.InvalidArgument, "parameter '%s' must be a positive integer", mirrorsKey)
+			}
+			modification.mirrors = mirrors
+		case encryptionKeyIDKey:
+			if !userTagPattern.MatchString(value) {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s '%s'", encryptionKeyIDKey, value)
+			}
+			modification.encryptionKeyID = value
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "mutable parameter '%s' is not supported", key)
+		}
+	}
+
+	if raidType == "" && modification.mirrors > 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "parameter '%s' requires '%s'", mirrorsKey, raidTypeKey)
+	}
+	if raidType != "" && modification.mirrors == 0 {
+		modification.mirrors = 1
+	}
+	return modification, nil
+}
+
+// modifyVolume applies a modification to an existing volume. Preconditions are checked before anything is changed,
+// and applying the same modification again is a no-op. The secrets are only needed to rotate the key of a volume.
+func (d *Driver) modifyVolume(lv *lvm.LogicalVolume, modification *volumeModification, secrets map[string]string) error {
+	if modification.changes.CacheMode != "" && !lv.Attr.IsCached() {
+		return status.Errorf(codes.InvalidArgument, "cannot set '%s' of lv '%s', which is not cached", cacheModeKey, lv.Name)
+	}
+	currentKeyID := encryptionKeyIDFromTags(lv)
+	rekey := modification.encryptionKeyID != "" && modification.encryptionKeyID != currentKeyID
+	if rekey && !lv.HasTag(encryptedTag) {
+		return status.Errorf(codes.InvalidArgument, "cannot set '%s' of lv '%s', which is not encrypted", encryptionKeyIDKey, lv.Name)
+	}
+	if rekey && secrets[passphraseKey] == "" {
+		return status.Errorf(codes.InvalidArgument, "'%s' requires a '%s' secret", encryptionKeyIDKey, passphraseKey)
+	}
+	if rekey {
+		if err := checkEncryptionKeyRotation(lv); err != nil {
+			return err
+		}
+	}
+	// a raid1 volume is left alone, since changing its number of images is not supported
+	convert := modification.mirrors > 0 && !lv.Attr.IsRaid()
+	if convert && !lv.Attr.IsLinear() {
+		return status.Errorf(codes.InvalidArgument, "cannot convert lv '%s' to raid1, only linear volumes can be converted", lv.Name)
+	}
+
+	if modification.setTags {
+		var current []string
+		for _, tag := range lv.Tags {
+			if !strings.HasPrefix(tag, lvm.OwnershipTag) {
+				current = append(current, tag)
+			}
+		}
+		var add, remove []string
+		for _, tag := range modification.tags {
+			if !slices.Contains(current, tag) {
+				add = append(add, tag)
+			}
+		}
+		for _, tag := range current {
+			if !slices.Contains(modification.tags, tag) {
+				remove = append(remove, tag)
+			}
+		}
+		if len(add) > 0 || len(remove) > 0 {
+			klog.InfoS("Updating LV tags", "vg", lv.VG, "lv", lv.Name, "add", add, "remove", remove)
+			if err := d.lvm.UpdateLVTags(lv.VG, lv.Name, add, remove); err != nil {
+				return status.Errorf(codes.Internal, "failed to update lv tags: %v", err)
+			}
+		}
+	}
+
+	if modification.changes != (lvm.LVChanges{}) {
+		klog.InfoS("Changing LV", "vg", lv.VG, "lv", lv.Name, "changes", modification.changes)
+		if err := d.lvm.ChangeLV(lv.VG, lv.Name, modification.changes); err != nil {
+			return status.Errorf(codes.Internal, "failed to change lv: %v", err)
+		}
+	}
+
+	if convert {
+		klog.InfoS("Converting LV to raid1", "vg", lv.VG, "lv", lv.Name, "mirrors", modification.mirrors)
+		if err := d.lvm.ConvertLVToRaid1(lv.VG, lv.Name, modification.mirrors); err != nil {
+			return status.Errorf(codes.Internal, "failed to convert lv: %v", err)
+		}
+	}
+
+	if rekey {
+		if err := d.rotateEncryptionKey(lv, []byte(secrets[previousPassphraseKey]), []byte(secrets[passphraseKey])); err != nil {
+			return err
+		}
+		add := []string{encryptionKeyIDTagPrefix + modification.encryptionKeyID}
+		var remove []string
+		if currentKeyID != "" {
+			remove = append(remove, encryptionKeyIDTagPrefix+currentKeyID)
+		}
+		if err := d.lvm.UpdateLVTags(lv.VG, lv.Name, add, remove); err != nil {
+			return status.Errorf(codes.Internal, "failed to update lv tags: %v", err)
+		}
+	}
+	return nil
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 
 	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
 )
@@ -50,7 +51,7 @@ func TestCreateVolume(t *testing.T) {
 						// first call returns nil, after createLV returns the mock
 						return getLV, nil
 					},
-					createLV: func(vg, name string, size int64, tags []string) error {
+					createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
 						getLV = &lvm.LogicalVolume{
 							Name: name,
 							VG:   vg,
@@ -93,7 +94,7 @@ func TestCreateVolume(t *testing.T) {
 						Size: 1024 * 1024 * 1024,
 					}, nil
 				},
-				createLV: func(vg, name string, size int64, tags []string) error {
+				createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
 					assert.Fail(t, "createLV should not have been called")
 					return nil
 				},
@@ -129,7 +130,7 @@ func TestCreateVolume(t *testing.T) {
 						Size: 10 * 1024 * 1024 * 1024,
 					}, nil
 				},
-				createLV: func(vg, name string, size int64, tags []string) error {
+				createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
 					assert.Fail(t, "createLV should not have been called")
 					return nil
 				},
@@ -165,7 +166,7 @@ func TestCreateVolume(t *testing.T) {
 						Size: 1024 * 1024 * 1024,
 					}, nil
 				},
-				createLV: func(vg, name string, size int64, tags []string) error {
+				createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
 					assert.Fail(t, "createLV should not have been called")
 					return nil
 				},
@@ -198,7 +199,223 @@ func TestCreateVolume(t *testing.T) {
 				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
 					return nil, nil
 				},
-				createLV: func(vg, name string, size int64, tags []string) error {
+				createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+					assert.Fail(t, "createLV should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should create thin volume in pool",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+					thinPoolKey:    "test-pool",
+				},
+			},
+			mockLVM: func() *mockLVM {
+				var getLV *lvm.LogicalVolume
+
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						if name == "test-pool" {
+							return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "twi-a-tz--"}, nil
+						}
+						return getLV, nil
+					},
+					createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+						assert.Equal(t, lvm.LVOptions{ThinPool: "test-pool"}, opts)
+						getLV = &lvm.LogicalVolume{
+							Name: name,
+							VG:   vg,
+							Size: size,
+							Tags: tags,
+							Pool: opts.ThinPool,
+						}
+
+						return nil
+					},
+				}
+			}(),
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail if thin pool does not exist",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+					thinPoolKey:    "test-pool",
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, nil
+				},
+				createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+					assert.Fail(t, "createLV should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail if thin pool would be overprovisioned",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 2 * 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey:        "test-vg",
+					thinPoolKey:           "test-pool",
+					overprovisionRatioKey: "1.5",
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					if name == "test-pool" {
+						return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "twi-a-tz--", Size: 4 * 1024 * 1024 * 1024}, nil
+					}
+					return nil, nil
+				},
+				listThinVolumes: func(vg, pool string) ([]*lvm.LogicalVolume, error) {
+					assert.Equal(t, "test-vg", vg)
+					assert.Equal(t, "test-pool", pool)
+					return []*lvm.LogicalVolume{
+						{Name: "other-lv", VG: vg, Pool: pool, Size: 5 * 1024 * 1024 * 1024},
+					}, nil
+				},
+				createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+					assert.Fail(t, "createLV should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.ResourceExhausted,
+		},
+		{
+			name: "should create thin volume within overprovisioning ratio",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey:        "test-vg",
+					thinPoolKey:           "test-pool",
+					overprovisionRatioKey: "1.5",
+				},
+			},
+			mockLVM: func() *mockLVM {
+				var getLV *lvm.LogicalVolume
+
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						if name == "test-pool" {
+							return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "twi-a-tz--", Size: 4 * 1024 * 1024 * 1024}, nil
+						}
+						return getLV, nil
+					},
+					listThinVolumes: func(vg, pool string) ([]*lvm.LogicalVolume, error) {
+						return []*lvm.LogicalVolume{
+							{Name: "other-lv", VG: vg, Pool: pool, Size: 5 * 1024 * 1024 * 1024},
+						}, nil
+					},
+					createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+						assert.Contains(t, tags, overprovisionRatioTagPrefix+"1.5")
+						getLV = &lvm.LogicalVolume{
+							Name: name,
+							VG:   vg,
+							Size: size,
+							Tags: tags,
+							Pool: opts.ThinPool,
+						}
+
+						return nil
+					},
+				}
+			}(),
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail on invalid overprovisioning ratio",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey:        "test-vg",
+					thinPoolKey:           "test-pool",
+					overprovisionRatioKey: "-1",
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					if name == "test-pool" {
+						return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "twi-a-tz--"}, nil
+					}
+					return nil, nil
+				},
+				createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
 					assert.Fail(t, "createLV should not have been called")
 					return nil
 				},
@@ -212,27 +429,3464 @@ func TestCreateVolume(t *testing.T) {
 				CapacityRange: &csi.CapacityRange{
 					RequiredBytes: 1024 * 1024 * 1024,
 				},
-				VolumeCapabilities: []*csi.VolumeCapability{
-					{
-						AccessType: &csi.VolumeCapability_Mount{
-							Mount: &csi.VolumeCapability_MountVolume{},
-						},
-						AccessMode: &csi.VolumeCapability_AccessMode{
-							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
-						},
-					},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, fmt.Errorf("some error")
+				},
+				createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+					assert.Fail(t, "createLV should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.Internal,
+		},
+		{
+			name: "should create volume if vg is accessible from the requested topology",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+				},
+				AccessibilityRequirements: &csi.TopologyRequirement{
+					Requisite: []*csi.Topology{
+						{Segments: map[string]string{topologyKeyPrefix + "other-vg": "true"}},
+						{Segments: map[string]string{topologyKeyPrefix + "test-vg": "true"}},
+					},
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Size: 1024 * 1024 * 1024}, nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail if vg is not accessible from the requested topology",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+				},
+				AccessibilityRequirements: &csi.TopologyRequirement{
+					Preferred: []*csi.Topology{
+						{Segments: map[string]string{topologyKeyPrefix + "other-vg": "true"}},
+					},
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					assert.Fail(t, "getLV should not have been called")
+					return nil, nil
+				},
+			},
+			expectedErr: codes.ResourceExhausted,
+		},
+		{
+			name: "should create volume with a whole number of extents",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024*1024*1024 + 1,
+					LimitBytes:    2 * 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+				},
+			},
+			mockLVM: func() *mockLVM {
+				var getLV *lvm.LogicalVolume
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						return getLV, nil
+					},
+					createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+						assert.Equal(t, int64(1024*1024*1024+4*1024*1024), size)
+						getLV = &lvm.LogicalVolume{Name: name, VG: vg, Size: size, Tags: tags}
+						return nil
+					},
+				}
+			}(),
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail if aligned size exceeds the limit",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024*1024*1024 + 1,
+					LimitBytes:    1024*1024*1024 + 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					assert.Fail(t, "getLV should not have been called")
+					return nil, nil
+				},
+			},
+			expectedErr: codes.OutOfRange,
+		},
+		{
+			name: "should fail if vg does not have enough free extents",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, nil
+				},
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return &lvm.VolumeGroup{Name: "test-vg", FreeSize: 512 * 1024 * 1024, Attr: "wz--n-", ExtentSize: 4 * 1024 * 1024}, nil
+				},
+				createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+					assert.Fail(t, "createLV should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.ResourceExhausted,
+		},
+		{
+			name: "should fail if vg does not exist",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+				},
+			},
+			mockLVM: &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return nil, nil
+				},
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should tag encrypted volumes",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+					encryptedKey:   "true",
+				},
+			},
+			mockLVM: func() *mockLVM {
+				var getLV *lvm.LogicalVolume
+
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						return getLV, nil
+					},
+					createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+						assert.Contains(t, tags, encryptedTag)
+						getLV = &lvm.LogicalVolume{
+							Name: name,
+							VG:   vg,
+							Size: size,
+							Tags: tags,
+						}
+
+						return nil
+					},
+				}
+			}(),
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should tag volumes with standalone integrity",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+					integrityKey:   "true",
+				},
+			},
+			mockLVM: func() *mockLVM {
+				var getLV *lvm.LogicalVolume
+
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						return getLV, nil
+					},
+					createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+						assert.Contains(t, tags, integrityTag)
+						assert.False(t, opts.RaidIntegrity)
+						getLV = &lvm.LogicalVolume{
+							Name: name,
+							VG:   vg,
+							Size: size,
+							Tags: tags,
+						}
+
+						return nil
+					},
+				}
+			}(),
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail on invalid encrypted parameter",
+			req: &csi.CreateVolumeRequest{
+				Name: "test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+					encryptedKey:   "maybe",
+				},
+			},
+			mockLVM:     &mockLVM{},
+			expectedErr: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := NewDriver("test-endpoint", tt.allowedVGs, tt.mockLVM.withDefaultVG())
+			_, err := driver.CreateVolume(context.Background(), tt.req)
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+		})
+	}
+}
+
+func TestCreateVolumeMultipleVolumeGroups(t *testing.T) {
+	vgs := map[string]*lvm.VolumeGroup{
+		"vg-a":     {Name: "vg-a", FreeSize: 10 * 1024 * 1024 * 1024, Attr: "wz--n-", ExtentSize: 4 * 1024 * 1024},
+		"vg-b":     {Name: "vg-b", FreeSize: 20 * 1024 * 1024 * 1024, Attr: "wz--n-", ExtentSize: 4 * 1024 * 1024},
+		"vg-small": {Name: "vg-small", FreeSize: 512 * 1024 * 1024, Attr: "wz--n-", ExtentSize: 4 * 1024 * 1024},
+	}
+	newRequest := func(name string, params map[string]string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name: name,
+			CapacityRange: &csi.CapacityRange{
+				RequiredBytes: 1024 * 1024 * 1024,
+			},
+			VolumeCapabilities: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Block{
+						Block: &csi.VolumeCapability_BlockVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			},
+			Parameters: params,
+		}
+	}
+
+	tests := []struct {
+		name        string
+		params      map[string]string
+		allowedVGs  []string
+		existingLVs map[string]string
+		names       []string
+		expectedVGs []string
+		expectedErr codes.Code
+	}{
+		{
+			name:        "should place volume in the vg with most free space by default",
+			params:      map[string]string{volumeGroupsKey: "vg-a, vg-b"},
+			names:       []string{"lv-1"},
+			expectedVGs: []string{"vg-b"},
+		},
+		{
+			name:        "should place volume in the first vg with enough free extents",
+			params:      map[string]string{volumeGroupsKey: "vg-small,vg-a,vg-b", placementKey: placementFirstFit},
+			names:       []string{"lv-1"},
+			expectedVGs: []string{"vg-a"},
+		},
+		{
+			name:        "should spread volumes across vgs in turn",
+			params:      map[string]string{volumeGroupsKey: "vg-a,vg-b", placementKey: placementRoundRobin},
+			names:       []string{"lv-1", "lv-2", "lv-3"},
+			expectedVGs: []string{"vg-a", "vg-b", "vg-a"},
+		},
+		{
+			name:        "should skip vgs that are not allowed",
+			params:      map[string]string{volumeGroupsKey: "vg-a,vg-b"},
+			allowedVGs:  []string{"vg-a"},
+			names:       []string{"lv-1"},
+			expectedVGs: []string{"vg-a"},
+		},
+		{
+			name:        "should return the vg of an existing volume",
+			params:      map[string]string{volumeGroupsKey: "vg-a,vg-b", placementKey: placementFirstFit},
+			existingLVs: map[string]string{"lv-1": "vg-b"},
+			names:       []string{"lv-1"},
+			expectedVGs: []string{"vg-b"},
+		},
+		{
+			name:        "should fail if no vg has enough free extents",
+			params:      map[string]string{volumeGroupsKey: "vg-small"},
+			names:       []string{"lv-1"},
+			expectedErr: codes.ResourceExhausted,
+		},
+		{
+			name:        "should fail if no vg is allowed",
+			params:      map[string]string{volumeGroupsKey: "vg-a,vg-b"},
+			allowedVGs:  []string{"vg-small"},
+			names:       []string{"lv-1"},
+			expectedErr: codes.ResourceExhausted,
+		},
+		{
+			name:        "should fail if placement is invalid",
+			params:      map[string]string{volumeGroupsKey: "vg-a,vg-b", placementKey: "random"},
+			names:       []string{"lv-1"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if both volumeGroup and volumeGroups are set",
+			params:      map[string]string{volumeGroupKey: "vg-a", volumeGroupsKey: "vg-a,vg-b"},
+			names:       []string{"lv-1"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if thin pool is set",
+			params:      map[string]string{volumeGroupsKey: "vg-a,vg-b", thinPoolKey: "test-pool"},
+			names:       []string{"lv-1"},
+			expectedErr: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lvs := map[string]*lvm.LogicalVolume{}
+			for name, vg := range tt.existingLVs {
+				lvs[vg+"/"+name] = &lvm.LogicalVolume{Name: name, VG: vg, Size: 1024 * 1024 * 1024}
+			}
+			mock := &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return vgs[name], nil
+				},
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return lvs[vg+"/"+name], nil
+				},
+				createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+					lvs[vg+"/"+name] = &lvm.LogicalVolume{Name: name, VG: vg, Size: size, Tags: tags}
+					return nil
+				},
+			}
+			driver := NewDriver("test-endpoint", tt.allowedVGs, mock)
+
+			for i, name := range tt.names {
+				resp, err := driver.CreateVolume(context.Background(), newRequest(name, tt.params))
+				if tt.expectedErr != codes.OK {
+					st, ok := status.FromError(err)
+					assert.True(t, ok)
+					assert.Equal(t, tt.expectedErr, st.Code())
+					continue
+				}
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedVGs[i]+"/"+name, resp.Volume.VolumeId)
+			}
+		})
+	}
+}
+
+func TestCreateVolumeLayout(t *testing.T) {
+	newRequest := func(params map[string]string) *csi.CreateVolumeRequest {
+		params[volumeGroupKey] = "test-vg"
+		return &csi.CreateVolumeRequest{
+			Name: "test-lv",
+			CapacityRange: &csi.CapacityRange{
+				RequiredBytes: 1024 * 1024 * 1024,
+			},
+			VolumeCapabilities: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Block{
+						Block: &csi.VolumeCapability_BlockVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			},
+			Parameters: params,
+		}
+	}
+	defaultPVs := []*lvm.PhysicalVolume{
+		{Name: "/dev/sda", VG: "test-vg", FreeSize: 2 * 1024 * 1024 * 1024, Tags: []string{"array-a"}},
+		{Name: "/dev/sdb", VG: "test-vg", FreeSize: 2 * 1024 * 1024 * 1024, Tags: []string{"array-b"}},
+		{Name: "/dev/sdc", VG: "test-vg", FreeSize: 2 * 1024 * 1024 * 1024},
+		{Name: "/dev/sdd", VG: "test-vg", FreeSize: 2 * 1024 * 1024 * 1024},
+		{Name: "/dev/sde", VG: "test-vg", FreeSize: 2 * 1024 * 1024 * 1024},
+		{Name: "/dev/sdf", VG: "test-vg", FreeSize: 2 * 1024 * 1024 * 1024},
+	}
+
+	tests := []struct {
+		name         string
+		params       map[string]string
+		pvs          []*lvm.PhysicalVolume
+		expectedOpts lvm.LVOptions
+		expectedSize int64
+		expectedErr  codes.Code
+	}{
+		{
+			name:         "should create raid1 volume with legs on distinct arrays",
+			params:       map[string]string{lvTypeKey: "raid1", pvTagsKey: "array-a,array-b"},
+			expectedOpts: lvm.LVOptions{Type: "raid1", PVTags: []string{"array-a", "array-b"}},
+			expectedSize: 1024 * 1024 * 1024,
+		},
+		{
+			name:         "should align striped raid volume to its data stripes",
+			params:       map[string]string{lvTypeKey: "raid5", stripesKey: "3"},
+			expectedOpts: lvm.LVOptions{Type: "raid5", Stripes: 3},
+			expectedSize: 1024*1024*1024 + 8*1024*1024,
+		},
+		{
+			name:         "should create raid10 volume",
+			params:       map[string]string{lvTypeKey: "raid10", mirrorsKey: "2", stripesKey: "2"},
+			expectedOpts: lvm.LVOptions{Type: "raid10", Mirrors: 2, Stripes: 2},
+			expectedSize: 1024 * 1024 * 1024,
+		},
+		{
+			name:        "should fail if tagged pvs don't have room for every image",
+			params:      map[string]string{lvTypeKey: "raid1", mirrorsKey: "2", pvTagsKey: "array-a,array-b,array-c"},
+			expectedErr: codes.ResourceExhausted,
+		},
+		{
+			name:   "should fail if pvs don't have room for every image",
+			params: map[string]string{lvTypeKey: "raid1"},
+			pvs: []*lvm.PhysicalVolume{
+				{Name: "/dev/sda", VG: "test-vg", FreeSize: 2 * 1024 * 1024 * 1024},
+				{Name: "/dev/sdb", VG: "test-vg", FreeSize: 768 * 1024 * 1024},
+			},
+			expectedErr: codes.ResourceExhausted,
+		},
+		{
+			name:         "should create striped volume",
+			params:       map[string]string{stripesKey: "4", stripeSizeKey: "64Ki"},
+			expectedOpts: lvm.LVOptions{Stripes: 4, StripeSize: 64 * 1024},
+			expectedSize: 1024 * 1024 * 1024,
+		},
+		{
+			name:   "should fail if there are fewer pvs with free space than stripes",
+			params: map[string]string{stripesKey: "3"},
+			pvs: []*lvm.PhysicalVolume{
+				{Name: "/dev/sda", VG: "test-vg", FreeSize: 2 * 1024 * 1024 * 1024},
+				{Name: "/dev/sdb", VG: "test-vg", FreeSize: 2 * 1024 * 1024 * 1024},
+				{Name: "/dev/sdc", VG: "test-vg", FreeSize: 0},
+			},
+			expectedErr: codes.ResourceExhausted,
+		},
+		{
+			name:        "should fail if stripe size is not a power of two",
+			params:      map[string]string{stripesKey: "2", stripeSizeKey: "48Ki"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if stripe size is set for a linear volume",
+			params:      map[string]string{stripeSizeKey: "64Ki"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:         "should create volume on tagged pvs with an allocation policy",
+			params:       map[string]string{pvTagsKey: "ssd", allocPolicyKey: "cling"},
+			pvs:          []*lvm.PhysicalVolume{{Name: "/dev/sda", VG: "test-vg", FreeSize: 2 * 1024 * 1024 * 1024, Tags: []string{"ssd"}}},
+			expectedOpts: lvm.LVOptions{PVTags: []string{"ssd"}, AllocPolicy: "cling"},
+			expectedSize: 1024 * 1024 * 1024,
+		},
+		{
+			name:   "should fail if tagged pvs don't have room for the volume",
+			params: map[string]string{pvTagsKey: "ssd"},
+			pvs: []*lvm.PhysicalVolume{
+				{Name: "/dev/sda", VG: "test-vg", FreeSize: 512 * 1024 * 1024, Tags: []string{"ssd"}},
+				{Name: "/dev/sdb", VG: "test-vg", FreeSize: 4 * 1024 * 1024 * 1024, Tags: []string{"hdd"}},
+			},
+			expectedErr: codes.ResourceExhausted,
+		},
+		{
+			name:         "should allow stripes on the same pvs if allocation policy is anywhere",
+			params:       map[string]string{stripesKey: "2", allocPolicyKey: "anywhere"},
+			pvs:          []*lvm.PhysicalVolume{{Name: "/dev/sda", VG: "test-vg", FreeSize: 2 * 1024 * 1024 * 1024}},
+			expectedOpts: lvm.LVOptions{Stripes: 2, AllocPolicy: "anywhere"},
+			expectedSize: 1024 * 1024 * 1024,
+		},
+		{
+			name:   "should create vdo volume with a smaller pool",
+			params: map[string]string{lvTypeKey: "vdo", vdoPhysicalSizePercentKey: "50", vdoCompressionKey: "false"},
+			expectedOpts: lvm.LVOptions{
+				Type:             "vdo",
+				VDOPool:          "test-lv_vdopool",
+				VDOPhysicalSize:  512 * 1024 * 1024,
+				VDODeduplication: true,
+			},
+			expectedSize: 1024 * 1024 * 1024,
+		},
+		{
+			name:   "should fail if vg does not have room for the vdo pool",
+			params: map[string]string{lvTypeKey: "vdo", vdoPhysicalSizePercentKey: "50"},
+			pvs: []*lvm.PhysicalVolume{
+				{Name: "/dev/sda", VG: "test-vg", FreeSize: 256 * 1024 * 1024},
+			},
+			expectedErr: codes.ResourceExhausted,
+		},
+		{
+			name:        "should fail if vdo physical size percent is out of range",
+			params:      map[string]string{lvTypeKey: "vdo", vdoPhysicalSizePercentKey: "150"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if vdo parameters are set without vdo lv type",
+			params:      map[string]string{vdoCompressionKey: "true"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if stripes is set for vdo",
+			params:      map[string]string{lvTypeKey: "vdo", stripesKey: "2"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if allocation policy is invalid",
+			params:      map[string]string{allocPolicyKey: "inherit"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if pv tags are set for a thin volume",
+			params:      map[string]string{thinPoolKey: "test-pool", pvTagsKey: "ssd"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if stripes is set for raid1",
+			params:      map[string]string{lvTypeKey: "raid1", stripesKey: "2"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if there are fewer pv tags than images",
+			params:      map[string]string{lvTypeKey: "raid1", mirrorsKey: "2", pvTagsKey: "array-a,array-b"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if lv type is not supported",
+			params:      map[string]string{lvTypeKey: "raid0"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if mirrors is set for raid5",
+			params:      map[string]string{lvTypeKey: "raid5", mirrorsKey: "1"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if raid6 has too few stripes",
+			params:      map[string]string{lvTypeKey: "raid6", stripesKey: "2"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:         "should create raid volume with integrity",
+			params:       map[string]string{lvTypeKey: "raid1", integrityKey: "true"},
+			expectedOpts: lvm.LVOptions{Type: "raid1", RaidIntegrity: true},
+			expectedSize: 1024 * 1024 * 1024,
+		},
+		{
+			name:        "should fail on invalid integrity parameter",
+			params:      map[string]string{lvTypeKey: "raid1", integrityKey: "maybe"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if raid is combined with a thin pool",
+			params:      map[string]string{lvTypeKey: "raid1", thinPoolKey: "test-pool"},
+			expectedErr: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvs := tt.pvs
+			if pvs == nil {
+				pvs = defaultPVs
+			}
+			var freeSize int64
+			for _, pv := range pvs {
+				freeSize += pv.FreeSize
+			}
+			var created *lvm.LogicalVolume
+			mockLVM := &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return &lvm.VolumeGroup{Name: name, FreeSize: freeSize, Attr: "wz--n-", ExtentSize: 4 * 1024 * 1024}, nil
+				},
+				listPVs: func(vg string) ([]*lvm.PhysicalVolume, error) {
+					return pvs, nil
+				},
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return created, nil
+				},
+				createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+					assert.Equal(t, tt.expectedOpts, opts)
+					assert.Equal(t, tt.expectedSize, size)
+					expectedTags := layoutTags(opts)
+					if opts.RaidIntegrity {
+						expectedTags = append(expectedTags, raidIntegrityTag)
+					}
+					assert.ElementsMatch(t, expectedTags, tags[1:])
+					created = &lvm.LogicalVolume{Name: name, VG: vg, Size: size, Tags: tags}
+					return nil
+				},
+			}
+			driver := NewDriver("test-endpoint", nil, mockLVM)
+
+			_, err := driver.CreateVolume(context.Background(), newRequest(tt.params))
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+				assert.NotNil(t, created)
+			} else {
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+		})
+	}
+}
+
+func TestCreateVolumeCache(t *testing.T) {
+	newRequest := func(params map[string]string) *csi.CreateVolumeRequest {
+		params[volumeGroupKey] = "test-vg"
+		return &csi.CreateVolumeRequest{
+			Name: "test-lv",
+			CapacityRange: &csi.CapacityRange{
+				RequiredBytes: 1024 * 1024 * 1024,
+			},
+			VolumeCapabilities: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Block{
+						Block: &csi.VolumeCapability_BlockVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			},
+			Parameters: params,
+		}
+	}
+	pvs := []*lvm.PhysicalVolume{
+		{Name: "/dev/sda", VG: "test-vg", FreeSize: 4 * 1024 * 1024 * 1024, Tags: []string{"hdd"}},
+		{Name: "/dev/nvme0n1", VG: "test-vg", FreeSize: 512 * 1024 * 1024, Tags: []string{"nvme"}},
+	}
+
+	tests := []struct {
+		name              string
+		params            map[string]string
+		existingLV        *lvm.LogicalVolume
+		existingCache     bool
+		expectedCacheOpts lvm.CacheOptions
+		expectedCacheSize int64
+		expectedCreate    bool
+		expectedAttach    bool
+		expectedErr       codes.Code
+	}{
+		{
+			name:              "should create volume with dm-cache pool on the fast pvs",
+			params:            map[string]string{cachePVTagsKey: "nvme", cacheSizeKey: "254Mi", cacheModeKey: "writeback"},
+			expectedCacheOpts: lvm.CacheOptions{Mode: "writeback", PVTags: []string{"nvme"}},
+			expectedCacheSize: 256 * 1024 * 1024,
+			expectedCreate:    true,
+			expectedAttach:    true,
+		},
+		{
+			name:              "should default to writethrough",
+			params:            map[string]string{cachePVTagsKey: "nvme", cacheSizeKey: "256Mi"},
+			expectedCacheOpts: lvm.CacheOptions{Mode: "writethrough", PVTags: []string{"nvme"}},
+			expectedCacheSize: 256 * 1024 * 1024,
+			expectedCreate:    true,
+			expectedAttach:    true,
+		},
+		{
+			name:              "should create volume with dm-writecache",
+			params:            map[string]string{cachePVTagsKey: "nvme", cacheSizeKey: "256Mi", cacheModeKey: "writecache"},
+			expectedCacheOpts: lvm.CacheOptions{Mode: "writecache", PVTags: []string{"nvme"}},
+			expectedCacheSize: 256 * 1024 * 1024,
+			expectedCreate:    true,
+			expectedAttach:    true,
+		},
+		{
+			name:   "should attach the cache a previous attempt left detached",
+			params: map[string]string{cachePVTagsKey: "nvme", cacheSizeKey: "256Mi"},
+			existingLV: &lvm.LogicalVolume{
+				Name: "test-lv",
+				VG:   "test-vg",
+				Size: 1024 * 1024 * 1024,
+				Attr: "-wi-a-----",
+				Tags: []string{lvm.OwnershipTag, cacheModeTagPrefix + "writethrough"},
+			},
+			existingCache:     true,
+			expectedCacheOpts: lvm.CacheOptions{Mode: "writethrough", PVTags: []string{"nvme"}},
+			expectedAttach:    true,
+		},
+		{
+			name:   "should return existing cached volume",
+			params: map[string]string{cachePVTagsKey: "nvme", cacheSizeKey: "256Mi"},
+			existingLV: &lvm.LogicalVolume{
+				Name: "test-lv",
+				VG:   "test-vg",
+				Size: 1024 * 1024 * 1024,
+				Attr: "Cwi-a-C---",
+				Tags: []string{lvm.OwnershipTag, cacheModeTagPrefix + "writethrough"},
+			},
+		},
+		{
+			name:        "should fail if fast pvs don't have room for the cache",
+			params:      map[string]string{cachePVTagsKey: "nvme", cacheSizeKey: "1Gi"},
+			expectedErr: codes.ResourceExhausted,
+		},
+		{
+			name:        "should fail if cache size is set without cache pv tags",
+			params:      map[string]string{cacheSizeKey: "256Mi"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if cache pv tags are set without cache size",
+			params:      map[string]string{cachePVTagsKey: "nvme"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if cache mode is invalid",
+			params:      map[string]string{cachePVTagsKey: "nvme", cacheSizeKey: "256Mi", cacheModeKey: "passthrough"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if cache is combined with a thin pool",
+			params:      map[string]string{cachePVTagsKey: "nvme", cacheSizeKey: "256Mi", thinPoolKey: "test-pool"},
+			expectedErr: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lv := tt.existingLV
+			var cacheCreated, attached bool
+			mockLVM := &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return &lvm.VolumeGroup{Name: name, FreeSize: 4*1024*1024*1024 + 512*1024*1024, Attr: "wz--n-", ExtentSize: 4 * 1024 * 1024}, nil
+				},
+				listPVs: func(vg string) ([]*lvm.PhysicalVolume, error) {
+					return pvs, nil
+				},
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					if name == cacheLVName("test-lv") {
+						if tt.existingCache || cacheCreated {
+							return &lvm.LogicalVolume{Name: name, VG: vg}, nil
+						}
+						return nil, nil
+					}
+					return lv, nil
+				},
+				createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+					assert.Nil(t, tt.existingLV, "createLV should not have been called")
+					assert.Contains(t, tags, cacheModeTagPrefix+tt.expectedCacheOpts.Mode)
+					lv = &lvm.LogicalVolume{Name: name, VG: vg, Size: size, Attr: "-wi-a-----", Tags: tags}
+					return nil
+				},
+				createCacheLV: func(vg, name string, size int64, opts lvm.CacheOptions) error {
+					assert.True(t, tt.expectedCreate, "createCacheLV should not have been called")
+					assert.Equal(t, cacheLVName("test-lv"), name)
+					assert.Equal(t, tt.expectedCacheSize, size)
+					assert.Equal(t, tt.expectedCacheOpts, opts)
+					cacheCreated = true
+					return nil
+				},
+				attachCache: func(vg, name, cache string, opts lvm.CacheOptions) error {
+					assert.Equal(t, "test-lv", name)
+					assert.Equal(t, cacheLVName("test-lv"), cache)
+					assert.Equal(t, tt.expectedCacheOpts, opts)
+					attached = true
+					return nil
+				},
+			}
+			driver := NewDriver("test-endpoint", nil, mockLVM)
+
+			_, err := driver.CreateVolume(context.Background(), newRequest(tt.params))
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedCreate, cacheCreated)
+				assert.Equal(t, tt.expectedAttach, attached)
+			} else {
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+		})
+	}
+}
+
+type mockCopier struct {
+	copy func(sourcePath, targetPath string) error
+}
+
+func (m *mockCopier) Copy(sourcePath, targetPath string) error {
+	return m.copy(sourcePath, targetPath)
+}
+
+func TestCreateVolumeFromSnapshot(t *testing.T) {
+	snapshotLV := &lvm.LogicalVolume{
+		Name:       "test-snap",
+		VG:         "test-vg",
+		Size:       256 * 1024 * 1024,
+		Tags:       []string{lvm.OwnershipTag, lvm.SnapshotTag},
+		Attr:       "swi---s---",
+		Origin:     "source-lv",
+		OriginSize: 1024 * 1024 * 1024,
+	}
+	newRequest := func(size int64) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name: "test-lv",
+			CapacityRange: &csi.CapacityRange{
+				RequiredBytes: size,
+			},
+			VolumeCapabilities: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Block{
+						Block: &csi.VolumeCapability_BlockVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			},
+			Parameters: map[string]string{
+				volumeGroupKey: "test-vg",
+			},
+			VolumeContentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Snapshot{
+					Snapshot: &csi.VolumeContentSource_SnapshotSource{
+						SnapshotId: "test-vg/test-snap",
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		req          *csi.CreateVolumeRequest
+		mockLVM      func(t *testing.T) *mockLVM
+		copyErr      error
+		expectedErr  codes.Code
+		expectedCopy bool
+	}{
+		{
+			name: "should create and populate volume successfully",
+			req:  newRequest(1024 * 1024 * 1024),
+			mockLVM: func(t *testing.T) *mockLVM {
+				var target *lvm.LogicalVolume
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						if name == "test-snap" {
+							return snapshotLV, nil
+						}
+						return target, nil
+					},
+					createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+						assert.Equal(t, []string{lvm.OwnershipTag, lvm.PopulatingTag}, tags)
+						target = &lvm.LogicalVolume{Name: name, VG: vg, Size: size, Tags: tags}
+						return nil
+					},
+					activateLV: func(vg, name string) error {
+						return nil
+					},
+					deactivateLV: func(vg, name string) error {
+						return nil
+					},
+					updateLVTags: func(vg, name string, add, remove []string) error {
+						assert.Equal(t, "test-lv", name)
+						assert.Equal(t, []string{lvm.PopulatingTag}, remove)
+						return nil
+					},
+				}
+			},
+			expectedErr:  codes.OK,
+			expectedCopy: true,
+		},
+		{
+			name: "should resume populating a volume left behind by a failed attempt",
+			req:  newRequest(1024 * 1024 * 1024),
+			mockLVM: func(t *testing.T) *mockLVM {
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						if name == "test-snap" {
+							return snapshotLV, nil
+						}
+						return &lvm.LogicalVolume{
+							Name: "test-lv",
+							VG:   "test-vg",
+							Size: 1024 * 1024 * 1024,
+							Tags: []string{lvm.OwnershipTag, lvm.PopulatingTag},
+						}, nil
+					},
+					createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+						assert.Fail(t, "createLV should not have been called")
+						return nil
+					},
+					activateLV: func(vg, name string) error {
+						return nil
+					},
+					deactivateLV: func(vg, name string) error {
+						return nil
+					},
+					updateLVTags: func(vg, name string, add, remove []string) error {
+						return nil
+					},
+				}
+			},
+			expectedErr:  codes.OK,
+			expectedCopy: true,
+		},
+		{
+			name: "should return success without copying if volume was already populated",
+			req:  newRequest(1024 * 1024 * 1024),
+			mockLVM: func(t *testing.T) *mockLVM {
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						if name == "test-snap" {
+							return snapshotLV, nil
+						}
+						return &lvm.LogicalVolume{
+							Name: "test-lv",
+							VG:   "test-vg",
+							Size: 1024 * 1024 * 1024,
+							Tags: []string{lvm.OwnershipTag},
+						}, nil
+					},
+				}
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail if requested size is smaller than the snapshot",
+			req:  newRequest(512 * 1024 * 1024),
+			mockLVM: func(t *testing.T) *mockLVM {
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						return snapshotLV, nil
+					},
+				}
+			},
+			expectedErr: codes.OutOfRange,
+		},
+		{
+			name: "should fail to restore snapshot of encrypted volume into an unencrypted one",
+			req:  newRequest(1024 * 1024 * 1024),
+			mockLVM: func(t *testing.T) *mockLVM {
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						snapshot := *snapshotLV
+						snapshot.Tags = []string{lvm.OwnershipTag, lvm.SnapshotTag, encryptedTag}
+						return &snapshot, nil
+					},
+				}
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail to restore snapshot of volume with integrity into one without it",
+			req:  newRequest(1024 * 1024 * 1024),
+			mockLVM: func(t *testing.T) *mockLVM {
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						snapshot := *snapshotLV
+						snapshot.Tags = []string{lvm.OwnershipTag, lvm.SnapshotTag, integrityTag}
+						return &snapshot, nil
+					},
+				}
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail if snapshot not found",
+			req:  newRequest(1024 * 1024 * 1024),
+			mockLVM: func(t *testing.T) *mockLVM {
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						return nil, nil
+					},
+				}
+			},
+			expectedErr: codes.NotFound,
+		},
+		{
+			name: "should keep the volume marked as populating if copy fails",
+			req:  newRequest(1024 * 1024 * 1024),
+			mockLVM: func(t *testing.T) *mockLVM {
+				var target *lvm.LogicalVolume
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						if name == "test-snap" {
+							return snapshotLV, nil
+						}
+						return target, nil
+					},
+					createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+						target = &lvm.LogicalVolume{Name: name, VG: vg, Size: size, Tags: tags}
+						return nil
+					},
+					activateLV: func(vg, name string) error {
+						return nil
+					},
+					deactivateLV: func(vg, name string) error {
+						return nil
+					},
+					updateLVTags: func(vg, name string, add, remove []string) error {
+						assert.Fail(t, "updateLVTags should not have been called")
+						return nil
+					},
+				}
+			},
+			copyErr:      fmt.Errorf("some error"),
+			expectedErr:  codes.Internal,
+			expectedCopy: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copied := false
+			driver := NewDriver("test-endpoint", nil, tt.mockLVM(t).withDefaultVG())
+			driver.copier = &mockCopier{
+				copy: func(sourcePath, targetPath string) error {
+					assert.Equal(t, "/dev/test-vg/test-snap", sourcePath)
+					assert.Equal(t, "/dev/test-vg/test-lv", targetPath)
+					copied = true
+					return tt.copyErr
+				},
+			}
+			resp, err := driver.CreateVolume(context.Background(), tt.req)
+			assert.Equal(t, tt.expectedCopy, copied)
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.req.VolumeContentSource, resp.Volume.ContentSource)
+			} else {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+		})
+	}
+}
+
+func TestCreateVolumeFromVolume(t *testing.T) {
+	newRequest := func(sourceID string) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name: "test-lv",
+			CapacityRange: &csi.CapacityRange{
+				RequiredBytes: 1024 * 1024 * 1024,
+			},
+			VolumeCapabilities: []*csi.VolumeCapability{
+				{
+					AccessType: &csi.VolumeCapability_Block{
+						Block: &csi.VolumeCapability_BlockVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+					},
+				},
+			},
+			Parameters: map[string]string{
+				volumeGroupKey: "test-vg",
+			},
+			VolumeContentSource: &csi.VolumeContentSource{
+				Type: &csi.VolumeContentSource_Volume{
+					Volume: &csi.VolumeContentSource_VolumeSource{
+						VolumeId: sourceID,
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		req          *csi.CreateVolumeRequest
+		allowedVGs   []string
+		source       *lvm.LogicalVolume
+		expectedErr  codes.Code
+		expectedCopy bool
+	}{
+		{
+			name: "should clone volume from another volume group",
+			req:  newRequest("source-vg/source-lv"),
+			source: &lvm.LogicalVolume{
+				Name: "source-lv",
+				VG:   "source-vg",
+				Size: 1024 * 1024 * 1024,
+				Tags: []string{lvm.OwnershipTag},
+				Attr: "-wi-------",
+			},
+			expectedErr:  codes.OK,
+			expectedCopy: true,
+		},
+		{
+			name: "should clone active volume without deactivating it",
+			req:  newRequest("source-vg/source-lv"),
+			source: &lvm.LogicalVolume{
+				Name: "source-lv",
+				VG:   "source-vg",
+				Size: 1024 * 1024 * 1024,
+				Tags: []string{lvm.OwnershipTag},
+				Attr: "-wi-a-----",
+			},
+			expectedErr:  codes.OK,
+			expectedCopy: true,
+		},
+		{
+			name:        "should fail if source volume not found",
+			req:         newRequest("source-vg/source-lv"),
+			expectedErr: codes.NotFound,
+		},
+		{
+			name: "should fail if source volume is a snapshot",
+			req:  newRequest("source-vg/source-lv"),
+			source: &lvm.LogicalVolume{
+				Name:   "source-lv",
+				VG:     "source-vg",
+				Tags:   []string{lvm.OwnershipTag, lvm.SnapshotTag},
+				Origin: "other-lv",
+			},
+			expectedErr: codes.NotFound,
+		},
+		{
+			name: "should fail if source volume is still being populated",
+			req:  newRequest("source-vg/source-lv"),
+			source: &lvm.LogicalVolume{
+				Name: "source-lv",
+				VG:   "source-vg",
+				Size: 1024 * 1024 * 1024,
+				Tags: []string{lvm.OwnershipTag, lvm.PopulatingTag},
+			},
+			expectedErr: codes.FailedPrecondition,
+		},
+		{
+			name: "should fail to clone encrypted volume into an unencrypted one",
+			req:  newRequest("source-vg/source-lv"),
+			source: &lvm.LogicalVolume{
+				Name: "source-lv",
+				VG:   "source-vg",
+				Size: 1024 * 1024 * 1024,
+				Tags: []string{lvm.OwnershipTag, encryptedTag},
+				Attr: "-wi-------",
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail to clone volume with integrity into one without it",
+			req:  newRequest("source-vg/source-lv"),
+			source: &lvm.LogicalVolume{
+				Name: "source-lv",
+				VG:   "source-vg",
+				Size: 1024 * 1024 * 1024,
+				Tags: []string{lvm.OwnershipTag, integrityTag},
+				Attr: "-wi-------",
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:        "should fail if source volume group is not allowed",
+			req:         newRequest("source-vg/source-lv"),
+			allowedVGs:  []string{"test-vg"},
+			expectedErr: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target *lvm.LogicalVolume
+			mock := &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					if name == "source-lv" {
+						return tt.source, nil
+					}
+					return target, nil
+				},
+				createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+					target = &lvm.LogicalVolume{Name: name, VG: vg, Size: size, Tags: tags}
+					return nil
+				},
+				activateLV: func(vg, name string) error {
+					if name == "source-lv" {
+						assert.False(t, tt.source.Attr.IsActive(), "active source should not be activated again")
+					}
+					return nil
+				},
+				deactivateLV: func(vg, name string) error {
+					if name == "source-lv" {
+						assert.False(t, tt.source.Attr.IsActive(), "active source should not be deactivated")
+					}
+					return nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					return nil
+				},
+			}
+
+			copied := false
+			driver := NewDriver("test-endpoint", tt.allowedVGs, mock.withDefaultVG())
+			driver.copier = &mockCopier{
+				copy: func(sourcePath, targetPath string) error {
+					assert.Equal(t, "/dev/source-vg/source-lv", sourcePath)
+					assert.Equal(t, "/dev/test-vg/test-lv", targetPath)
+					copied = true
+					return nil
+				},
+			}
+			resp, err := driver.CreateVolume(context.Background(), tt.req)
+			assert.Equal(t, tt.expectedCopy, copied)
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.req.VolumeContentSource, resp.Volume.ContentSource)
+			} else {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+		})
+	}
+}
+
+func TestCreateVolumeThinClone(t *testing.T) {
+	source := &lvm.LogicalVolume{
+		Name: "source-lv",
+		VG:   "test-vg",
+		Size: 1024 * 1024 * 1024,
+		Tags: []string{lvm.OwnershipTag},
+		Attr: "Vwi---tz--",
+		Pool: "test-pool",
+	}
+
+	var target *lvm.LogicalVolume
+	var deactivated []string
+	resized := false
+	mock := &mockLVM{
+		getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+			switch name {
+			case "source-lv":
+				return source, nil
+			case "test-pool":
+				return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "twi-a-tz--"}, nil
+			}
+			return target, nil
+		},
+		createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+			assert.Fail(t, "createLV should not have been called")
+			return nil
+		},
+		createSnapshot: func(vg, name, origin string, size int64, tags []string) error {
+			assert.Equal(t, "source-lv", origin)
+			assert.Equal(t, int64(0), size)
+			assert.Equal(t, []string{lvm.OwnershipTag}, tags)
+			target = &lvm.LogicalVolume{Name: name, VG: vg, Size: source.Size, Tags: tags, Pool: source.Pool}
+			return nil
+		},
+		resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+			resized = true
+			target.Size = size
+			return nil
+		},
+		deactivateLV: func(vg, name string) error {
+			deactivated = append(deactivated, name)
+			return nil
+		},
+	}
+
+	driver := NewDriver("test-endpoint", nil, mock.withDefaultVG())
+	driver.copier = &mockCopier{
+		copy: func(sourcePath, targetPath string) error {
+			assert.Fail(t, "copy should not have been called")
+			return nil
+		},
+	}
+	resp, err := driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name: "test-lv",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 2 * 1024 * 1024 * 1024,
+		},
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Block{
+					Block: &csi.VolumeCapability_BlockVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+				},
+			},
+		},
+		Parameters: map[string]string{
+			volumeGroupKey: "test-vg",
+			thinPoolKey:    "test-pool",
+		},
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{
+					VolumeId: "test-vg/source-lv",
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.True(t, resized)
+	assert.Equal(t, int64(2*1024*1024*1024), resp.Volume.CapacityBytes)
+	assert.Equal(t, []string{"test-lv", "test-pool"}, deactivated, "thin volume and pool should be left inactive")
+}
+
+func TestCreateVolumeThinPoolInUse(t *testing.T) {
+	mock := &mockLVM{
+		getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+			if name == "test-pool" {
+				return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "twi---tz--"}, nil
+			}
+			return nil, nil
+		},
+		listThinVolumes: func(vg, pool string) ([]*lvm.LogicalVolume, error) {
+			return []*lvm.LogicalVolume{
+				{Name: "other-lv", VG: vg, Attr: "Vwi---tz--", Pool: pool, Tags: []string{lvm.OwnershipTag, publishedNodeTag("node-1")}},
+			}, nil
+		},
+		createLV: func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+			assert.Fail(t, "createLV should not have been called")
+			return nil
+		},
+	}
+
+	driver := NewDriver("test-endpoint", nil, mock.withDefaultVG())
+	_, err := driver.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name: "test-lv",
+		CapacityRange: &csi.CapacityRange{
+			RequiredBytes: 1024 * 1024 * 1024,
+		},
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Block{
+					Block: &csi.VolumeCapability_BlockVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+		Parameters: map[string]string{
+			volumeGroupKey: "test-vg",
+			thinPoolKey:    "test-pool",
+		},
+	})
+	st, _ := status.FromError(err)
+	assert.Equal(t, codes.FailedPrecondition, st.Code())
+}
+
+func TestDeleteVolume(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         *csi.DeleteVolumeRequest
+		mockLVM     *mockLVM
+		expectedErr codes.Code
+	}{
+		{
+			name: "should delete volume successfully",
+			req: &csi.DeleteVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					if name == "test-lv" {
+						return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "-wi-a-----"}, nil
+					}
+					return nil, nil
+				},
+				deleteLV: func(vg, name string) error {
+					assert.Equal(t, "test-lv", name)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should remove the cache of a cached volume",
+			req: &csi.DeleteVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "Cwi-a-C---"}, nil
+				},
+				uncache: func(vg, name string) error {
+					assert.Equal(t, "test-lv", name)
+					return nil
+				},
+				deleteLV: func(vg, name string) error {
+					assert.Equal(t, "test-lv", name)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should remove the pool of a vdo volume",
+			req: &csi.DeleteVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					if name == "test-lv" {
+						return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "vwi-a-v---", Pool: "test-lv_vdopool"}, nil
+					}
+					return nil, nil
+				},
+				deleteLV: func(vg, name string) error {
+					assert.Equal(t, "test-lv_vdopool", name)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should remove a cache lv left detached",
+			req: &csi.DeleteVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "-wi-a-----"}, nil
+				},
+				deleteLV: func() func(vg, name string) error {
+					expected := []string{"test-lv_cache", "test-lv"}
+					return func(vg, name string) error {
+						assert.Equal(t, expected[0], name)
+						expected = expected[1:]
+						return nil
+					}
+				}(),
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should return success if volume not found",
+			req: &csi.DeleteVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, nil
+				},
+				deleteLV: func(vg, name string) error {
+					return fmt.Errorf("not found")
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail on invalid volume id",
+			req: &csi.DeleteVolumeRequest{
+				VolumeId: "invalid-id",
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail if volume has snapshots",
+			req: &csi.DeleteVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+			},
+			mockLVM: &mockLVM{
+				listSnapshots: func(vg string) ([]*lvm.LogicalVolume, error) {
+					return []*lvm.LogicalVolume{
+						{Name: "test-snap", VG: "test-vg", Attr: "swi---s---", Origin: "test-lv"},
+					}, nil
+				},
+				deleteLV: func(vg, name string) error {
+					assert.Fail(t, "deleteLV should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.FailedPrecondition,
+		},
+		{
+			name: "should fail on internal error",
+			req: &csi.DeleteVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, nil
+				},
+				deleteLV: func(vg, name string) error {
+					return fmt.Errorf("some other error")
+				},
+			},
+			expectedErr: codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := NewDriver("test-endpoint", nil, tt.mockLVM)
+			_, err := driver.DeleteVolume(context.Background(), tt.req)
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+		})
+	}
+}
+
+func TestControllerPublishVolume(t *testing.T) {
+	mountCapability := func(mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: mode,
+			},
+		}
+	}
+	blockCapability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{
+			Block: &csi.VolumeCapability_BlockVolume{},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{
+			Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+		},
+	}
+
+	tests := []struct {
+		name        string
+		req         *csi.ControllerPublishVolumeRequest
+		mockLVM     *mockLVM
+		expectedErr codes.Code
+	}{
+		{
+			name: "should publish volume successfully",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId:         "test-vg/test-lv",
+				NodeId:           "node-1",
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Tags: []string{lvm.OwnershipTag}}, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Equal(t, []string{publishedNodeTag("node-1")}, add)
+					assert.Empty(t, remove)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail if thin pool is in use on another node",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId:         "test-vg/test-lv",
+				NodeId:           "node-1",
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "Vwi---tz--", Pool: "test-pool", Tags: []string{lvm.OwnershipTag}}, nil
+				},
+				listThinVolumes: func(vg, pool string) ([]*lvm.LogicalVolume, error) {
+					return []*lvm.LogicalVolume{
+						{Name: "test-lv", VG: vg, Attr: "Vwi---tz--", Pool: pool},
+						{Name: "other-lv", VG: vg, Attr: "Vwi---tz--", Pool: pool, Tags: []string{publishedNodeTag("node-2")}},
+					}, nil
+				},
+			},
+			expectedErr: codes.FailedPrecondition,
+		},
+		{
+			name: "should publish thin volume to the node its pool is in use on",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId:         "test-vg/test-lv",
+				NodeId:           "node-1",
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "Vwi---tz--", Pool: "test-pool", Tags: []string{lvm.OwnershipTag}}, nil
+				},
+				listThinVolumes: func(vg, pool string) ([]*lvm.LogicalVolume, error) {
+					return []*lvm.LogicalVolume{
+						{Name: "other-lv", VG: vg, Attr: "Vwi---tz--", Pool: pool, Tags: []string{publishedNodeTag("node-1")}},
+					}, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Equal(t, []string{publishedNodeTag("node-1")}, add)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should abort raid check of the raid monitor before publishing",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId:         "test-vg/test-lv",
+				NodeId:           "node-1",
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			},
+			mockLVM: func() *mockLVM {
+				deactivated := false
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "rwi-a-r---", Tags: []string{lvm.OwnershipTag, scrubbingTag, scrubActivatedTag}}, nil
+					},
+					deactivateLV: func(vg, name string) error {
+						deactivated = true
+						return nil
+					},
+					updateLVTags: func(vg, name string, add, remove []string) error {
+						assert.True(t, deactivated, "lv should be deactivated before it's published")
+						if len(remove) > 0 {
+							assert.Equal(t, []string{scrubbingTag, scrubActivatedTag}, remove)
+						} else {
+							assert.Equal(t, []string{publishedNodeTag("node-1")}, add)
+						}
+						return nil
+					},
+				}
+			}(),
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should return success if already published to node",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId:         "test-vg/test-lv",
+				NodeId:           "node-1",
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Tags: []string{lvm.OwnershipTag, publishedNodeTag("node-1")}}, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Fail(t, "updateLVTags should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail if single node volume is published to another node",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId:         "test-vg/test-lv",
+				NodeId:           "node-2",
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Tags: []string{lvm.OwnershipTag, publishedNodeTag("node-1")}}, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Fail(t, "updateLVTags should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.FailedPrecondition,
+		},
+		{
+			name: "should publish multi node block volume to another node",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId:         "test-vg/test-lv",
+				NodeId:           "node-2",
+				VolumeCapability: blockCapability,
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Tags: []string{lvm.OwnershipTag, publishedNodeTag("node-1")}}, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Equal(t, []string{publishedNodeTag("node-2")}, add)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should publish single node block volume to another node",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				NodeId:   "node-2",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Block{
+						Block: &csi.VolumeCapability_BlockVolume{},
+					},
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Tags: []string{lvm.OwnershipTag, publishedNodeTag("node-1")}}, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Equal(t, []string{publishedNodeTag("node-2")}, add)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail if volume not found",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId:         "test-vg/test-lv",
+				NodeId:           "node-1",
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, nil
+				},
+			},
+			expectedErr: codes.NotFound,
+		},
+		{
+			name: "should fail if node id is missing",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId:         "test-vg/test-lv",
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail on internal error on update lv tags",
+			req: &csi.ControllerPublishVolumeRequest{
+				VolumeId:         "test-vg/test-lv",
+				NodeId:           "node-1",
+				VolumeCapability: mountCapability(csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER),
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Tags: []string{lvm.OwnershipTag}}, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					return fmt.Errorf("some error")
+				},
+			},
+			expectedErr: codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := NewDriver("test-endpoint", nil, tt.mockLVM)
+			resp, err := driver.ControllerPublishVolume(context.Background(), tt.req)
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+				assert.Equal(t, map[string]string{publishedNodeKey: tt.req.NodeId}, resp.PublishContext)
+			} else {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+		})
+	}
+}
+
+func TestControllerUnpublishVolume(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         *csi.ControllerUnpublishVolumeRequest
+		mockLVM     *mockLVM
+		expectedErr codes.Code
+	}{
+		{
+			name: "should unpublish volume successfully",
+			req: &csi.ControllerUnpublishVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				NodeId:   "node-1",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Tags: []string{lvm.OwnershipTag, publishedNodeTag("node-1"), publishedNodeTag("node-2")}}, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Empty(t, add)
+					assert.Equal(t, []string{publishedNodeTag("node-1")}, remove)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should unpublish volume from all nodes if node id is empty",
+			req: &csi.ControllerUnpublishVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Tags: []string{lvm.OwnershipTag, publishedNodeTag("node-1"), publishedNodeTag("node-2")}}, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Equal(t, []string{publishedNodeTag("node-1"), publishedNodeTag("node-2")}, remove)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should return success if not published to node",
+			req: &csi.ControllerUnpublishVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				NodeId:   "node-1",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Tags: []string{lvm.OwnershipTag}}, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Fail(t, "updateLVTags should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should return success if volume not found",
+			req: &csi.ControllerUnpublishVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				NodeId:   "node-1",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail on invalid volume id",
+			req: &csi.ControllerUnpublishVolumeRequest{
+				VolumeId: "invalid-id",
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := NewDriver("test-endpoint", nil, tt.mockLVM)
+			_, err := driver.ControllerUnpublishVolume(context.Background(), tt.req)
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+		})
+	}
+}
+
+func TestControllerExpandVolume(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         *csi.ControllerExpandVolumeRequest
+		mockLVM     *mockLVM
+		expectedErr codes.Code
+	}{
+		{
+			name: "should expand volume successfully",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 2 * 1024 * 1024 * 1024,
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Size: 1024 * 1024 * 1024,
+						Attr: "-wi-a-----",
+					}, nil
+				},
+				resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should expand striped volume with its stripe geometry",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 2 * 1024 * 1024 * 1024,
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Size: 1024 * 1024 * 1024,
+						Attr: "-wi-a-----",
+						Tags: []string{lvm.OwnershipTag, stripesTagPrefix + "2", stripeSizeTagPrefix + "65536"},
+					}, nil
+				},
+				listPVs: func(vg string) ([]*lvm.PhysicalVolume, error) {
+					return []*lvm.PhysicalVolume{
+						{Name: "/dev/sda", VG: "test-vg", FreeSize: 1024 * 1024 * 1024},
+						{Name: "/dev/sdb", VG: "test-vg", FreeSize: 1024 * 1024 * 1024},
+					}, nil
+				},
+				resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+					assert.Equal(t, lvm.LVOptions{Stripes: 2, StripeSize: 65536}, opts)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should expand volume on the pvs and with the allocation policy it was created with",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 2 * 1024 * 1024 * 1024,
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Size: 1024 * 1024 * 1024,
+						Attr: "-wi-a-----",
+						Tags: []string{lvm.OwnershipTag, pvTagTagPrefix + "ssd", allocPolicyTagPrefix + "contiguous"},
+					}, nil
+				},
+				listPVs: func(vg string) ([]*lvm.PhysicalVolume, error) {
+					return []*lvm.PhysicalVolume{
+						{Name: "/dev/sda", VG: "test-vg", FreeSize: 1024 * 1024 * 1024, LargestFreeSegment: 1024 * 1024 * 1024, Tags: []string{"ssd"}},
+					}, nil
+				},
+				resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+					assert.Equal(t, lvm.LVOptions{PVTags: []string{"ssd"}, AllocPolicy: "contiguous"}, opts)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should grow the pool of a vdo volume along with it",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 2 * 1024 * 1024 * 1024,
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Size: 1024 * 1024 * 1024,
+						Attr: "vwi-a-v---",
+						Pool: "test-lv_vdopool",
+						Tags: []string{lvm.OwnershipTag},
+					}, nil
+				},
+				getVDOPool: func(vg, name string) (*lvm.VDOPool, error) {
+					return &lvm.VDOPool{Name: name, VG: vg, Size: 256 * 1024 * 1024}, nil
+				},
+				resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+					switch name {
+					case "test-lv_vdopool":
+						assert.Equal(t, int64(512*1024*1024), size)
+					case "test-lv":
+						assert.Equal(t, int64(2*1024*1024*1024), size)
+					default:
+						assert.Fail(t, "unexpected lv resized", name)
+					}
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should detach the cache of a cached volume while it's extended",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 2 * 1024 * 1024 * 1024,
+				},
+			},
+			mockLVM: func() *mockLVM {
+				var calls []string
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						if name == cacheLVName("test-lv") {
+							return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "Cwi---C---"}, nil
+						}
+						return &lvm.LogicalVolume{
+							Name: "test-lv",
+							VG:   "test-vg",
+							Size: 1024 * 1024 * 1024,
+							Attr: "Cwi-a-C---",
+							Tags: []string{lvm.OwnershipTag, cacheModeTagPrefix + "writeback"},
+						}, nil
+					},
+					splitCache: func(vg, name string) error {
+						calls = append(calls, "split")
+						return nil
+					},
+					resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+						calls = append(calls, "resize")
+						return nil
+					},
+					attachCache: func(vg, name, cache string, opts lvm.CacheOptions) error {
+						calls = append(calls, "attach")
+						assert.Equal(t, []string{"split", "resize", "attach"}, calls)
+						assert.Equal(t, lvm.CacheOptions{Mode: "writeback"}, opts)
+						return nil
+					},
+				}
+			}(),
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail if striped volume can't be extended on enough pvs",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 2 * 1024 * 1024 * 1024,
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Size: 1024 * 1024 * 1024,
+						Attr: "-wi-a-----",
+						Tags: []string{lvm.OwnershipTag, stripesTagPrefix + "2"},
+					}, nil
+				},
+				listPVs: func(vg string) ([]*lvm.PhysicalVolume, error) {
+					return []*lvm.PhysicalVolume{
+						{Name: "/dev/sda", VG: "test-vg", FreeSize: 2 * 1024 * 1024 * 1024},
+					}, nil
+				},
+				resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+					assert.Fail(t, "resizeLV should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.ResourceExhausted,
+		},
+		{
+			name: "should resize volume to a whole number of extents",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 2*1024*1024*1024 + 1,
+					LimitBytes:    3 * 1024 * 1024 * 1024,
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Size: 1024 * 1024 * 1024,
+						Attr: "-wi-a-----",
+					}, nil
+				},
+				resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+					assert.Equal(t, int64(2*1024*1024*1024+4*1024*1024), size)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail if aligned size exceeds the limit",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 2*1024*1024*1024 + 1,
+					LimitBytes:    2*1024*1024*1024 + 1024,
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Size: 1024 * 1024 * 1024,
+						Attr: "-wi-a-----",
+					}, nil
+				},
+				resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+					assert.Fail(t, "resizeLV should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.OutOfRange,
+		},
+		{
+			name: "should fail if vg does not have enough free extents",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 2 * 1024 * 1024 * 1024,
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Size: 1024 * 1024 * 1024,
+						Attr: "-wi-a-----",
+					}, nil
+				},
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return &lvm.VolumeGroup{Name: "test-vg", FreeSize: 512 * 1024 * 1024, Attr: "wz--n-", ExtentSize: 4 * 1024 * 1024}, nil
+				},
+				resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+					assert.Fail(t, "resizeLV should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.ResourceExhausted,
+		},
+		{
+			name: "should return success if volume is already large enough",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 1024 * 1024 * 1024,
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Size: 2 * 1024 * 1024 * 1024,
+						Attr: "-wi-a-----",
+					}, nil
+				},
+				resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+					assert.Fail(t, "resizeLV should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail if volume not found",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 2 * 1024 * 1024 * 1024,
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, nil
+				},
+				resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+					assert.Fail(t, "resizeLV should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.NotFound,
+		},
+		{
+			name: "should fail on invalid volume id",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "invalid-id",
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail on internal error on get lv",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 2 * 1024 * 1024 * 1024,
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, fmt.Errorf("some error")
+				},
+				resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+					assert.Fail(t, "resizeLV should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.Internal,
+		},
+		{
+			name: "should fail if thin pool would be overprovisioned",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 4 * 1024 * 1024 * 1024,
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					if name == "test-pool" {
+						return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "twi-a-tz--", Size: 4 * 1024 * 1024 * 1024}, nil
+					}
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Size: 1024 * 1024 * 1024,
+						Attr: "Vwi-a-tz--",
+						Pool: "test-pool",
+						Tags: []string{lvm.OwnershipTag, overprovisionRatioTagPrefix + "1"},
+					}, nil
+				},
+				listThinVolumes: func(vg, pool string) ([]*lvm.LogicalVolume, error) {
+					return []*lvm.LogicalVolume{
+						{Name: "test-lv", VG: vg, Pool: pool, Size: 1024 * 1024 * 1024},
+						{Name: "other-lv", VG: vg, Pool: pool, Size: 1024 * 1024 * 1024},
+					}, nil
+				},
+				resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+					assert.Fail(t, "resizeLV should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.ResourceExhausted,
+		},
+		{
+			name: "should fail on internal error on resize lv",
+			req: &csi.ControllerExpandVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+				CapacityRange: &csi.CapacityRange{
+					RequiredBytes: 2 * 1024 * 1024 * 1024,
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Size: 1024 * 1024 * 1024,
+						Attr: "-wi-a-----",
+					}, nil
+				},
+				resizeLV: func(vg, name string, size int64, opts lvm.LVOptions) error {
+					return fmt.Errorf("some other error")
+				},
+			},
+			expectedErr: codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := NewDriver("test-endpoint", nil, tt.mockLVM.withDefaultVG())
+			_, err := driver.ControllerExpandVolume(context.Background(), tt.req)
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+		})
+	}
+}
+
+func TestListVolumes(t *testing.T) {
+	lvs := map[string][]*lvm.LogicalVolume{
+		"vg1": {
+			{Name: "lv-b", VG: "vg1", Size: 2048, Attr: "-wi-a---p-", Tags: []string{lvm.OwnershipTag}},
+			{Name: "lv-a", VG: "vg1", Size: 1024, Attr: "-wi-a-----", Tags: []string{lvm.OwnershipTag}},
+			{Name: "snap-a", VG: "vg1", Size: 512, Attr: "swi-a-s---", Tags: []string{lvm.OwnershipTag, lvm.SnapshotTag}, Origin: "lv-a"},
+		},
+		"vg2": {
+			{Name: "lv-c", VG: "vg2", Size: 4096, Attr: "-wi-a-----", Tags: []string{lvm.OwnershipTag}},
+		},
+	}
+	mock := &mockLVM{
+		listOwnedLVs: func(vg string) ([]*lvm.LogicalVolume, error) {
+			return lvs[vg], nil
+		},
+		getVG: func(name string) (*lvm.VolumeGroup, error) {
+			return &lvm.VolumeGroup{Name: name, Attr: "wz--n-"}, nil
+		},
+	}
+
+	tests := []struct {
+		name              string
+		req               *csi.ListVolumesRequest
+		mockLVM           *mockLVM
+		expectedErr       codes.Code
+		expectedIDs       []string
+		expectedAbnormal  []string
+		expectedNextToken string
+	}{
+		{
+			name:             "should list all volumes sorted by id",
+			req:              &csi.ListVolumesRequest{},
+			mockLVM:          mock,
+			expectedErr:      codes.OK,
+			expectedIDs:      []string{"vg1/lv-a", "vg1/lv-b", "vg2/lv-c"},
+			expectedAbnormal: []string{"vg1/lv-b"},
+		},
+		{
+			name: "should paginate volumes",
+			req: &csi.ListVolumesRequest{
+				MaxEntries: 2,
+			},
+			mockLVM:           mock,
+			expectedErr:       codes.OK,
+			expectedIDs:       []string{"vg1/lv-a", "vg1/lv-b"},
+			expectedAbnormal:  []string{"vg1/lv-b"},
+			expectedNextToken: "vg2/lv-c",
+		},
+		{
+			name: "should continue from starting token",
+			req: &csi.ListVolumesRequest{
+				MaxEntries:    2,
+				StartingToken: "vg2/lv-c",
+			},
+			mockLVM:     mock,
+			expectedErr: codes.OK,
+			expectedIDs: []string{"vg2/lv-c"},
+		},
+		{
+			name: "should fail on unknown starting token",
+			req: &csi.ListVolumesRequest{
+				StartingToken: "vg2/lv-gone",
+			},
+			mockLVM:     mock,
+			expectedErr: codes.Aborted,
+		},
+		{
+			name: "should fail on internal error on get vg",
+			req:  &csi.ListVolumesRequest{},
+			mockLVM: &mockLVM{
+				listOwnedLVs: mock.listOwnedLVs,
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return nil, fmt.Errorf("some error")
+				},
+			},
+			expectedErr: codes.Internal,
+		},
+		{
+			name: "should fail on internal error",
+			req:  &csi.ListVolumesRequest{},
+			mockLVM: &mockLVM{
+				listOwnedLVs: func(vg string) ([]*lvm.LogicalVolume, error) {
+					return nil, fmt.Errorf("some error")
+				},
+			},
+			expectedErr: codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := NewDriver("test-endpoint", []string{"vg1", "vg2"}, tt.mockLVM)
+			resp, err := driver.ListVolumes(context.Background(), tt.req)
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+				var ids, abnormal []string
+				for _, entry := range resp.Entries {
+					ids = append(ids, entry.Volume.VolumeId)
+					if entry.Status.VolumeCondition.Abnormal {
+						abnormal = append(abnormal, entry.Volume.VolumeId)
+					}
+				}
+				assert.Equal(t, tt.expectedIDs, ids)
+				assert.Equal(t, tt.expectedAbnormal, abnormal)
+				assert.Equal(t, tt.expectedNextToken, resp.NextToken)
+			} else {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+		})
+	}
+}
+
+func TestGetCapacity(t *testing.T) {
+	tests := []struct {
+		name         string
+		req          *csi.GetCapacityRequest
+		allowedVGs   []string
+		mockLVM      *mockLVM
+		expectedErr  codes.Code
+		expectedResp *csi.GetCapacityResponse
+	}{
+		{
+			name: "should return capacity for specific VG",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+				},
+			},
+			allowedVGs: []string{"test-vg"},
+			mockLVM: &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					if name == "test-vg" {
+						return &lvm.VolumeGroup{
+							Name:       "test-vg",
+							FreeSize:   100,
+							Attr:       "wz--n-",
+							ExtentSize: 4,
+						}, nil
+					}
+					return nil, nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 100,
+				MaximumVolumeSize: wrapperspb.Int64(100),
+				MinimumVolumeSize: wrapperspb.Int64(4),
+			},
+		},
+		{
+			name: "should return largest free area as maximum size if allocation is contiguous",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+				},
+			},
+			mockLVM: &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return &lvm.VolumeGroup{Name: "test-vg", FreeSize: 100, Attr: "wz--c-", ExtentSize: 4}, nil
+				},
+				listPVs: func(vg string) ([]*lvm.PhysicalVolume, error) {
+					return []*lvm.PhysicalVolume{
+						{Name: "/dev/sda", VG: "test-vg", FreeSize: 60, LargestFreeSegment: 40},
+						{Name: "/dev/sdb", VG: "test-vg", FreeSize: 40, LargestFreeSegment: 40},
+					}, nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 100,
+				MaximumVolumeSize: wrapperspb.Int64(40),
+				MinimumVolumeSize: wrapperspb.Int64(4),
+			},
+		},
+		{
+			name: "should report logical capacity of vdo volumes",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey:            "test-vg",
+					lvTypeKey:                 "vdo",
+					vdoPhysicalSizePercentKey: "25",
+				},
+			},
+			mockLVM: &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return &lvm.VolumeGroup{Name: "test-vg", FreeSize: 100, Attr: "wz--n-", ExtentSize: 4}, nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 400,
+				MaximumVolumeSize: wrapperspb.Int64(400),
+				MinimumVolumeSize: wrapperspb.Int64(4),
+			},
+		},
+		{
+			name: "should only count pvs with the requested tags",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+					pvTagsKey:      "ssd",
+				},
+			},
+			mockLVM: &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return &lvm.VolumeGroup{Name: "test-vg", FreeSize: 100, Attr: "wz--n-", ExtentSize: 4}, nil
+				},
+				listPVs: func(vg string) ([]*lvm.PhysicalVolume, error) {
+					return []*lvm.PhysicalVolume{
+						{Name: "/dev/sda", VG: "test-vg", FreeSize: 60, LargestFreeSegment: 40, Tags: []string{"hdd"}},
+						{Name: "/dev/sdb", VG: "test-vg", FreeSize: 24, LargestFreeSegment: 16, Tags: []string{"ssd"}},
+						{Name: "/dev/sdc", VG: "test-vg", FreeSize: 16, LargestFreeSegment: 16, Tags: []string{"ssd"}},
+					}, nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 40,
+				MaximumVolumeSize: wrapperspb.Int64(40),
+				MinimumVolumeSize: wrapperspb.Int64(4),
+			},
+		},
+		{
+			name: "should apply the requested allocation policy over the one of the vg",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+					pvTagsKey:      "ssd",
+					allocPolicyKey: "contiguous",
+				},
+			},
+			mockLVM: &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return &lvm.VolumeGroup{Name: "test-vg", FreeSize: 100, Attr: "wz--n-", ExtentSize: 4}, nil
+				},
+				listPVs: func(vg string) ([]*lvm.PhysicalVolume, error) {
+					return []*lvm.PhysicalVolume{
+						{Name: "/dev/sda", VG: "test-vg", FreeSize: 60, LargestFreeSegment: 40, Tags: []string{"hdd"}},
+						{Name: "/dev/sdb", VG: "test-vg", FreeSize: 24, LargestFreeSegment: 16, Tags: []string{"ssd"}},
+						{Name: "/dev/sdc", VG: "test-vg", FreeSize: 16, LargestFreeSegment: 16, Tags: []string{"ssd"}},
+					}, nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 40,
+				MaximumVolumeSize: wrapperspb.Int64(16),
+				MinimumVolumeSize: wrapperspb.Int64(4),
+			},
+		},
+		{
+			name: "should not report maximum volume size if no pv has the requested tags",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+					pvTagsKey:      "nvme",
+				},
+			},
+			mockLVM: &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return &lvm.VolumeGroup{Name: "test-vg", FreeSize: 100, Attr: "wz--n-", ExtentSize: 4}, nil
+				},
+				listPVs: func(vg string) ([]*lvm.PhysicalVolume, error) {
+					return []*lvm.PhysicalVolume{
+						{Name: "/dev/sda", VG: "test-vg", FreeSize: 60, LargestFreeSegment: 40, Tags: []string{"hdd"}},
+					}, nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 0,
+				MinimumVolumeSize: wrapperspb.Int64(4),
+			},
+		},
+		{
+			name: "should fail if pvs can't be listed for a contiguous VG",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+				},
+			},
+			mockLVM: &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return &lvm.VolumeGroup{Name: "test-vg", FreeSize: 100, Attr: "wz--c-", ExtentSize: 4}, nil
+				},
+				listPVs: func(vg string) ([]*lvm.PhysicalVolume, error) {
+					return nil, fmt.Errorf("lvm error")
+				},
+			},
+			expectedErr: codes.Internal,
+		},
+		{
+			name: "should return free space of thin pool",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+					thinPoolKey:    "test-pool",
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name:        "test-pool",
+						VG:          "test-vg",
+						Size:        1000,
+						Attr:        "twi-a-tz--",
+						DataPercent: 40,
+						UsageKnown:  true,
+					}, nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 600,
+			},
+		},
+		{
+			name: "should report unallocated space of inactive thin pool",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+					thinPoolKey:    "test-pool",
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: "test-pool", VG: "test-vg", Size: 1000, Attr: "twi---tz--"}, nil
+				},
+				listThinVolumes: func(vg, pool string) ([]*lvm.LogicalVolume, error) {
+					return []*lvm.LogicalVolume{{Name: "lv1", Size: 300}}, nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 700,
+			},
+		},
+		{
+			name: "should limit maximum size of thin volumes by the overprovisioning ratio",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey:        "test-vg",
+					thinPoolKey:           "test-pool",
+					overprovisionRatioKey: "2",
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: "test-pool", VG: "test-vg", Size: 1000, Attr: "twi-a-tz--", UsageKnown: true}, nil
+				},
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return &lvm.VolumeGroup{Name: "test-vg", Attr: "wz--n-", ExtentSize: 4}, nil
+				},
+				listThinVolumes: func(vg, pool string) ([]*lvm.LogicalVolume, error) {
+					return []*lvm.LogicalVolume{{Name: "lv1", Size: 1500}}, nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 1000,
+				MaximumVolumeSize: wrapperspb.Int64(500),
+				MinimumVolumeSize: wrapperspb.Int64(4),
+			},
+		},
+		{
+			name: "should fail if thin pool does not exist",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+					thinPoolKey:    "test-pool",
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, nil
+				},
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name:       "should return capacity for allowed VGs (implicit)",
+			req:        &csi.GetCapacityRequest{},
+			allowedVGs: []string{"vg1", "vg2"},
+			mockLVM: &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					if name == "vg1" {
+						return &lvm.VolumeGroup{Name: "vg1", FreeSize: 100, Attr: "wz--n-", ExtentSize: 4}, nil
+					}
+					if name == "vg2" {
+						return &lvm.VolumeGroup{Name: "vg2", FreeSize: 200, Attr: "wz--n-", ExtentSize: 2}, nil
+					}
+					return nil, nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 300,
+				MaximumVolumeSize: wrapperspb.Int64(200),
+				MinimumVolumeSize: wrapperspb.Int64(2),
+			},
+		},
+		{
+			name: "should return capacity for allowed VGs of a multi-VG StorageClass",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupsKey: "vg1,vg2,vg3",
+				},
+			},
+			allowedVGs: []string{"vg1", "vg2"},
+			mockLVM: &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					assert.NotEqual(t, "vg3", name)
+					return &lvm.VolumeGroup{Name: name, FreeSize: 100, Attr: "wz--n-", ExtentSize: 4}, nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 200,
+				MaximumVolumeSize: wrapperspb.Int64(100),
+				MinimumVolumeSize: wrapperspb.Int64(4),
+			},
+		},
+		{
+			name:        "should return 0 if no allowed VGs configured and no param",
+			req:         &csi.GetCapacityRequest{},
+			allowedVGs:  nil,
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 0,
+			},
+		},
+		{
+			name: "should fail if requested VG is not allowed",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey: "forbidden-vg",
+				},
+			},
+			allowedVGs:  []string{"allowed-vg"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail if get vg error on specific request",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+				},
+			},
+			allowedVGs: []string{"test-vg"},
+			mockLVM: &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return nil, fmt.Errorf("lvm error")
+				},
+			},
+			expectedErr: codes.Internal,
+		},
+		{
+			name:       "should skip if get vg error on implicit request",
+			req:        &csi.GetCapacityRequest{},
+			allowedVGs: []string{"vg1", "error-vg"},
+			mockLVM: &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					if name == "vg1" {
+						return &lvm.VolumeGroup{Name: "vg1", FreeSize: 100, Attr: "wz--n-", ExtentSize: 4}, nil
+					}
+					return nil, fmt.Errorf("lvm error")
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 100,
+				MaximumVolumeSize: wrapperspb.Int64(100),
+				MinimumVolumeSize: wrapperspb.Int64(4),
+			},
+		},
+		{
+			name: "should return capacity for VG visible from topology",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+				},
+				AccessibleTopology: &csi.Topology{
+					Segments: map[string]string{topologyKeyPrefix + "test-vg": "true"},
+				},
+			},
+			mockLVM: &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return &lvm.VolumeGroup{Name: "test-vg", FreeSize: 100, Attr: "wz--n-", ExtentSize: 4}, nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 100,
+				MaximumVolumeSize: wrapperspb.Int64(100),
+				MinimumVolumeSize: wrapperspb.Int64(4),
+			},
+		},
+		{
+			name: "should return 0 if VG is not visible from topology",
+			req: &csi.GetCapacityRequest{
+				Parameters: map[string]string{
+					volumeGroupKey: "test-vg",
+					thinPoolKey:    "test-pool",
+				},
+				AccessibleTopology: &csi.Topology{
+					Segments: map[string]string{topologyKeyPrefix + "other-vg": "true"},
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					assert.Fail(t, "getLV should not have been called")
+					return nil, nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 0,
+			},
+		},
+		{
+			name: "should only sum allowed VGs visible from topology",
+			req: &csi.GetCapacityRequest{
+				AccessibleTopology: &csi.Topology{
+					Segments: map[string]string{
+						topologyKeyPrefix + "vg1": "true",
+						topologyKeyPrefix + "vg3": "true",
+					},
+				},
+			},
+			allowedVGs: []string{"vg1", "vg2", "vg3"},
+			mockLVM: &mockLVM{
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					assert.NotEqual(t, "vg2", name)
+					return &lvm.VolumeGroup{Name: name, FreeSize: 100, Attr: "wz--n-", ExtentSize: 4}, nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedResp: &csi.GetCapacityResponse{
+				AvailableCapacity: 200,
+				MaximumVolumeSize: wrapperspb.Int64(100),
+				MinimumVolumeSize: wrapperspb.Int64(4),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := NewDriver("test-endpoint", tt.allowedVGs, tt.mockLVM)
+			resp, err := driver.GetCapacity(context.Background(), tt.req)
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedResp, resp)
+			} else {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+		})
+	}
+}
+
+func TestValidateVolumeCapabilities(t *testing.T) {
+	tests := []struct {
+		name        string
+		req         *csi.ValidateVolumeCapabilitiesRequest
+		mockLVM     *mockLVM
+		expectedErr codes.Code
+	}{
+		{
+			name: "should confirm capabilities for existing volume",
+			req: &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId: "test-vg/test-lv",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Attr: "-wi-a-----",
+					}, nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should return not found for non-existing volume",
+			req: &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId: "test-vg/test-lv",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{},
+					},
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, nil
+				},
+			},
+			expectedErr: codes.NotFound,
+		},
+		{
+			name: "should fail if invalid volume id",
+			req: &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId: "invalid-id",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{},
+				},
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail if no capabilities provided",
+			req: &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId: "test-vg/test-lv",
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should confirm capabilities for block volume with RWX",
+			req: &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId: "test-vg/test-lv",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Block{
+							Block: &csi.VolumeCapability_BlockVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg", Attr: "-wi-a-----"}, nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail capabilities for mount volume with RWX",
+			req: &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId: "test-vg/test-lv",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+						},
+					},
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg", Attr: "-wi-a-----"}, nil
+				},
+			},
+			expectedErr: codes.OK, // Returns nil Confirmed, not error code
+		},
+		{
+			name: "should fail on internal error",
+			req: &csi.ValidateVolumeCapabilitiesRequest{
+				VolumeId: "test-vg/test-lv",
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{},
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, fmt.Errorf("some error")
+				},
+			},
+			expectedErr: codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := NewDriver("test-endpoint", nil, tt.mockLVM)
+			resp, err := driver.ValidateVolumeCapabilities(context.Background(), tt.req)
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+				if tt.name == "should fail capabilities for mount volume with RWX" {
+					assert.Nil(t, resp.Confirmed)
+					assert.NotEmpty(t, resp.Message)
+				}
+			} else {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+		})
+	}
+}
+
+func TestValidateVolumeCapabilitiesRaidState(t *testing.T) {
+	mockLVM := &mockLVM{
+		getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+			return &lvm.LogicalVolume{
+				Name:           "test-lv",
+				VG:             "test-vg",
+				Attr:           "rwi-a-r---",
+				SyncPercent:    42.5,
+				RaidSyncAction: "recover",
+			}, nil
+		},
+	}
+	driver := NewDriver("test-endpoint", nil, mockLVM)
+
+	resp, err := driver.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId: "test-vg/test-lv",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Block{
+					Block: &csi.VolumeCapability_BlockVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp.Confirmed)
+	assert.Equal(t, "raid images of lv 'test-lv' are 42.50% in sync, current action: recover", resp.Message)
+}
+
+func TestRaidState(t *testing.T) {
+	tests := []struct {
+		name     string
+		lv       *lvm.LogicalVolume
+		expected string
+	}{
+		{
+			name:     "should report raid volume in sync",
+			lv:       &lvm.LogicalVolume{Name: "test-lv", Attr: "rwi-a-r---", SyncPercent: 100, RaidSyncAction: "idle"},
+			expected: "raid images of lv 'test-lv' are in sync",
+		},
+		{
+			name:     "should report sync progress",
+			lv:       &lvm.LogicalVolume{Name: "test-lv", Attr: "rwi-a-r---", SyncPercent: 42.5, RaidSyncAction: "recover"},
+			expected: "raid images of lv 'test-lv' are 42.50% in sync, current action: recover",
+		},
+		{
+			name:     "should report unknown sync state of inactive raid volume",
+			lv:       &lvm.LogicalVolume{Name: "test-lv", Attr: "rwi---r---"},
+			expected: "sync state of raid images of lv 'test-lv' is unknown, it is not active on this node",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, raidState(tt.lv))
+		})
+	}
+}
+
+func TestCreateSnapshot(t *testing.T) {
+	sourceLV := &lvm.LogicalVolume{
+		Name: "test-lv",
+		VG:   "test-vg",
+		Size: 1024 * 1024 * 1024,
+		Tags: []string{lvm.OwnershipTag},
+		Attr: "-wi-------",
+	}
+
+	tests := []struct {
+		name         string
+		req          *csi.CreateSnapshotRequest
+		allowedVGs   []string
+		mockLVM      *mockLVM
+		expectedErr  codes.Code
+		expectedSize int64
+	}{
+		{
+			name: "should create snapshot successfully",
+			req: &csi.CreateSnapshotRequest{
+				Name:           "test-snap",
+				SourceVolumeId: "test-vg/test-lv",
+				Parameters: map[string]string{
+					snapshotSizePercentKey: "25",
+				},
+			},
+			mockLVM: func() *mockLVM {
+				var snapshot *lvm.LogicalVolume
+
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						if name == "test-lv" {
+							return sourceLV, nil
+						}
+						return snapshot, nil
+					},
+					createSnapshot: func(vg, name, origin string, size int64, tags []string) error {
+						assert.Equal(t, "test-lv", origin)
+						assert.Equal(t, int64(256*1024*1024), size)
+						assert.Equal(t, []string{lvm.OwnershipTag, lvm.SnapshotTag}, tags)
+						snapshot = &lvm.LogicalVolume{
+							Name:       name,
+							VG:         vg,
+							Size:       size,
+							Tags:       tags,
+							Origin:     origin,
+							OriginSize: sourceLV.Size,
+						}
+						return nil
+					},
+				}
+			}(),
+			expectedErr:  codes.OK,
+			expectedSize: 1024 * 1024 * 1024,
+		},
+		{
+			name: "should copy encryption and integrity tags of source",
+			req: &csi.CreateSnapshotRequest{
+				Name:           "test-snap",
+				SourceVolumeId: "test-vg/test-lv",
+			},
+			mockLVM: func() *mockLVM {
+				var snapshot *lvm.LogicalVolume
+
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						if name == "test-lv" {
+							return &lvm.LogicalVolume{
+								Name: "test-lv",
+								VG:   "test-vg",
+								Size: 1024 * 1024 * 1024,
+								Tags: []string{lvm.OwnershipTag, encryptedTag, integrityTag, encryptionKeyIDTagPrefix + "v1"},
+								Attr: "-wi-------",
+							}, nil
+						}
+						return snapshot, nil
+					},
+					createSnapshot: func(vg, name, origin string, size int64, tags []string) error {
+						assert.Equal(t, []string{lvm.OwnershipTag, lvm.SnapshotTag, encryptedTag, integrityTag}, tags)
+						snapshot = &lvm.LogicalVolume{Name: name, VG: vg, Size: size, Tags: tags, Origin: origin, OriginSize: 1024 * 1024 * 1024}
+						return nil
+					},
+				}
+			}(),
+			expectedErr:  codes.OK,
+			expectedSize: 1024 * 1024 * 1024,
+		},
+		{
+			name: "should fail if source is published",
+			req: &csi.CreateSnapshotRequest{
+				Name:           "test-snap",
+				SourceVolumeId: "test-vg/test-lv",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					if name == "test-lv" {
+						return &lvm.LogicalVolume{
+							Name: "test-lv",
+							VG:   "test-vg",
+							Size: 1024 * 1024 * 1024,
+							Tags: []string{lvm.OwnershipTag, publishedNodeTag("node-1")},
+							Attr: "-wi-------",
+						}, nil
+					}
+					return nil, nil
+				},
+			},
+			expectedErr: codes.FailedPrecondition,
+		},
+		{
+			name: "should create thin snapshot of thin volume",
+			req: &csi.CreateSnapshotRequest{
+				Name:           "test-snap",
+				SourceVolumeId: "test-vg/test-lv",
+			},
+			mockLVM: func() *mockLVM {
+				var snapshot *lvm.LogicalVolume
+
+				return &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						if name == "test-lv" {
+							return &lvm.LogicalVolume{
+								Name: "test-lv",
+								VG:   "test-vg",
+								Size: 1024 * 1024 * 1024,
+								Tags: []string{lvm.OwnershipTag},
+								Attr: "Vwi-a-tz--",
+								Pool: "test-pool",
+							}, nil
+						}
+						return snapshot, nil
+					},
+					createSnapshot: func(vg, name, origin string, size int64, tags []string) error {
+						assert.Equal(t, int64(0), size)
+						snapshot = &lvm.LogicalVolume{
+							Name:   name,
+							VG:     vg,
+							Size:   1024 * 1024 * 1024,
+							Tags:   tags,
+							Attr:   "Vwi---tz-k",
+							Origin: origin,
+							Pool:   "test-pool",
+						}
+						return nil
+					},
+				}
+			}(),
+			expectedErr:  codes.OK,
+			expectedSize: 1024 * 1024 * 1024,
+		},
+		{
+			name: "should return success if snapshot already exists",
+			req: &csi.CreateSnapshotRequest{
+				Name:           "test-snap",
+				SourceVolumeId: "test-vg/test-lv",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					if name == "test-lv" {
+						return sourceLV, nil
+					}
+					return &lvm.LogicalVolume{
+						Name:       "test-snap",
+						VG:         "test-vg",
+						Size:       1024 * 1024 * 1024,
+						Tags:       []string{lvm.OwnershipTag, lvm.SnapshotTag},
+						Origin:     "test-lv",
+						OriginSize: 1024 * 1024 * 1024,
+					}, nil
+				},
+				createSnapshot: func(vg, name, origin string, size int64, tags []string) error {
+					assert.Fail(t, "createSnapshot should not have been called")
+					return nil
+				},
+			},
+			expectedErr:  codes.OK,
+			expectedSize: 1024 * 1024 * 1024,
+		},
+		{
+			name: "should fail if snapshot already exists with a different source",
+			req: &csi.CreateSnapshotRequest{
+				Name:           "test-snap",
+				SourceVolumeId: "test-vg/test-lv",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					if name == "test-lv" {
+						return sourceLV, nil
+					}
+					return &lvm.LogicalVolume{
+						Name:   "test-snap",
+						VG:     "test-vg",
+						Tags:   []string{lvm.OwnershipTag, lvm.SnapshotTag},
+						Origin: "other-lv",
+					}, nil
+				},
+				createSnapshot: func(vg, name, origin string, size int64, tags []string) error {
+					assert.Fail(t, "createSnapshot should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.AlreadyExists,
+		},
+		{
+			name: "should fail if source volume not found",
+			req: &csi.CreateSnapshotRequest{
+				Name:           "test-snap",
+				SourceVolumeId: "test-vg/test-lv",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, nil
+				},
+			},
+			expectedErr: codes.NotFound,
+		},
+		{
+			name: "should fail if source volume is a snapshot",
+			req: &csi.CreateSnapshotRequest{
+				Name:           "test-snap",
+				SourceVolumeId: "test-vg/other-snap",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name:   "other-snap",
+						VG:     "test-vg",
+						Tags:   []string{lvm.OwnershipTag, lvm.SnapshotTag},
+						Origin: "test-lv",
+					}, nil
 				},
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail on invalid size percent",
+			req: &csi.CreateSnapshotRequest{
+				Name:           "test-snap",
+				SourceVolumeId: "test-vg/test-lv",
 				Parameters: map[string]string{
-					volumeGroupKey: "test-vg",
+					snapshotSizePercentKey: "150",
 				},
 			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail if volume group is not allowed",
+			req: &csi.CreateSnapshotRequest{
+				Name:           "test-snap",
+				SourceVolumeId: "not-allowed-vg/test-lv",
+			},
+			allowedVGs:  []string{"test-vg"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail if name is missing",
+			req: &csi.CreateSnapshotRequest{
+				SourceVolumeId: "test-vg/test-lv",
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail on internal error on create snapshot",
+			req: &csi.CreateSnapshotRequest{
+				Name:           "test-snap",
+				SourceVolumeId: "test-vg/test-lv",
+			},
 			mockLVM: &mockLVM{
 				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
-					return nil, fmt.Errorf("some error")
+					if name == "test-lv" {
+						return sourceLV, nil
+					}
+					return nil, nil
 				},
-				createLV: func(vg, name string, size int64, tags []string) error {
-					assert.Fail(t, "createLV should not have been called")
-					return nil
+				createSnapshot: func(vg, name, origin string, size int64, tags []string) error {
+					return fmt.Errorf("some error")
 				},
 			},
 			expectedErr: codes.Internal,
@@ -242,9 +3896,13 @@ func TestCreateVolume(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			driver := NewDriver("test-endpoint", tt.allowedVGs, tt.mockLVM)
-			_, err := driver.CreateVolume(context.Background(), tt.req)
+			resp, err := driver.CreateSnapshot(context.Background(), tt.req)
 			if tt.expectedErr == codes.OK {
 				assert.NoError(t, err)
+				assert.Equal(t, "test-vg/test-snap", resp.Snapshot.SnapshotId)
+				assert.Equal(t, "test-vg/test-lv", resp.Snapshot.SourceVolumeId)
+				assert.Equal(t, tt.expectedSize, resp.Snapshot.SizeBytes)
+				assert.True(t, resp.Snapshot.ReadyToUse)
 			} else {
 				assert.Error(t, err)
 				st, ok := status.FromError(err)
@@ -255,52 +3913,92 @@ func TestCreateVolume(t *testing.T) {
 	}
 }
 
-func TestDeleteVolume(t *testing.T) {
+func TestDeleteSnapshot(t *testing.T) {
 	tests := []struct {
 		name        string
-		req         *csi.DeleteVolumeRequest
+		req         *csi.DeleteSnapshotRequest
 		mockLVM     *mockLVM
 		expectedErr codes.Code
 	}{
 		{
-			name: "should delete volume successfully",
-			req: &csi.DeleteVolumeRequest{
-				VolumeId: "test-vg/test-lv",
+			name: "should delete snapshot successfully",
+			req: &csi.DeleteSnapshotRequest{
+				SnapshotId: "test-vg/test-snap",
 			},
 			mockLVM: &mockLVM{
-				deleteLV: func(vg, name string) error {
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name:   "test-snap",
+						VG:     "test-vg",
+						Tags:   []string{lvm.OwnershipTag, lvm.SnapshotTag},
+						Origin: "test-lv",
+					}, nil
+				},
+				deleteSnapshot: func(vg, name string) error {
 					return nil
 				},
 			},
 			expectedErr: codes.OK,
 		},
 		{
-			name: "should return success if volume not found",
-			req: &csi.DeleteVolumeRequest{
-				VolumeId: "test-vg/test-lv",
+			name: "should return success if snapshot not found",
+			req: &csi.DeleteSnapshotRequest{
+				SnapshotId: "test-vg/test-snap",
 			},
 			mockLVM: &mockLVM{
-				deleteLV: func(vg, name string) error {
-					return fmt.Errorf("not found")
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, nil
+				},
+				deleteSnapshot: func(vg, name string) error {
+					assert.Fail(t, "deleteSnapshot should not have been called")
+					return nil
 				},
 			},
 			expectedErr: codes.OK,
 		},
 		{
-			name: "should fail on invalid volume id",
-			req: &csi.DeleteVolumeRequest{
-				VolumeId: "invalid-id",
+			name: "should fail if lv is not a snapshot",
+			req: &csi.DeleteSnapshotRequest{
+				SnapshotId: "test-vg/test-lv",
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Tags: []string{lvm.OwnershipTag},
+					}, nil
+				},
+				deleteSnapshot: func(vg, name string) error {
+					assert.Fail(t, "deleteSnapshot should not have been called")
+					return nil
+				},
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail on invalid snapshot id",
+			req: &csi.DeleteSnapshotRequest{
+				SnapshotId: "invalid-id",
 			},
 			expectedErr: codes.InvalidArgument,
 		},
 		{
 			name: "should fail on internal error",
-			req: &csi.DeleteVolumeRequest{
-				VolumeId: "test-vg/test-lv",
+			req: &csi.DeleteSnapshotRequest{
+				SnapshotId: "test-vg/test-snap",
 			},
 			mockLVM: &mockLVM{
-				deleteLV: func(vg, name string) error {
-					return fmt.Errorf("some other error")
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name:   "test-snap",
+						VG:     "test-vg",
+						Tags:   []string{lvm.OwnershipTag, lvm.SnapshotTag},
+						Origin: "test-lv",
+					}, nil
+				},
+				deleteSnapshot: func(vg, name string) error {
+					return fmt.Errorf("some error")
 				},
 			},
 			expectedErr: codes.Internal,
@@ -310,7 +4008,7 @@ func TestDeleteVolume(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			driver := NewDriver("test-endpoint", nil, tt.mockLVM)
-			_, err := driver.DeleteVolume(context.Background(), tt.req)
+			_, err := driver.DeleteSnapshot(context.Background(), tt.req)
 			if tt.expectedErr == codes.OK {
 				assert.NoError(t, err)
 			} else {
@@ -323,121 +4021,289 @@ func TestDeleteVolume(t *testing.T) {
 	}
 }
 
-func TestControllerExpandVolume(t *testing.T) {
+func TestListSnapshots(t *testing.T) {
+	snapshots := map[string][]*lvm.LogicalVolume{
+		"vg1": {
+			{Name: "snap-b", VG: "vg1", Tags: []string{lvm.OwnershipTag, lvm.SnapshotTag}, Origin: "lv1"},
+			{Name: "snap-a", VG: "vg1", Tags: []string{lvm.OwnershipTag, lvm.SnapshotTag}, Origin: "lv1"},
+		},
+		"vg2": {
+			{Name: "snap-c", VG: "vg2", Tags: []string{lvm.OwnershipTag, lvm.SnapshotTag}, Origin: "lv2"},
+		},
+	}
+	mock := &mockLVM{
+		listSnapshots: func(vg string) ([]*lvm.LogicalVolume, error) {
+			return snapshots[vg], nil
+		},
+		getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+			for _, snapshot := range snapshots[vg] {
+				if snapshot.Name == name {
+					return snapshot, nil
+				}
+			}
+			return nil, nil
+		},
+	}
+
 	tests := []struct {
-		name        string
-		req         *csi.ControllerExpandVolumeRequest
-		mockLVM     *mockLVM
-		expectedErr codes.Code
+		name              string
+		req               *csi.ListSnapshotsRequest
+		mockLVM           *mockLVM
+		expectedErr       codes.Code
+		expectedIDs       []string
+		expectedNextToken string
 	}{
 		{
-			name: "should expand volume successfully",
-			req: &csi.ControllerExpandVolumeRequest{
-				VolumeId: "test-vg/test-lv",
-				CapacityRange: &csi.CapacityRange{
-					RequiredBytes: 2 * 1024 * 1024 * 1024,
+			name:        "should list all snapshots sorted by id",
+			req:         &csi.ListSnapshotsRequest{},
+			mockLVM:     mock,
+			expectedErr: codes.OK,
+			expectedIDs: []string{"vg1/snap-a", "vg1/snap-b", "vg2/snap-c"},
+		},
+		{
+			name: "should paginate snapshots",
+			req: &csi.ListSnapshotsRequest{
+				MaxEntries: 2,
+			},
+			mockLVM:           mock,
+			expectedErr:       codes.OK,
+			expectedIDs:       []string{"vg1/snap-a", "vg1/snap-b"},
+			expectedNextToken: "vg2/snap-c",
+		},
+		{
+			name: "should continue from starting token",
+			req: &csi.ListSnapshotsRequest{
+				MaxEntries:    2,
+				StartingToken: "vg2/snap-c",
+			},
+			mockLVM:     mock,
+			expectedErr: codes.OK,
+			expectedIDs: []string{"vg2/snap-c"},
+		},
+		{
+			name: "should fail on unknown starting token",
+			req: &csi.ListSnapshotsRequest{
+				StartingToken: "vg2/snap-gone",
+			},
+			mockLVM:     mock,
+			expectedErr: codes.Aborted,
+		},
+		{
+			name: "should filter by source volume",
+			req: &csi.ListSnapshotsRequest{
+				SourceVolumeId: "vg2/lv2",
+			},
+			mockLVM:     mock,
+			expectedErr: codes.OK,
+			expectedIDs: []string{"vg2/snap-c"},
+		},
+		{
+			name: "should filter by snapshot id",
+			req: &csi.ListSnapshotsRequest{
+				SnapshotId: "vg1/snap-b",
+			},
+			mockLVM:     mock,
+			expectedErr: codes.OK,
+			expectedIDs: []string{"vg1/snap-b"},
+		},
+		{
+			name: "should return empty if snapshot id not found",
+			req: &csi.ListSnapshotsRequest{
+				SnapshotId: "vg1/snap-z",
+			},
+			mockLVM:     mock,
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail on internal error",
+			req:  &csi.ListSnapshotsRequest{},
+			mockLVM: &mockLVM{
+				listSnapshots: func(vg string) ([]*lvm.LogicalVolume, error) {
+					return nil, fmt.Errorf("some error")
 				},
 			},
+			expectedErr: codes.Internal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := NewDriver("test-endpoint", []string{"vg1", "vg2"}, tt.mockLVM)
+			resp, err := driver.ListSnapshots(context.Background(), tt.req)
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+				var ids []string
+				for _, entry := range resp.Entries {
+					ids = append(ids, entry.Snapshot.SnapshotId)
+				}
+				assert.Equal(t, tt.expectedIDs, ids)
+				assert.Equal(t, tt.expectedNextToken, resp.NextToken)
+			} else {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+		})
+	}
+}
+
+func TestControllerGetVolume(t *testing.T) {
+	healthyLV := &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg", Size: 1024, Attr: "-wi-a-----", Tags: []string{lvm.OwnershipTag}}
+	healthyVG := &lvm.VolumeGroup{Name: "test-vg", Attr: "wz--n-"}
+
+	tests := []struct {
+		name             string
+		req              *csi.ControllerGetVolumeRequest
+		allowedVGs       []string
+		mockLVM          *mockLVM
+		expectedErr      codes.Code
+		expectedCapacity int64
+		expectedAbnormal bool
+	}{
+		{
+			name: "should report healthy volume",
+			req: &csi.ControllerGetVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+			},
 			mockLVM: &mockLVM{
 				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
-					return &lvm.LogicalVolume{
-						Name: "test-lv",
-						VG:   "test-vg",
-						Size: 1024 * 1024 * 1024,
-					}, nil
+					return healthyLV, nil
 				},
-				resizeLV: func(vg, name string, size int64) error {
-					return nil
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return healthyVG, nil
 				},
 			},
-			expectedErr: codes.OK,
+			expectedErr:      codes.OK,
+			expectedCapacity: 1024,
 		},
 		{
-			name: "should return success if volume is already large enough",
-			req: &csi.ControllerExpandVolumeRequest{
+			name: "should report resynchronizing raid volume as normal",
+			req: &csi.ControllerGetVolumeRequest{
 				VolumeId: "test-vg/test-lv",
-				CapacityRange: &csi.CapacityRange{
-					RequiredBytes: 1024 * 1024 * 1024,
-				},
 			},
 			mockLVM: &mockLVM{
 				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
-					return &lvm.LogicalVolume{
-						Name: "test-lv",
-						VG:   "test-vg",
-						Size: 2 * 1024 * 1024 * 1024,
-					}, nil
+					return &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg", Size: 1024, Attr: "rwi-a-r---", Tags: []string{lvm.OwnershipTag}, SyncPercent: 10, RaidSyncAction: "resync"}, nil
 				},
-				resizeLV: func(vg, name string, size int64) error {
-					assert.Fail(t, "resizeLV should not have been called")
-					return nil
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return healthyVG, nil
 				},
 			},
-			expectedErr: codes.OK,
+			expectedErr:      codes.OK,
+			expectedCapacity: 1024,
 		},
 		{
-			name: "should fail if volume not found",
-			req: &csi.ControllerExpandVolumeRequest{
+			name: "should report abnormal vdo volume if its pool is full",
+			req: &csi.ControllerGetVolumeRequest{
 				VolumeId: "test-vg/test-lv",
-				CapacityRange: &csi.CapacityRange{
-					RequiredBytes: 2 * 1024 * 1024 * 1024,
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg", Size: 1024, Attr: "vwi-a-v---", Pool: "test-lv_vdopool", Tags: []string{lvm.OwnershipTag}}, nil
+				},
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return healthyVG, nil
 				},
+				getVDOPool: func(vg, name string) (*lvm.VDOPool, error) {
+					assert.Equal(t, "test-lv_vdopool", name)
+					return &lvm.VDOPool{Name: name, VG: vg, Size: 512, UsedSize: 512, SavingPercent: 50, UsageKnown: true}, nil
+				},
+			},
+			expectedErr:      codes.OK,
+			expectedCapacity: 1024,
+			expectedAbnormal: true,
+		},
+		{
+			name: "should report abnormal volume if lv is missing",
+			req: &csi.ControllerGetVolumeRequest{
+				VolumeId: "test-vg/test-lv",
 			},
 			mockLVM: &mockLVM{
 				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
 					return nil, nil
 				},
-				resizeLV: func(vg, name string, size int64) error {
-					assert.Fail(t, "resizeLV should not have been called")
-					return nil
-				},
 			},
-			expectedErr: codes.NotFound,
+			expectedErr:      codes.OK,
+			expectedAbnormal: true,
 		},
 		{
-			name: "should fail on invalid volume id",
-			req: &csi.ControllerExpandVolumeRequest{
-				VolumeId: "invalid-id",
+			name: "should report abnormal volume if vg is partial",
+			req: &csi.ControllerGetVolumeRequest{
+				VolumeId: "test-vg/test-lv",
 			},
-			expectedErr: codes.InvalidArgument,
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return healthyLV, nil
+				},
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return &lvm.VolumeGroup{Name: name, Attr: "wz-pn-"}, nil
+				},
+			},
+			expectedErr:      codes.OK,
+			expectedCapacity: 1024,
+			expectedAbnormal: true,
 		},
 		{
-			name: "should fail on internal error on get lv",
-			req: &csi.ControllerExpandVolumeRequest{
+			name: "should report abnormal volume if lv health bit is set",
+			req: &csi.ControllerGetVolumeRequest{
 				VolumeId: "test-vg/test-lv",
-				CapacityRange: &csi.CapacityRange{
-					RequiredBytes: 2 * 1024 * 1024 * 1024,
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Size: 1024, Attr: "rwi-a-r-r-"}, nil
+				},
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return healthyVG, nil
 				},
 			},
+			expectedErr:      codes.OK,
+			expectedCapacity: 1024,
+			expectedAbnormal: true,
+		},
+		{
+			name: "should report abnormal volume if raid integrity detected mismatches",
+			req: &csi.ControllerGetVolumeRequest{
+				VolumeId: "test-vg/test-lv",
+			},
 			mockLVM: &mockLVM{
 				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
-					return nil, fmt.Errorf("some error")
+					return &lvm.LogicalVolume{Name: name, VG: vg, Size: 1024, Attr: "rwi-a-r---", IntegrityMismatches: 2}, nil
 				},
-				resizeLV: func(vg, name string, size int64) error {
-					assert.Fail(t, "resizeLV should not have been called")
-					return nil
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return healthyVG, nil
 				},
 			},
-			expectedErr: codes.Internal,
+			expectedErr:      codes.OK,
+			expectedCapacity: 1024,
+			expectedAbnormal: true,
+		},
+		{
+			name: "should fail if volume group is not allowed",
+			req: &csi.ControllerGetVolumeRequest{
+				VolumeId: "not-allowed-vg/test-lv",
+			},
+			allowedVGs:  []string{"test-vg"},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail on invalid volume id",
+			req: &csi.ControllerGetVolumeRequest{
+				VolumeId: "invalid-id",
+			},
+			expectedErr: codes.InvalidArgument,
 		},
 		{
-			name: "should fail on internal error on resize lv",
-			req: &csi.ControllerExpandVolumeRequest{
+			name: "should fail on internal error on get vg",
+			req: &csi.ControllerGetVolumeRequest{
 				VolumeId: "test-vg/test-lv",
-				CapacityRange: &csi.CapacityRange{
-					RequiredBytes: 2 * 1024 * 1024 * 1024,
-				},
 			},
 			mockLVM: &mockLVM{
 				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
-					return &lvm.LogicalVolume{
-						Name: "test-lv",
-						VG:   "test-vg",
-						Size: 1024 * 1024 * 1024,
-					}, nil
+					return healthyLV, nil
 				},
-				resizeLV: func(vg, name string, size int64) error {
-					return fmt.Errorf("some other error")
+				getVG: func(name string) (*lvm.VolumeGroup, error) {
+					return nil, fmt.Errorf("some error")
 				},
 			},
 			expectedErr: codes.Internal,
@@ -446,10 +4312,13 @@ func TestControllerExpandVolume(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			driver := NewDriver("test-endpoint", nil, tt.mockLVM)
-			_, err := driver.ControllerExpandVolume(context.Background(), tt.req)
+			driver := NewDriver("test-endpoint", tt.allowedVGs, tt.mockLVM)
+			resp, err := driver.ControllerGetVolume(context.Background(), tt.req)
 			if tt.expectedErr == codes.OK {
 				assert.NoError(t, err)
+				assert.Equal(t, tt.req.VolumeId, resp.Volume.VolumeId)
+				assert.Equal(t, tt.expectedCapacity, resp.Volume.CapacityBytes)
+				assert.Equal(t, tt.expectedAbnormal, resp.Status.VolumeCondition.Abnormal)
 			} else {
 				assert.Error(t, err)
 				st, ok := status.FromError(err)
@@ -460,266 +4329,283 @@ func TestControllerExpandVolume(t *testing.T) {
 	}
 }
 
-func TestGetCapacity(t *testing.T) {
+func TestControllerModifyVolume(t *testing.T) {
+	linearLV := &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg", Attr: "-wi-a-----", Tags: []string{lvm.OwnershipTag, "old-tag"}}
+	encryptedLV := &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg", Attr: "-wi-a-----", Tags: []string{lvm.OwnershipTag, encryptedTag, encryptionKeyIDTagPrefix + "v1"}}
+
 	tests := []struct {
-		name         string
-		req          *csi.GetCapacityRequest
-		allowedVGs   []string
-		mockLVM      *mockLVM
-		expectedErr  codes.Code
-		expectedResp *csi.GetCapacityResponse
+		name        string
+		req         *csi.ControllerModifyVolumeRequest
+		mockLVM     *mockLVM
+		expectedErr codes.Code
 	}{
 		{
-			name: "should return capacity for specific VG",
-			req: &csi.GetCapacityRequest{
-				Parameters: map[string]string{
-					volumeGroupKey: "test-vg",
-				},
+			name: "should replace user tags",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{tagsKey: "new-tag, team=storage"},
 			},
-			allowedVGs: []string{"test-vg"},
 			mockLVM: &mockLVM{
-				getVG: func(name string) (*lvm.VolumeGroup, error) {
-					if name == "test-vg" {
-						return &lvm.VolumeGroup{
-							Name:     "test-vg",
-							FreeSize: 100,
-						}, nil
-					}
-					return nil, nil
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return linearLV, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Equal(t, []string{"new-tag", "team=storage"}, add)
+					assert.Equal(t, []string{"old-tag"}, remove)
+					return nil
 				},
 			},
 			expectedErr: codes.OK,
-			expectedResp: &csi.GetCapacityResponse{
-				AvailableCapacity: 100,
-			},
 		},
 		{
-			name:       "should return capacity for allowed VGs (implicit)",
-			req:        &csi.GetCapacityRequest{},
-			allowedVGs: []string{"vg1", "vg2"},
+			name: "should not update tags that are already set",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{tagsKey: "old-tag"},
+			},
 			mockLVM: &mockLVM{
-				getVG: func(name string) (*lvm.VolumeGroup, error) {
-					if name == "vg1" {
-						return &lvm.VolumeGroup{Name: "vg1", FreeSize: 100}, nil
-					}
-					if name == "vg2" {
-						return &lvm.VolumeGroup{Name: "vg2", FreeSize: 200}, nil
-					}
-					return nil, nil
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return linearLV, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Fail(t, "updateLVTags should not have been called")
+					return nil
 				},
 			},
 			expectedErr: codes.OK,
-			expectedResp: &csi.GetCapacityResponse{
-				AvailableCapacity: 300,
-			},
 		},
 		{
-			name:        "should return 0 if no allowed VGs configured and no param",
-			req:         &csi.GetCapacityRequest{},
-			allowedVGs:  nil,
-			expectedErr: codes.OK,
-			expectedResp: &csi.GetCapacityResponse{
-				AvailableCapacity: 0,
+			name: "should change read ahead",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{readAheadKey: "512"},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return linearLV, nil
+				},
+				changeLV: func(vg, name string, changes lvm.LVChanges) error {
+					assert.Equal(t, lvm.LVChanges{ReadAhead: "512"}, changes)
+					return nil
+				},
 			},
+			expectedErr: codes.OK,
 		},
 		{
-			name: "should fail if requested VG is not allowed",
-			req: &csi.GetCapacityRequest{
-				Parameters: map[string]string{
-					volumeGroupKey: "forbidden-vg",
+			name: "should change cache mode of cached volume",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{cacheModeKey: "writeback"},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "Cwi-a-C---"}, nil
+				},
+				changeLV: func(vg, name string, changes lvm.LVChanges) error {
+					assert.Equal(t, lvm.LVChanges{CacheMode: "writeback"}, changes)
+					return nil
 				},
 			},
-			allowedVGs:  []string{"allowed-vg"},
-			expectedErr: codes.InvalidArgument,
+			expectedErr: codes.OK,
 		},
 		{
-			name: "should fail if get vg error on specific request",
-			req: &csi.GetCapacityRequest{
-				Parameters: map[string]string{
-					volumeGroupKey: "test-vg",
-				},
+			name: "should fail to change cache mode of uncached volume",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{cacheModeKey: "writeback"},
 			},
-			allowedVGs: []string{"test-vg"},
 			mockLVM: &mockLVM{
-				getVG: func(name string) (*lvm.VolumeGroup, error) {
-					return nil, fmt.Errorf("lvm error")
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return linearLV, nil
+				},
+				changeLV: func(vg, name string, changes lvm.LVChanges) error {
+					assert.Fail(t, "changeLV should not have been called")
+					return nil
 				},
 			},
-			expectedErr: codes.Internal,
+			expectedErr: codes.InvalidArgument,
 		},
 		{
-			name:       "should skip if get vg error on implicit request",
-			req:        &csi.GetCapacityRequest{},
-			allowedVGs: []string{"vg1", "error-vg"},
+			name: "should convert linear volume to raid1",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{raidTypeKey: "raid1", mirrorsKey: "2"},
+			},
 			mockLVM: &mockLVM{
-				getVG: func(name string) (*lvm.VolumeGroup, error) {
-					if name == "vg1" {
-						return &lvm.VolumeGroup{Name: "vg1", FreeSize: 100}, nil
-					}
-					return nil, fmt.Errorf("lvm error")
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return linearLV, nil
+				},
+				convertToRaid1: func(vg, name string, mirrors int) error {
+					assert.Equal(t, 2, mirrors)
+					return nil
 				},
 			},
 			expectedErr: codes.OK,
-			expectedResp: &csi.GetCapacityResponse{
-				AvailableCapacity: 100,
-			},
 		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			driver := NewDriver("test-endpoint", tt.allowedVGs, tt.mockLVM)
-			resp, err := driver.GetCapacity(context.Background(), tt.req)
-			if tt.expectedErr == codes.OK {
-				assert.NoError(t, err)
-				assert.Equal(t, tt.expectedResp, resp)
-			} else {
-				assert.Error(t, err)
-				st, ok := status.FromError(err)
-				assert.True(t, ok)
-				assert.Equal(t, tt.expectedErr, st.Code())
-			}
-		})
-	}
-}
-
-func TestValidateVolumeCapabilities(t *testing.T) {
-	tests := []struct {
-		name        string
-		req         *csi.ValidateVolumeCapabilitiesRequest
-		mockLVM     *mockLVM
-		expectedErr codes.Code
-	}{
 		{
-			name: "should confirm capabilities for existing volume",
-			req: &csi.ValidateVolumeCapabilitiesRequest{
-				VolumeId: "test-vg/test-lv",
-				VolumeCapabilities: []*csi.VolumeCapability{
-					{
-						AccessType: &csi.VolumeCapability_Mount{
-							Mount: &csi.VolumeCapability_MountVolume{},
-						},
-						AccessMode: &csi.VolumeCapability_AccessMode{
-							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
-						},
-					},
-				},
+			name: "should not convert volume that is already raid1",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{raidTypeKey: "raid1"},
 			},
 			mockLVM: &mockLVM{
 				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
-					return &lvm.LogicalVolume{
-						Name: "test-lv",
-						VG:   "test-vg",
-					}, nil
+					return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "rwi-a-r---"}, nil
+				},
+				convertToRaid1: func(vg, name string, mirrors int) error {
+					assert.Fail(t, "convertToRaid1 should not have been called")
+					return nil
 				},
 			},
 			expectedErr: codes.OK,
 		},
 		{
-			name: "should return not found for non-existing volume",
-			req: &csi.ValidateVolumeCapabilitiesRequest{
-				VolumeId: "test-vg/test-lv",
-				VolumeCapabilities: []*csi.VolumeCapability{
-					{
-						AccessType: &csi.VolumeCapability_Mount{},
-					},
-				},
+			name: "should fail to convert thin volume",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{raidTypeKey: "raid1"},
 			},
 			mockLVM: &mockLVM{
 				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
-					return nil, nil
+					return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "Vwi-a-tz--", Pool: "test-pool"}, nil
 				},
 			},
-			expectedErr: codes.NotFound,
+			expectedErr: codes.InvalidArgument,
 		},
 		{
-			name: "should fail if invalid volume id",
-			req: &csi.ValidateVolumeCapabilitiesRequest{
-				VolumeId: "invalid-id",
-				VolumeCapabilities: []*csi.VolumeCapability{
-					{},
-				},
+			name: "should fail on unsupported parameter",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{"unknown": "value"},
 			},
 			expectedErr: codes.InvalidArgument,
 		},
 		{
-			name: "should fail if no capabilities provided",
-			req: &csi.ValidateVolumeCapabilitiesRequest{
-				VolumeId: "test-vg/test-lv",
+			name: "should fail on invalid tag",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{tagsKey: lvm.SnapshotTag},
 			},
 			expectedErr: codes.InvalidArgument,
 		},
 		{
-			name: "should confirm capabilities for block volume with RWX",
-			req: &csi.ValidateVolumeCapabilitiesRequest{
-				VolumeId: "test-vg/test-lv",
-				VolumeCapabilities: []*csi.VolumeCapability{
-					{
-						AccessType: &csi.VolumeCapability_Block{
-							Block: &csi.VolumeCapability_BlockVolume{},
-						},
-						AccessMode: &csi.VolumeCapability_AccessMode{
-							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
-						},
-					},
+			name: "should fail on mirrors without raid type",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{mirrorsKey: "1"},
+			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail if volume not found",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{readAheadKey: "auto"},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return nil, nil
 				},
 			},
+			expectedErr: codes.NotFound,
+		},
+		{
+			name: "should fail on internal error on change lv",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{readAheadKey: "auto"},
+			},
 			mockLVM: &mockLVM{
 				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
-					return &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg"}, nil
+					return linearLV, nil
+				},
+				changeLV: func(vg, name string, changes lvm.LVChanges) error {
+					return fmt.Errorf("some error")
 				},
 			},
-			expectedErr: codes.OK,
+			expectedErr: codes.Internal,
 		},
 		{
-			name: "should fail capabilities for mount volume with RWX",
-			req: &csi.ValidateVolumeCapabilitiesRequest{
-				VolumeId: "test-vg/test-lv",
-				VolumeCapabilities: []*csi.VolumeCapability{
-					{
-						AccessType: &csi.VolumeCapability_Mount{
-							Mount: &csi.VolumeCapability_MountVolume{},
-						},
-						AccessMode: &csi.VolumeCapability_AccessMode{
-							Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
-						},
-					},
+			name: "should rotate key of encrypted volume",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{encryptionKeyIDKey: "v2"},
+				Secrets:           map[string]string{passphraseKey: "new", previousPassphraseKey: "old"},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return encryptedLV, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Equal(t, []string{encryptionKeyIDTagPrefix + "v2"}, add)
+					assert.Equal(t, []string{encryptionKeyIDTagPrefix + "v1"}, remove)
+					return nil
 				},
 			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should not rotate key that is already current",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{encryptionKeyIDKey: "v1"},
+			},
 			mockLVM: &mockLVM{
 				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
-					return &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg"}, nil
+					return encryptedLV, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Fail(t, "updateLVTags should not have been called")
+					return nil
 				},
 			},
-			expectedErr: codes.OK, // Returns nil Confirmed, not error code
+			expectedErr: codes.OK,
 		},
 		{
-			name: "should fail on internal error",
-			req: &csi.ValidateVolumeCapabilitiesRequest{
-				VolumeId: "test-vg/test-lv",
-				VolumeCapabilities: []*csi.VolumeCapability{
-					{},
+			name: "should fail to rotate key of unencrypted volume",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{encryptionKeyIDKey: "v2"},
+				Secrets:           map[string]string{passphraseKey: "new", previousPassphraseKey: "old"},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return linearLV, nil
 				},
 			},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail to rotate key without passphrase secret",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{encryptionKeyIDKey: "v2"},
+			},
 			mockLVM: &mockLVM{
 				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
-					return nil, fmt.Errorf("some error")
+					return encryptedLV, nil
 				},
 			},
-			expectedErr: codes.Internal,
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should fail on invalid encryption key id",
+			req: &csi.ControllerModifyVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				MutableParameters: map[string]string{encryptionKeyIDKey: "v 2"},
+			},
+			mockLVM:     &mockLVM{},
+			expectedErr: codes.InvalidArgument,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			driver := NewDriver("test-endpoint", nil, tt.mockLVM)
-			resp, err := driver.ValidateVolumeCapabilities(context.Background(), tt.req)
+			driver.encryptor = &mockEncryptor{}
+			_, err := driver.ControllerModifyVolume(context.Background(), tt.req)
 			if tt.expectedErr == codes.OK {
 				assert.NoError(t, err)
-				if tt.name == "should fail capabilities for mount volume with RWX" {
-					assert.Nil(t, resp.Confirmed)
-					assert.NotEmpty(t, resp.Message)
-				}
 			} else {
 				assert.Error(t, err)
 				st, ok := status.FromError(err)
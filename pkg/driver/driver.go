@@ -1,19 +1,25 @@
 package driver
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"golang.org/x/sys/unix"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/mount-utils"
 	utilexec "k8s.io/utils/exec"
 
 	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
 )
 
+const DriverName = "csi-shared-lvm.cienijr.github.com"
+
 type Driver struct {
 	csi.UnimplementedIdentityServer
 	csi.UnimplementedControllerServer
@@ -26,6 +32,81 @@ type Driver struct {
 	mounter             *mount.SafeFormatAndMount
 	resizer             Resizer
 	stats               DeviceStats
+	copier              Copier
+	encryptor           Encryptor
+	integrity           IntegrityLayer
+	recorder            record.EventRecorder
+	publishLock         sync.Mutex
+	placementLock       sync.Mutex
+	// placementCounters keeps the round-robin position of each multi-VG StorageClass, keyed by its volume groups
+	placementCounters map[string]int
+
+	// thin pool limits, see Option
+	overprovisionRatio       float64
+	dataPercentThreshold     float64
+	metadataPercentThreshold float64
+
+	// RAID maintenance, see WithRaidMaintenance
+	raidScrubInterval time.Duration
+	raidSparePVTag    string
+
+	// orphanGracePeriod is how long a volume has to be orphaned before it's deleted, zero if orphans are kept
+	orphanGracePeriod time.Duration
+
+	// trashTTL is how long deleted volumes are kept in the trash, zero if they're removed right away
+	trashTTL time.Duration
+}
+
+// Option customizes optional driver behavior
+type Option func(*Driver)
+
+// WithEventRecorder makes the driver report notable conditions as Kubernetes events
+func WithEventRecorder(recorder record.EventRecorder) Option {
+	return func(d *Driver) {
+		d.recorder = recorder
+	}
+}
+
+// WithOverprovisionRatio sets the default limit for the sum of virtual sizes of the volumes in a thin pool, relative
+// to the pool size. Zero means no limit.
+func WithOverprovisionRatio(ratio float64) Option {
+	return func(d *Driver) {
+		d.overprovisionRatio = ratio
+	}
+}
+
+// WithThinPoolThresholds sets the data and metadata usage percentages above which thin pools are reported.
+// Zero disables the respective check.
+func WithThinPoolThresholds(dataPercent, metadataPercent float64) Option {
+	return func(d *Driver) {
+		d.dataPercentThreshold = dataPercent
+		d.metadataPercentThreshold = metadataPercent
+	}
+}
+
+// WithRaidMaintenance sets how often the RAID monitor checks each RAID volume for mismatches, and the tag of the PVs
+// that images on failed PVs are moved to. Zero and an empty tag disable checks and repairs respectively.
+func WithRaidMaintenance(scrubInterval time.Duration, sparePVTag string) Option {
+	return func(d *Driver) {
+		d.raidScrubInterval = scrubInterval
+		d.raidSparePVTag = sparePVTag
+	}
+}
+
+// WithOrphanDeletion makes the orphan reconciler delete volumes that no PersistentVolume referred to for the given
+// grace period. Zero keeps them.
+func WithOrphanDeletion(gracePeriod time.Duration) Option {
+	return func(d *Driver) {
+		d.orphanGracePeriod = gracePeriod
+	}
+}
+
+// WithTrash makes DeleteVolume move volumes to the trash instead of removing them, where they can be restored until
+// they're purged after the given TTL. Zero removes them right away.
+func WithTrash(ttl time.Duration) Option {
+	return func(d *Driver) {
+		d.trashTTL = ttl
+	}
 }
 
 type Resizer interface {
@@ -39,6 +120,183 @@ type DeviceStats interface {
 	IsBlockDevice(path string) (bool, error)
 }
 
+type Copier interface {
+	Copy(sourcePath, targetPath string) error
+}
+
+type defaultCopier struct {
+	exec utilexec.Interface
+}
+
+func (c *defaultCopier) Copy(sourcePath, targetPath string) error {
+	output, err := c.exec.Command("dd", "if="+sourcePath, "of="+targetPath, "bs=4M", "iflag=direct", "oflag=direct", "conv=fsync", "status=none").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to copy device: %v, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// Encryptor manages the LUKS encryption of volumes. Mappings are addressed by their device-mapper name.
+type Encryptor interface {
+	Format(devicePath string, passphrase []byte) error
+	Open(devicePath, name string, passphrase []byte) error
+	Close(name string) error
+	Resize(name string, passphrase []byte) error
+	IsOpen(name string) (bool, error)
+	AddKey(devicePath string, passphrase, newPassphrase []byte) error
+	RemoveKey(devicePath string, passphrase []byte) error
+	TestPassphrase(devicePath string, passphrase []byte) (bool, error)
+}
+
+// defaultEncryptor runs cryptsetup, passing passphrases on stdin so that they never show up in the process list
+type defaultEncryptor struct {
+	exec utilexec.Interface
+}
+
+func (e *defaultEncryptor) Format(devicePath string, passphrase []byte) error {
+	return e.run(passphrase, "luksFormat", "--type", "luks2", "--batch-mode", "--key-file", "-", devicePath)
+}
+
+func (e *defaultEncryptor) Open(devicePath, name string, passphrase []byte) error {
+	return e.run(passphrase, "luksOpen", "--key-file", "-", devicePath, name)
+}
+
+func (e *defaultEncryptor) Close(name string) error {
+	return e.run(nil, "luksClose", name)
+}
+
+func (e *defaultEncryptor) Resize(name string, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return e.run(nil, "resize", name)
+	}
+	return e.run(passphrase, "resize", "--key-file", "-", name)
+}
+
+func (e *defaultEncryptor) IsOpen(name string) (bool, error) {
+	return mapperExists(name)
+}
+
+func (e *defaultEncryptor) AddKey(devicePath string, passphrase, newPassphrase []byte) error {
+	// stdin already carries the current passphrase, so the new one is passed in a file only readable by us
+	f, err := os.CreateTemp("", "luks-key-")
+	if err != nil {
+		return fmt.Errorf("failed to create key file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(newPassphrase)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write key file: %v", err)
+	}
+	return e.run(passphrase, "luksAddKey", "--key-file", "-", devicePath, f.Name())
+}
+
+func (e *defaultEncryptor) RemoveKey(devicePath string, passphrase []byte) error {
+	return e.run(passphrase, "luksRemoveKey", "--key-file", "-", devicePath)
+}
+
+func (e *defaultEncryptor) TestPassphrase(devicePath string, passphrase []byte) (bool, error) {
+	cmd := e.exec.Command("cryptsetup", "open", "--test-passphrase", "--key-file", "-", devicePath)
+	cmd.SetStdin(bytes.NewReader(passphrase))
+	output, err := cmd.CombinedOutput()
+	// cryptsetup exits with status 2 when no key slot matches the passphrase
+	if exitErr, ok := err.(utilexec.ExitError); ok && exitErr.ExitStatus() == 2 {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to run cryptsetup open: %v, output: %s", err, string(output))
+	}
+	return true, nil
+}
+
+func (e *defaultEncryptor) run(passphrase []byte, args ...string) error {
+	cmd := e.exec.Command("cryptsetup", args...)
+	if passphrase != nil {
+		cmd.SetStdin(bytes.NewReader(passphrase))
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to run cryptsetup %s: %v, output: %s", args[0], err, string(output))
+	}
+	return nil
+}
+
+// IntegrityLayer manages standalone dm-integrity mappings, which checksum every sector of a volume. Mappings are
+// addressed by their device-mapper name.
+type IntegrityLayer interface {
+	Format(devicePath string) error
+	Open(devicePath, name string, recalculate bool) error
+	Close(name string) error
+	Resize(name string) error
+	IsOpen(name string) (bool, error)
+	Mismatches(name string) (int64, error)
+}
+
+// defaultIntegrityLayer runs integritysetup with crc32c checksums, which detect corruption at a low CPU cost
+type defaultIntegrityLayer struct {
+	exec utilexec.Interface
+}
+
+func (i *defaultIntegrityLayer) Format(devicePath string) error {
+	// the checksums are calculated by the kernel on the first open instead, which is much faster than wiping the device
+	return i.run("format", "--batch-mode", "--no-wipe", "--integrity", "crc32c", devicePath)
+}
+
+func (i *defaultIntegrityLayer) Open(devicePath, name string, recalculate bool) error {
+	args := []string{"open", "--integrity", "crc32c"}
+	if recalculate {
+		args = append(args, "--integrity-recalculate")
+	}
+	return i.run(append(args, devicePath, name)...)
+}
+
+func (i *defaultIntegrityLayer) Close(name string) error {
+	return i.run("close", name)
+}
+
+func (i *defaultIntegrityLayer) Resize(name string) error {
+	return i.run("resize", name)
+}
+
+func (i *defaultIntegrityLayer) IsOpen(name string) (bool, error) {
+	return mapperExists(name)
+}
+
+func (i *defaultIntegrityLayer) Mismatches(name string) (int64, error) {
+	output, err := i.exec.Command("dmsetup", "status", name).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get integrity status: %v, output: %s", err, string(output))
+	}
+	// <start> <length> integrity <mismatches> <provided data sectors> <recalculated sector>
+	fields := strings.Fields(string(output))
+	if len(fields) < 4 || fields[2] != "integrity" {
+		return 0, fmt.Errorf("failed to parse integrity status: %s", string(output))
+	}
+	return strconv.ParseInt(fields[3], 10, 64)
+}
+
+func (i *defaultIntegrityLayer) run(args ...string) error {
+	output, err := i.exec.Command("integritysetup", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to run integritysetup %s: %v, output: %s", args[0], err, string(output))
+	}
+	return nil
+}
+
+// mapperExists returns true if a device-mapper device with the given name exists on this node
+func mapperExists(name string) (bool, error) {
+	_, err := os.Stat(mapperDevicePath(name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 type defaultDeviceStats struct {
 	exec utilexec.Interface
 }
@@ -77,10 +335,10 @@ func (d *defaultDeviceStats) IsBlockDevice(path string) (bool, error) {
 	return (info.Mode() & os.ModeDevice) != 0, nil
 }
 
-func NewDriver(endpoint string, allowedVolumeGroups []string, lvm lvm.LVM) *Driver {
+func NewDriver(endpoint string, allowedVolumeGroups []string, lvm lvm.LVM, opts ...Option) *Driver {
 	nodeID, _ := os.Hostname()
 	mountExec := utilexec.New()
-	return &Driver{
+	d := &Driver{
 		endpoint:            endpoint,
 		nodeID:              nodeID,
 		allowedVolumeGroups: allowedVolumeGroups,
@@ -88,5 +346,12 @@ func NewDriver(endpoint string, allowedVolumeGroups []string, lvm lvm.LVM) *Driv
 		mounter:             &mount.SafeFormatAndMount{Interface: mount.New(""), Exec: mountExec},
 		resizer:             mount.NewResizeFs(mountExec),
 		stats:               &defaultDeviceStats{exec: mountExec},
+		copier:              &defaultCopier{exec: mountExec},
+		encryptor:           &defaultEncryptor{exec: mountExec},
+		integrity:           &defaultIntegrityLayer{exec: mountExec},
+	}
+	for _, opt := range opts {
+		opt(d)
 	}
+	return d
 }
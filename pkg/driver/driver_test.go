@@ -5,29 +5,67 @@ import (
 )
 
 type mockLVM struct {
-	getLV        func(vg, name string) (*lvm.LogicalVolume, error)
-	createLV     func(vg, name string, size int64, tags []string) error
-	deleteLV     func(vg, name string) error
-	resizeLV     func(vg, name string, size int64) error
-	activateLV   func(vg, name string) error
-	deactivateLV func(vg, name string) error
-	getVG        func(name string) (*lvm.VolumeGroup, error)
+	getLV           func(vg, name string) (*lvm.LogicalVolume, error)
+	listOwnedLVs    func(vg string) ([]*lvm.LogicalVolume, error)
+	createLV        func(vg, name string, size int64, tags []string, opts lvm.LVOptions) error
+	deleteLV        func(vg, name string) error
+	renameLV        func(vg, name, newName string) error
+	resizeLV        func(vg, name string, size int64, opts lvm.LVOptions) error
+	activateLV      func(vg, name string) error
+	deactivateLV    func(vg, name string) error
+	getVG           func(name string) (*lvm.VolumeGroup, error)
+	listVGs         func() ([]*lvm.VolumeGroup, error)
+	listPVs         func(vg string) ([]*lvm.PhysicalVolume, error)
+	createSnapshot  func(vg, name, origin string, size int64, tags []string) error
+	listSnapshots   func(vg string) ([]*lvm.LogicalVolume, error)
+	deleteSnapshot  func(vg, name string) error
+	updateLVTags    func(vg, name string, add, remove []string) error
+	listThinPools   func(vg string) ([]*lvm.LogicalVolume, error)
+	listThinVolumes func(vg, pool string) ([]*lvm.LogicalVolume, error)
+	changeLV        func(vg, name string, changes lvm.LVChanges) error
+	convertToRaid1  func(vg, name string, mirrors int) error
+	createCacheLV   func(vg, name string, size int64, opts lvm.CacheOptions) error
+	attachCache     func(vg, name, cache string, opts lvm.CacheOptions) error
+	splitCache      func(vg, name string) error
+	uncache         func(vg, name string) error
+	getVDOPool      func(vg, name string) (*lvm.VDOPool, error)
+	listRaidLVs     func(vg string) ([]*lvm.LogicalVolume, error)
+	startSyncAction func(vg, name, action string) error
+	repairRaidLV    func(vg, name string, pvTags []string) error
+}
+
+// withDefaultVG makes the mock report a VG with 1TiB free in 4MiB extents, unless the test mocks GetVG itself
+func (m *mockLVM) withDefaultVG() *mockLVM {
+	if m != nil && m.getVG == nil {
+		m.getVG = func(name string) (*lvm.VolumeGroup, error) {
+			return &lvm.VolumeGroup{Name: name, FreeSize: 1 << 40, Attr: "wz--n-", ExtentSize: 4 << 20}, nil
+		}
+	}
+	return m
 }
 
 func (m *mockLVM) GetLV(vg, name string) (*lvm.LogicalVolume, error) {
 	return m.getLV(vg, name)
 }
 
-func (m *mockLVM) CreateLV(vg, name string, size int64, tags []string) error {
-	return m.createLV(vg, name, size, tags)
+func (m *mockLVM) ListOwnedLVs(vg string) ([]*lvm.LogicalVolume, error) {
+	return m.listOwnedLVs(vg)
+}
+
+func (m *mockLVM) CreateLV(vg, name string, size int64, tags []string, opts lvm.LVOptions) error {
+	return m.createLV(vg, name, size, tags, opts)
 }
 
 func (m *mockLVM) DeleteLV(vg, name string) error {
 	return m.deleteLV(vg, name)
 }
 
-func (m *mockLVM) ResizeLV(vg, name string, size int64) error {
-	return m.resizeLV(vg, name, size)
+func (m *mockLVM) RenameLV(vg, name, newName string) error {
+	return m.renameLV(vg, name, newName)
+}
+
+func (m *mockLVM) ResizeLV(vg, name string, size int64, opts lvm.LVOptions) error {
+	return m.resizeLV(vg, name, size, opts)
 }
 
 func (m *mockLVM) ActivateLV(vg, name string) error {
@@ -35,7 +73,10 @@ func (m *mockLVM) ActivateLV(vg, name string) error {
 }
 
 func (m *mockLVM) DeactivateLV(vg, name string) error {
-	return m.deactivateLV(vg, name)
+	if m.deactivateLV != nil {
+		return m.deactivateLV(vg, name)
+	}
+	return nil
 }
 
 func (m *mockLVM) GetVG(name string) (*lvm.VolumeGroup, error) {
@@ -44,3 +85,84 @@ func (m *mockLVM) GetVG(name string) (*lvm.VolumeGroup, error) {
 	}
 	return nil, nil
 }
+
+func (m *mockLVM) ListVGs() ([]*lvm.VolumeGroup, error) {
+	return m.listVGs()
+}
+
+func (m *mockLVM) ListPVs(vg string) ([]*lvm.PhysicalVolume, error) {
+	return m.listPVs(vg)
+}
+
+func (m *mockLVM) CreateSnapshot(vg, name, origin string, size int64, tags []string) error {
+	return m.createSnapshot(vg, name, origin, size, tags)
+}
+
+func (m *mockLVM) ListSnapshots(vg string) ([]*lvm.LogicalVolume, error) {
+	if m.listSnapshots != nil {
+		return m.listSnapshots(vg)
+	}
+	return nil, nil
+}
+
+func (m *mockLVM) DeleteSnapshot(vg, name string) error {
+	return m.deleteSnapshot(vg, name)
+}
+
+func (m *mockLVM) UpdateLVTags(vg, name string, add, remove []string) error {
+	return m.updateLVTags(vg, name, add, remove)
+}
+
+func (m *mockLVM) ListThinPools(vg string) ([]*lvm.LogicalVolume, error) {
+	if m.listThinPools != nil {
+		return m.listThinPools(vg)
+	}
+	return nil, nil
+}
+
+func (m *mockLVM) ListThinVolumes(vg, pool string) ([]*lvm.LogicalVolume, error) {
+	if m.listThinVolumes != nil {
+		return m.listThinVolumes(vg, pool)
+	}
+	return nil, nil
+}
+
+func (m *mockLVM) ChangeLV(vg, name string, changes lvm.LVChanges) error {
+	return m.changeLV(vg, name, changes)
+}
+
+func (m *mockLVM) ConvertLVToRaid1(vg, name string, mirrors int) error {
+	return m.convertToRaid1(vg, name, mirrors)
+}
+
+func (m *mockLVM) CreateCacheLV(vg, name string, size int64, opts lvm.CacheOptions) error {
+	return m.createCacheLV(vg, name, size, opts)
+}
+
+func (m *mockLVM) AttachCache(vg, name, cache string, opts lvm.CacheOptions) error {
+	return m.attachCache(vg, name, cache, opts)
+}
+
+func (m *mockLVM) SplitCache(vg, name string) error {
+	return m.splitCache(vg, name)
+}
+
+func (m *mockLVM) Uncache(vg, name string) error {
+	return m.uncache(vg, name)
+}
+
+func (m *mockLVM) GetVDOPool(vg, name string) (*lvm.VDOPool, error) {
+	return m.getVDOPool(vg, name)
+}
+
+func (m *mockLVM) ListRaidLVs(vg string) ([]*lvm.LogicalVolume, error) {
+	return m.listRaidLVs(vg)
+}
+
+func (m *mockLVM) StartRaidSyncAction(vg, name, action string) error {
+	return m.startSyncAction(vg, name, action)
+}
+
+func (m *mockLVM) RepairRaidLV(vg, name string, pvTags []string) error {
+	return m.repairRaidLV(vg, name, pvTags)
+}
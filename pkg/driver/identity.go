@@ -10,7 +10,7 @@ import (
 func (d *Driver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
 	klog.InfoS("GetPluginInfo called", "req", req)
 	return &csi.GetPluginInfoResponse{
-		Name:          "csi-shared-lvm.cienijr.github.com",
+		Name:          DriverName,
 		VendorVersion: "0.1.0",
 	}, nil
 }
@@ -26,6 +26,13 @@ func (d *Driver) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCa
 					},
 				},
 			},
+			{
+				Type: &csi.PluginCapability_Service_{
+					Service: &csi.PluginCapability_Service{
+						Type: csi.PluginCapability_Service_VOLUME_ACCESSIBILITY_CONSTRAINTS,
+					},
+				},
+			},
 		},
 	}, nil
 }
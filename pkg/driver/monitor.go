@@ -0,0 +1,137 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
+)
+
+var (
+	thinPoolDataPercent = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "csi_shared_lvm_thin_pool_data_percent",
+		Help: "Percentage of the thin pool data space in use.",
+	}, []string{"vg", "pool"})
+	thinPoolMetadataPercent = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "csi_shared_lvm_thin_pool_metadata_percent",
+		Help: "Percentage of the thin pool metadata space in use.",
+	}, []string{"vg", "pool"})
+	thinPoolThresholdExceeded = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "csi_shared_lvm_thin_pool_threshold_exceeded",
+		Help: "Whether the thin pool usage is above the configured threshold (1) or not (0).",
+	}, []string{"vg", "pool", "type"})
+	thinPoolUsageKnown = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "csi_shared_lvm_thin_pool_usage_known",
+		Help: "Whether the thin pool usage could be read on the controller's node (1) or not (0).",
+	}, []string{"vg", "pool"})
+)
+
+func init() {
+	prometheus.MustRegister(thinPoolDataPercent, thinPoolMetadataPercent, thinPoolThresholdExceeded, thinPoolUsageKnown)
+}
+
+// thinPoolUsage identifies one of the usage figures of a thin pool that are checked against a threshold
+type thinPoolUsage string
+
+const (
+	thinPoolData     thinPoolUsage = "data"
+	thinPoolMetadata thinPoolUsage = "metadata"
+)
+
+// RunThinPoolMonitor periodically checks the usage of the thin pools in the allowed volume groups until ctx is
+// cancelled. A full pool blocks all of its volumes, so crossing a threshold is reported with a warning event.
+func (d *Driver) RunThinPoolMonitor(ctx context.Context, interval time.Duration) {
+	klog.InfoS("Starting thin pool monitor", "interval", interval)
+	// keyed by vg/pool/usage, so that events are only emitted when a threshold is crossed
+	exceeded := map[string]bool{}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		d.checkThinPools(exceeded)
+	}, interval)
+}
+
+func (d *Driver) checkThinPools(exceeded map[string]bool) {
+	for _, vgName := range d.volumeGroupsToList() {
+		pools, err := d.lvm.ListThinPools(vgName)
+		if err != nil {
+			klog.ErrorS(err, "Failed to list thin pools", "vg", vgName)
+			continue
+		}
+		for _, pool := range pools {
+			// usage is only reported by LVM for pools that are active on this node. The last figures are dropped
+			// rather than kept, so that they aren't mistaken for the current usage.
+			if !pool.Attr.IsActive() || !pool.UsageKnown {
+				klog.V(4).InfoS("Skipping thin pool with unknown usage", "vg", pool.VG, "pool", pool.Name)
+				thinPoolUsageKnown.WithLabelValues(pool.VG, pool.Name).Set(0)
+				thinPoolDataPercent.DeleteLabelValues(pool.VG, pool.Name)
+				thinPoolMetadataPercent.DeleteLabelValues(pool.VG, pool.Name)
+				thinPoolThresholdExceeded.DeletePartialMatch(prometheus.Labels{"vg": pool.VG, "pool": pool.Name})
+				continue
+			}
+			thinPoolUsageKnown.WithLabelValues(pool.VG, pool.Name).Set(1)
+			thinPoolDataPercent.WithLabelValues(pool.VG, pool.Name).Set(pool.DataPercent)
+			thinPoolMetadataPercent.WithLabelValues(pool.VG, pool.Name).Set(pool.MetadataPercent)
+			d.checkThinPoolThreshold(exceeded, pool.VG, pool.Name, thinPoolData, pool.DataPercent, d.dataPercentThreshold)
+			d.checkThinPoolThreshold(exceeded, pool.VG, pool.Name, thinPoolMetadata, pool.MetadataPercent, d.metadataPercentThreshold)
+		}
+	}
+}
+
+func (d *Driver) checkThinPoolThreshold(exceeded map[string]bool, vgName, poolName string, usage thinPoolUsage, percent, threshold float64) {
+	if threshold <= 0 {
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s/%s", vgName, poolName, usage)
+	isExceeded := percent >= threshold
+	gauge := thinPoolThresholdExceeded.WithLabelValues(vgName, poolName, string(usage))
+	if isExceeded {
+		gauge.Set(1)
+	} else {
+		gauge.Set(0)
+	}
+
+	if isExceeded == exceeded[key] {
+		return
+	}
+	exceeded[key] = isExceeded
+
+	if isExceeded {
+		klog.InfoS("Thin pool usage is above threshold", "vg", vgName, "pool", poolName, "usage", usage, "percent", percent, "threshold", threshold)
+		d.event(corev1.EventTypeWarning, "ThinPoolThresholdExceeded", "Thin pool %s/%s %s usage is %.2f%%, above the %.2f%% threshold", vgName, poolName, usage, percent, threshold)
+	} else {
+		klog.InfoS("Thin pool usage is back below threshold", "vg", vgName, "pool", poolName, "usage", usage, "percent", percent, "threshold", threshold)
+		d.event(corev1.EventTypeNormal, "ThinPoolThresholdRecovered", "Thin pool %s/%s %s usage is %.2f%%, below the %.2f%% threshold", vgName, poolName, usage, percent, threshold)
+	}
+}
+
+// event records a driver-wide event on the CSIDriver object, if the driver was given an event recorder
+func (d *Driver) event(eventType, reason, messageFmt string, args ...interface{}) {
+	if d.recorder == nil {
+		return
+	}
+	ref := &corev1.ObjectReference{
+		APIVersion: "storage.k8s.io/v1",
+		Kind:       "CSIDriver",
+		Name:       DriverName,
+	}
+	d.recorder.Eventf(ref, eventType, reason, messageFmt, args...)
+}
+
+// volumeEvent records an event on the PersistentVolume of an LV, which is named after it by the external-provisioner
+func (d *Driver) volumeEvent(lv *lvm.LogicalVolume, eventType, reason, messageFmt string, args ...interface{}) {
+	if d.recorder == nil {
+		return
+	}
+	ref := &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "PersistentVolume",
+		Name:       lv.Name,
+	}
+	d.recorder.Eventf(ref, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,89 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
+)
+
+func TestCheckThinPools(t *testing.T) {
+	pool := &lvm.LogicalVolume{
+		Name:       "test-pool",
+		VG:         "test-vg",
+		Attr:       "twi-aotz--",
+		UsageKnown: true,
+	}
+	inactivePool := &lvm.LogicalVolume{
+		Name:        "inactive-pool",
+		VG:          "test-vg",
+		Attr:        "twi---tz--",
+		DataPercent: 100,
+	}
+	mockLVM := &mockLVM{
+		listThinPools: func(vg string) ([]*lvm.LogicalVolume, error) {
+			assert.Equal(t, "test-vg", vg)
+			return []*lvm.LogicalVolume{pool, inactivePool}, nil
+		},
+	}
+	recorder := record.NewFakeRecorder(10)
+	driver := NewDriver("test-endpoint", []string{"test-vg"}, mockLVM,
+		WithEventRecorder(recorder),
+		WithThinPoolThresholds(80, 50),
+	)
+	exceeded := map[string]bool{}
+
+	steps := []struct {
+		name            string
+		dataPercent     float64
+		metadataPercent float64
+		expectedEvents  []string
+	}{
+		{
+			name:            "should not emit events below thresholds",
+			dataPercent:     10,
+			metadataPercent: 5,
+		},
+		{
+			name:            "should emit warning when data threshold is crossed",
+			dataPercent:     85,
+			metadataPercent: 5,
+			expectedEvents: []string{
+				"Warning ThinPoolThresholdExceeded Thin pool test-vg/test-pool data usage is 85.00%, above the 80.00% threshold",
+			},
+		},
+		{
+			name:            "should not emit events again while above threshold",
+			dataPercent:     90,
+			metadataPercent: 5,
+		},
+		{
+			name:            "should emit events when thresholds change state",
+			dataPercent:     20,
+			metadataPercent: 60,
+			expectedEvents: []string{
+				"Normal ThinPoolThresholdRecovered Thin pool test-vg/test-pool data usage is 20.00%, below the 80.00% threshold",
+				"Warning ThinPoolThresholdExceeded Thin pool test-vg/test-pool metadata usage is 60.00%, above the 50.00% threshold",
+			},
+		},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			pool.DataPercent = step.dataPercent
+			pool.MetadataPercent = step.metadataPercent
+			driver.checkThinPools(exceeded)
+
+			var events []string
+			for len(recorder.Events) > 0 {
+				events = append(events, <-recorder.Events)
+			}
+			assert.Equal(t, step.expectedEvents, events)
+			assert.Equal(t, float64(1), testutil.ToFloat64(thinPoolUsageKnown.WithLabelValues("test-vg", "test-pool")))
+			assert.Equal(t, float64(0), testutil.ToFloat64(thinPoolUsageKnown.WithLabelValues("test-vg", "inactive-pool")))
+		})
+	}
+}
@@ -2,16 +2,25 @@ package driver
 
 import (
 	"context"
-	"fmt"
 	"os"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/klog/v2"
 	"k8s.io/mount-utils"
 )
 
+var integrityMismatches = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "csi_shared_lvm_integrity_mismatches",
+	Help: "Number of checksum mismatches detected on a staged volume, updated whenever its stats are collected.",
+}, []string{"volume_id"})
+
+func init() {
+	prometheus.MustRegister(integrityMismatches)
+}
+
 func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
 	klog.InfoS("NodeStageVolume called", "req", req)
 
@@ -30,6 +39,13 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		return nil, err
 	}
 
+	// the volume must have been published to this node by the controller before it can be activated here. Volumes
+	// that were staged before publishing was tracked have no publish context, see below.
+	node, tracked := req.GetPublishContext()[publishedNodeKey]
+	if tracked && node != d.nodeID {
+		return nil, status.Errorf(codes.FailedPrecondition, "volume '%s' is published to node '%s', not to '%s'", req.VolumeId, node, d.nodeID)
+	}
+
 	// check if the volume is already staged
 	lv, err := d.lvm.GetLV(vgName, lvName)
 	if err != nil {
@@ -38,6 +54,17 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	if lv == nil {
 		return nil, status.Errorf(codes.NotFound, "volume '%s' not found", req.VolumeId)
 	}
+	if !lv.HasTag(publishedNodeTag(d.nodeID)) {
+		if tracked || len(publishedNodes(lv)) > 0 {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume '%s' is not published to node '%s'", req.VolumeId, d.nodeID)
+		}
+		// a volume that isn't tracked yet is adopted by the first node that stages it, so that the controller refuses
+		// to publish it to another node from now on
+		klog.InfoS("Adopting volume that was staged before publishing was tracked", "vg", vgName, "lv", lvName, "node", d.nodeID)
+		if err := d.lvm.UpdateLVTags(vgName, lvName, []string{publishedNodeTag(d.nodeID)}, nil); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to update lv tags: %v", err)
+		}
+	}
 
 	if !lv.Attr.IsActive() {
 		klog.InfoS("Activating LV", "vg", vgName, "lv", lvName)
@@ -46,6 +73,20 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 		}
 	}
 
+	devicePath := getDevicePath(vgName, lvName)
+	if lv.HasTag(integrityTag) {
+		devicePath, err = d.openIntegrityVolume(devicePath, vgName, lvName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if lv.HasTag(encryptedTag) {
+		devicePath, err = d.openEncryptedVolume(devicePath, vgName, lvName, req.GetSecrets())
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// skip block volumes
 	if req.VolumeCapability.GetBlock() != nil {
 		klog.InfoS("Volume is a block device, skipping format and mount", "vg", vgName, "lv", lvName)
@@ -53,7 +94,6 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	}
 
 	// format and mount the filesystem
-	devicePath := fmt.Sprintf("/dev/%s/%s", vgName, lvName)
 	fsType := "ext4"
 	if mount := req.GetVolumeCapability().GetMount(); mount.GetFsType() != "" {
 		fsType = mount.GetFsType()
@@ -78,6 +118,158 @@ func (d *Driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRe
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
+// openIntegrityVolume opens the standalone dm-integrity mapping of a volume, formatting the LV first if it's still
+// blank, and returns the path of the checked device
+func (d *Driver) openIntegrityVolume(devicePath, vgName, lvName string) (string, error) {
+	name := integrityMapperName(vgName, lvName)
+	open, err := d.integrity.IsOpen(name)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to check integrity mapping: %v", err)
+	}
+	if open {
+		klog.InfoS("Integrity mapping is already open", "vg", vgName, "lv", lvName, "name", name)
+		return mapperDevicePath(name), nil
+	}
+
+	format, err := d.mounter.GetDiskFormat(devicePath)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to get disk format: %v", err)
+	}
+	// the checksums of a freshly formatted device are calculated by the kernel in the background
+	recalculate := false
+	switch format {
+	case "":
+		klog.InfoS("Formatting integrity volume", "devicePath", devicePath)
+		if err := d.integrity.Format(devicePath); err != nil {
+			return "", status.Errorf(codes.Internal, "failed to format integrity volume: %v", err)
+		}
+		recalculate = true
+	case integrityDiskFormat:
+	default:
+		return "", status.Errorf(codes.FailedPrecondition, "volume '%s/%s' already contains '%s' data", vgName, lvName, format)
+	}
+
+	klog.InfoS("Opening integrity volume", "devicePath", devicePath, "name", name)
+	if err := d.integrity.Open(devicePath, name, recalculate); err != nil {
+		return "", status.Errorf(codes.Internal, "failed to open integrity volume: %v", err)
+	}
+	return mapperDevicePath(name), nil
+}
+
+// openEncryptedVolume opens the LUKS mapping of an encrypted volume, formatting the LV first if it's still blank, and
+// returns the path of the decrypted device
+func (d *Driver) openEncryptedVolume(devicePath, vgName, lvName string, secrets map[string]string) (string, error) {
+	passphrase := secrets[passphraseKey]
+	if passphrase == "" {
+		return "", status.Errorf(codes.InvalidArgument, "encrypted volume '%s/%s' requires a '%s' node stage secret", vgName, lvName, passphraseKey)
+	}
+
+	name := luksMapperName(vgName, lvName)
+	open, err := d.encryptor.IsOpen(name)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to check luks mapping: %v", err)
+	}
+	if open {
+		klog.InfoS("LUKS mapping is already open", "vg", vgName, "lv", lvName, "name", name)
+		return mapperDevicePath(name), nil
+	}
+
+	format, err := d.mounter.GetDiskFormat(devicePath)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to get disk format: %v", err)
+	}
+	key := []byte(passphrase)
+	switch format {
+	case "":
+		klog.InfoS("Formatting LUKS volume", "devicePath", devicePath)
+		if err := d.encryptor.Format(devicePath, key); err != nil {
+			return "", status.Errorf(codes.Internal, "failed to format luks volume: %v", err)
+		}
+	case luksDiskFormat:
+		key, err = d.unlockingPassphrase(devicePath, secrets)
+		if err != nil {
+			return "", err
+		}
+	default:
+		// never encrypt over existing data
+		return "", status.Errorf(codes.FailedPrecondition, "encrypted volume '%s/%s' already contains '%s' data", vgName, lvName, format)
+	}
+
+	klog.InfoS("Opening LUKS volume", "devicePath", devicePath, "name", name)
+	if err := d.encryptor.Open(devicePath, name, key); err != nil {
+		return "", status.Errorf(codes.Internal, "failed to open luks volume: %v", err)
+	}
+	return mapperDevicePath(name), nil
+}
+
+// unlockingPassphrase returns the passphrase from the secrets that opens an encrypted volume. The previous passphrase
+// is only tried while the key of the volume is being rotated, so that the Secret can be updated first.
+func (d *Driver) unlockingPassphrase(devicePath string, secrets map[string]string) ([]byte, error) {
+	for _, key := range []string{passphraseKey, previousPassphraseKey} {
+		if secrets[key] == "" {
+			continue
+		}
+		ok, err := d.encryptor.TestPassphrase(devicePath, []byte(secrets[key]))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to test luks passphrase: %v", err)
+		}
+		if ok {
+			return []byte(secrets[key]), nil
+		}
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "no passphrase in the secrets opens encrypted volume '%s'", devicePath)
+}
+
+// closeVolumeMappings closes the LUKS and dm-integrity mappings stacked on a volume, from the top down
+func (d *Driver) closeVolumeMappings(vgName, lvName string) error {
+	name := luksMapperName(vgName, lvName)
+	open, err := d.encryptor.IsOpen(name)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to check luks mapping: %v", err)
+	}
+	if open {
+		klog.InfoS("Closing LUKS volume", "name", name)
+		if err := d.encryptor.Close(name); err != nil {
+			return status.Errorf(codes.Internal, "failed to close luks volume: %v", err)
+		}
+	}
+
+	name = integrityMapperName(vgName, lvName)
+	open, err = d.integrity.IsOpen(name)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to check integrity mapping: %v", err)
+	}
+	if open {
+		klog.InfoS("Closing integrity volume", "name", name)
+		if err := d.integrity.Close(name); err != nil {
+			return status.Errorf(codes.Internal, "failed to close integrity volume: %v", err)
+		}
+	}
+	return nil
+}
+
+// stagedDevicePath returns the device holding the data of a volume on this node, which is the topmost of its LUKS and
+// dm-integrity mappings that is open, or the LV itself
+func (d *Driver) stagedDevicePath(vgName, lvName string) (string, error) {
+	name := luksMapperName(vgName, lvName)
+	open, err := d.encryptor.IsOpen(name)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to check luks mapping: %v", err)
+	}
+	if open {
+		return mapperDevicePath(name), nil
+	}
+	name = integrityMapperName(vgName, lvName)
+	open, err = d.integrity.IsOpen(name)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to check integrity mapping: %v", err)
+	}
+	if open {
+		return mapperDevicePath(name), nil
+	}
+	return getDevicePath(vgName, lvName), nil
+}
+
 func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
 	klog.InfoS("NodeUnstageVolume called", "req", req)
 
@@ -92,6 +284,7 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 	if err != nil {
 		return nil, err
 	}
+	integrityMismatches.DeleteLabelValues(req.VolumeId)
 
 	dev, refcnt, err := mount.GetDeviceNameFromMount(d.mounter, req.StagingTargetPath)
 	if err != nil {
@@ -100,6 +293,10 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 
 	if refcnt == 0 {
 		klog.InfoS("Staging path does not exist, assuming unmounted", "stagingPath", req.StagingTargetPath)
+		// block volumes are never mounted at the staging path, but may still have been opened
+		if err := d.closeVolumeMappings(vgName, lvName); err != nil {
+			return nil, err
+		}
 		return &csi.NodeUnstageVolumeResponse{}, nil
 	}
 
@@ -112,6 +309,10 @@ func (d *Driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolu
 		return nil, status.Errorf(codes.Internal, "failed to unmount volume: %v", err)
 	}
 
+	if err := d.closeVolumeMappings(vgName, lvName); err != nil {
+		return nil, err
+	}
+
 	// check if the volume was already deactivated
 	lv, err := d.lvm.GetLV(vgName, lvName)
 	if lv == nil && err == nil {
@@ -193,7 +394,12 @@ func (d *Driver) nodePublishVolumeMount(req *csi.NodePublishVolumeRequest) (*csi
 func (d *Driver) nodePublishVolumeBlock(req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
 	klog.InfoS("Publishing block volume", "volumeId", req.VolumeId, "targetPath", req.TargetPath)
 
-	devicePath, err := getDevicePath(req.VolumeId)
+	vgName, lvName, err := getVGAndLVNames(req.VolumeId)
+	if err != nil {
+		return nil, err
+	}
+	// volumes with LUKS or dm-integrity expose the mapping opened by NodeStageVolume
+	devicePath, err := d.stagedDevicePath(vgName, lvName)
 	if err != nil {
 		return nil, err
 	}
@@ -278,42 +484,73 @@ func (d *Driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeS
 		return nil, status.Errorf(codes.Internal, "failed to check if path is block device: %v", err)
 	}
 
+	var resp *csi.NodeGetVolumeStatsResponse
 	if isBlock {
 		totalBytes, err := d.stats.GetBlockSizeBytes(req.VolumePath)
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to get block device stats: %v", err)
 		}
-		return &csi.NodeGetVolumeStatsResponse{
+		resp = &csi.NodeGetVolumeStatsResponse{
 			Usage: []*csi.VolumeUsage{
 				{
 					Total: totalBytes,
 					Unit:  csi.VolumeUsage_BYTES,
 				},
 			},
-		}, nil
+		}
+	} else {
+		available, capacity, used, inodes, inodesFree, inodesUsed, err := d.stats.GetFSStats(req.VolumePath)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get fs stats: %v", err)
+		}
+		resp = &csi.NodeGetVolumeStatsResponse{
+			Usage: []*csi.VolumeUsage{
+				{
+					Available: available,
+					Total:     capacity,
+					Used:      used,
+					Unit:      csi.VolumeUsage_BYTES,
+				},
+				{
+					Available: inodesFree,
+					Total:     inodes,
+					Used:      inodesUsed,
+					Unit:      csi.VolumeUsage_INODES,
+				},
+			},
+		}
 	}
 
-	available, capacity, used, inodes, inodesFree, inodesUsed, err := d.stats.GetFSStats(req.VolumePath)
+	// usage is logical for VDO volumes, so the condition also reports the physical usage of their pool
+	vgName, lvName, err := getVGAndLVNames(req.VolumeId)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to get fs stats: %v", err)
+		return nil, err
 	}
-
-	return &csi.NodeGetVolumeStatsResponse{
-		Usage: []*csi.VolumeUsage{
-			{
-				Available: available,
-				Total:     capacity,
-				Used:      used,
-				Unit:      csi.VolumeUsage_BYTES,
-			},
-			{
-				Available: inodesFree,
-				Total:     inodes,
-				Used:      inodesUsed,
-				Unit:      csi.VolumeUsage_INODES,
-			},
-		},
-	}, nil
+	lv, err := d.lvm.GetLV(vgName, lvName)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get lv: %v", err)
+	}
+	if lv != nil {
+		// a standalone integrity layer is only visible on the node it's open on
+		name := integrityMapperName(vgName, lvName)
+		open, err := d.integrity.IsOpen(name)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to check integrity mapping: %v", err)
+		}
+		if open {
+			mismatches, err := d.integrity.Mismatches(name)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to get integrity mismatches: %v", err)
+			}
+			lv.IntegrityMismatches += mismatches
+		}
+		integrityMismatches.WithLabelValues(req.VolumeId).Set(float64(lv.IntegrityMismatches))
+	}
+	resp.VolumeCondition, err = d.volumeHealth(req.VolumeId, lv)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
 }
 
 func (d *Driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
@@ -326,10 +563,46 @@ func (d *Driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolume
 		return nil, status.Error(codes.InvalidArgument, "volume path is required")
 	}
 
-	devicePath, err := getDevicePath(req.VolumeId)
+	vgName, lvName, err := getVGAndLVNames(req.VolumeId)
 	if err != nil {
 		return nil, err
 	}
+	devicePath := getDevicePath(vgName, lvName)
+
+	// the dm-integrity and LUKS mappings have to grow along with the LV, for both block and filesystem volumes
+	name := integrityMapperName(vgName, lvName)
+	open, err := d.integrity.IsOpen(name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check integrity mapping: %v", err)
+	}
+	if open {
+		klog.InfoS("Resizing integrity volume", "name", name)
+		if err := d.integrity.Resize(name); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to resize integrity volume: %v", err)
+		}
+		devicePath = mapperDevicePath(name)
+	}
+
+	name = luksMapperName(vgName, lvName)
+	open, err = d.encryptor.IsOpen(name)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check luks mapping: %v", err)
+	}
+	if open {
+		// the passphrase is only needed if the volume key isn't kept in the kernel keyring
+		var passphrase []byte
+		if len(req.GetSecrets()) > 0 {
+			passphrase, err = d.unlockingPassphrase(devicePath, req.GetSecrets())
+			if err != nil {
+				return nil, err
+			}
+		}
+		klog.InfoS("Resizing LUKS volume", "name", name)
+		if err := d.encryptor.Resize(name, passphrase); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to resize luks volume: %v", err)
+		}
+		devicePath = mapperDevicePath(name)
+	}
 
 	// skip block volumes
 	if req.VolumeCapability.GetBlock() != nil {
@@ -370,13 +643,47 @@ func (d *Driver) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabi
 					},
 				},
 			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+					},
+				},
+			},
 		},
 	}, nil
 }
 
 func (d *Driver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
 	klog.InfoS("NodeGetInfo called", "req", req)
+
+	vgs, err := d.lvm.ListVGs()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list vgs: %v", err)
+	}
+
+	// every complete VG the node can see is reported, so that volumes are only scheduled where their VG is available
+	segments := make(map[string]string)
+	for _, vg := range vgs {
+		if !d.isVolumeGroupAllowed(vg.Name) {
+			continue
+		}
+		if vg.Attr.IsPartial() {
+			klog.InfoS("Volume group is partial, not reporting it as accessible", "vg", vg.Name)
+			continue
+		}
+		key := volumeGroupTopologyKey(vg.Name)
+		if key == "" {
+			klog.InfoS("Volume group name is not a valid topology key, skipping", "vg", vg.Name)
+			continue
+		}
+		segments[key] = "true"
+	}
+
 	return &csi.NodeGetInfoResponse{
 		NodeId: d.nodeID,
+		AccessibleTopology: &csi.Topology{
+			Segments: segments,
+		},
 	}, nil
 }
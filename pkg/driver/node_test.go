@@ -10,6 +10,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/mount-utils"
+	utilexec "k8s.io/utils/exec"
 	testingexec "k8s.io/utils/exec/testing"
 
 	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
@@ -61,6 +62,169 @@ func (m *mockDeviceStats) IsBlockDevice(path string) (bool, error) {
 	return false, nil
 }
 
+type mockEncryptor struct {
+	format func(devicePath string, passphrase []byte) error
+	open   func(devicePath, name string, passphrase []byte) error
+	close  func(name string) error
+	resize func(name string, passphrase []byte) error
+	isOpen func(name string) (bool, error)
+
+	addKey         func(devicePath string, passphrase, newPassphrase []byte) error
+	removeKey      func(devicePath string, passphrase []byte) error
+	testPassphrase func(devicePath string, passphrase []byte) (bool, error)
+}
+
+func (m *mockEncryptor) Format(devicePath string, passphrase []byte) error {
+	if m.format != nil {
+		return m.format(devicePath, passphrase)
+	}
+	return nil
+}
+
+func (m *mockEncryptor) Open(devicePath, name string, passphrase []byte) error {
+	if m.open != nil {
+		return m.open(devicePath, name, passphrase)
+	}
+	return nil
+}
+
+func (m *mockEncryptor) Close(name string) error {
+	if m.close != nil {
+		return m.close(name)
+	}
+	return nil
+}
+
+func (m *mockEncryptor) Resize(name string, passphrase []byte) error {
+	if m.resize != nil {
+		return m.resize(name, passphrase)
+	}
+	return nil
+}
+
+func (m *mockEncryptor) IsOpen(name string) (bool, error) {
+	if m.isOpen != nil {
+		return m.isOpen(name)
+	}
+	return false, nil
+}
+
+func (m *mockEncryptor) AddKey(devicePath string, passphrase, newPassphrase []byte) error {
+	if m.addKey != nil {
+		return m.addKey(devicePath, passphrase, newPassphrase)
+	}
+	return nil
+}
+
+func (m *mockEncryptor) RemoveKey(devicePath string, passphrase []byte) error {
+	if m.removeKey != nil {
+		return m.removeKey(devicePath, passphrase)
+	}
+	return nil
+}
+
+func (m *mockEncryptor) TestPassphrase(devicePath string, passphrase []byte) (bool, error) {
+	if m.testPassphrase != nil {
+		return m.testPassphrase(devicePath, passphrase)
+	}
+	return true, nil
+}
+
+type mockIntegrityLayer struct {
+	format     func(devicePath string) error
+	open       func(devicePath, name string, recalculate bool) error
+	close      func(name string) error
+	resize     func(name string) error
+	isOpen     func(name string) (bool, error)
+	mismatches func(name string) (int64, error)
+}
+
+func (m *mockIntegrityLayer) Format(devicePath string) error {
+	if m.format != nil {
+		return m.format(devicePath)
+	}
+	return nil
+}
+
+func (m *mockIntegrityLayer) Open(devicePath, name string, recalculate bool) error {
+	if m.open != nil {
+		return m.open(devicePath, name, recalculate)
+	}
+	return nil
+}
+
+func (m *mockIntegrityLayer) Close(name string) error {
+	if m.close != nil {
+		return m.close(name)
+	}
+	return nil
+}
+
+func (m *mockIntegrityLayer) Resize(name string) error {
+	if m.resize != nil {
+		return m.resize(name)
+	}
+	return nil
+}
+
+func (m *mockIntegrityLayer) IsOpen(name string) (bool, error) {
+	if m.isOpen != nil {
+		return m.isOpen(name)
+	}
+	return false, nil
+}
+
+func (m *mockIntegrityLayer) Mismatches(name string) (int64, error) {
+	if m.mismatches != nil {
+		return m.mismatches(name)
+	}
+	return 0, nil
+}
+
+// diskFormatAction scripts a blkid run reporting the given format, or no format at all if it's empty
+func diskFormatAction(format string) testingexec.FakeCommandAction {
+	blkid := &testingexec.FakeCmd{
+		CombinedOutputScript: []testingexec.FakeAction{
+			func() ([]byte, []byte, error) {
+				if format == "" {
+					return nil, nil, testingexec.FakeExitError{Status: 2}
+				}
+				return []byte("TYPE=" + format + "\n"), nil, nil
+			},
+		},
+	}
+	return func(cmd string, args ...string) utilexec.Cmd {
+		return testingexec.InitFakeCmd(blkid, cmd, args...)
+	}
+}
+
+// unformattedDiskActions scripts the commands run by FormatAndMount for a blank device: blkid finds no
+// filesystem (exit status 2) and mkfs succeeds
+func unformattedDiskActions() []testingexec.FakeCommandAction {
+	blkid := &testingexec.FakeCmd{
+		CombinedOutputScript: []testingexec.FakeAction{
+			func() ([]byte, []byte, error) {
+				return nil, nil, testingexec.FakeExitError{Status: 2}
+			},
+		},
+	}
+	mkfs := &testingexec.FakeCmd{
+		CombinedOutputScript: []testingexec.FakeAction{
+			func() ([]byte, []byte, error) {
+				return nil, nil, nil
+			},
+		},
+	}
+	return []testingexec.FakeCommandAction{
+		func(cmd string, args ...string) utilexec.Cmd {
+			return testingexec.InitFakeCmd(blkid, cmd, args...)
+		},
+		func(cmd string, args ...string) utilexec.Cmd {
+			return testingexec.InitFakeCmd(mkfs, cmd, args...)
+		},
+	}
+}
+
 func TestNodeStageVolume(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -68,6 +232,8 @@ func TestNodeStageVolume(t *testing.T) {
 		mockLVM     *mockLVM
 		mounter     *mount.FakeMounter
 		actions     []testingexec.FakeCommandAction
+		encryptor   *mockEncryptor
+		integrity   *mockIntegrityLayer
 		expectedErr codes.Code
 		expectedLog []mount.FakeAction
 	}{
@@ -76,6 +242,7 @@ func TestNodeStageVolume(t *testing.T) {
 			req: &csi.NodeStageVolumeRequest{
 				VolumeId:          "test-vg/test-lv",
 				StagingTargetPath: "/test/path",
+				PublishContext:    map[string]string{publishedNodeKey: "test-node"},
 				VolumeCapability: &csi.VolumeCapability{
 					AccessMode: &csi.VolumeCapability_AccessMode{
 						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
@@ -87,6 +254,7 @@ func TestNodeStageVolume(t *testing.T) {
 					return &lvm.LogicalVolume{
 						Name: "test-lv",
 						VG:   "test-vg",
+						Tags: []string{publishedNodeTag("test-node")},
 						Attr: "-wi-------",
 					}, nil
 				},
@@ -95,7 +263,7 @@ func TestNodeStageVolume(t *testing.T) {
 				},
 			},
 			mounter:     &mount.FakeMounter{},
-			actions:     []testingexec.FakeCommandAction{},
+			actions:     unformattedDiskActions(),
 			expectedErr: codes.OK,
 			expectedLog: []mount.FakeAction{
 				{
@@ -111,6 +279,7 @@ func TestNodeStageVolume(t *testing.T) {
 			req: &csi.NodeStageVolumeRequest{
 				VolumeId:          "test-vg/test-lv",
 				StagingTargetPath: "/test/path",
+				PublishContext:    map[string]string{publishedNodeKey: "test-node"},
 				VolumeCapability: &csi.VolumeCapability{
 					AccessMode: &csi.VolumeCapability_AccessMode{
 						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
@@ -122,6 +291,7 @@ func TestNodeStageVolume(t *testing.T) {
 					return &lvm.LogicalVolume{
 						Name: "test-lv",
 						VG:   "test-vg",
+						Tags: []string{publishedNodeTag("test-node")},
 						Attr: "-wi-a-----",
 					}, nil
 				},
@@ -131,7 +301,7 @@ func TestNodeStageVolume(t *testing.T) {
 				},
 			},
 			mounter:     &mount.FakeMounter{},
-			actions:     []testingexec.FakeCommandAction{},
+			actions:     unformattedDiskActions(),
 			expectedErr: codes.OK,
 			expectedLog: []mount.FakeAction{
 				{
@@ -147,6 +317,7 @@ func TestNodeStageVolume(t *testing.T) {
 			req: &csi.NodeStageVolumeRequest{
 				VolumeId:          "test-vg/test-lv",
 				StagingTargetPath: "/test/path",
+				PublishContext:    map[string]string{publishedNodeKey: "test-node"},
 				VolumeCapability: &csi.VolumeCapability{
 					AccessType: &csi.VolumeCapability_Block{
 						Block: &csi.VolumeCapability_BlockVolume{},
@@ -158,6 +329,7 @@ func TestNodeStageVolume(t *testing.T) {
 					return &lvm.LogicalVolume{
 						Name: "test-lv",
 						VG:   "test-vg",
+						Tags: []string{publishedNodeTag("test-node")},
 						Attr: "-wi-------",
 					}, nil
 				},
@@ -173,6 +345,7 @@ func TestNodeStageVolume(t *testing.T) {
 			req: &csi.NodeStageVolumeRequest{
 				VolumeId:          "test-vg/test-lv",
 				StagingTargetPath: "/test/path",
+				PublishContext:    map[string]string{publishedNodeKey: "test-node"},
 				VolumeCapability: &csi.VolumeCapability{
 					AccessMode: &csi.VolumeCapability_AccessMode{
 						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
@@ -184,6 +357,7 @@ func TestNodeStageVolume(t *testing.T) {
 					return &lvm.LogicalVolume{
 						Name: "test-lv",
 						VG:   "test-vg",
+						Tags: []string{publishedNodeTag("test-node")},
 						Attr: "-wi-a-----",
 					}, nil
 				},
@@ -193,6 +367,7 @@ func TestNodeStageVolume(t *testing.T) {
 				},
 			},
 			mounter:     &mount.FakeMounter{},
+			actions:     unformattedDiskActions(),
 			expectedErr: codes.OK,
 			expectedLog: []mount.FakeAction{
 				{
@@ -208,6 +383,7 @@ func TestNodeStageVolume(t *testing.T) {
 			req: &csi.NodeStageVolumeRequest{
 				VolumeId:          "test-vg/test-lv",
 				StagingTargetPath: "/test/path",
+				PublishContext:    map[string]string{publishedNodeKey: "test-node"},
 				VolumeCapability: &csi.VolumeCapability{
 					AccessMode: &csi.VolumeCapability_AccessMode{
 						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
@@ -226,11 +402,130 @@ func TestNodeStageVolume(t *testing.T) {
 			mounter:     &mount.FakeMounter{},
 			expectedErr: codes.NotFound,
 		},
+		{
+			name: "should fail if volume is published to another node",
+			req: &csi.NodeStageVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				StagingTargetPath: "/test/path",
+				PublishContext:    map[string]string{publishedNodeKey: "other-node"},
+				VolumeCapability: &csi.VolumeCapability{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					assert.Fail(t, "getLV should not have been called")
+					return nil, nil
+				},
+			},
+			mounter:     &mount.FakeMounter{},
+			expectedErr: codes.FailedPrecondition,
+		},
+		{
+			name: "should fail if lv is not tagged as published to node",
+			req: &csi.NodeStageVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				StagingTargetPath: "/test/path",
+				PublishContext:    map[string]string{publishedNodeKey: "test-node"},
+				VolumeCapability: &csi.VolumeCapability{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Tags: []string{publishedNodeTag("other-node")},
+						Attr: "-wi-------",
+					}, nil
+				},
+				activateLV: func(vg, name string) error {
+					assert.Fail(t, "activateLV should not have been called")
+					return nil
+				},
+			},
+			mounter:     &mount.FakeMounter{},
+			expectedErr: codes.FailedPrecondition,
+		},
+		{
+			name: "should adopt volume that isn't tracked yet",
+			req: &csi.NodeStageVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				StagingTargetPath: "/test/path",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Attr: "-wi-------",
+					}, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Equal(t, []string{publishedNodeTag("test-node")}, add)
+					assert.Empty(t, remove)
+					return nil
+				},
+				activateLV: func(vg, name string) error {
+					return nil
+				},
+			},
+			mounter:     &mount.FakeMounter{},
+			actions:     unformattedDiskActions(),
+			expectedErr: codes.OK,
+			expectedLog: []mount.FakeAction{
+				{
+					Action: "mount",
+					Source: "/dev/test-vg/test-lv",
+					Target: "/test/path",
+					FSType: "ext4",
+				},
+			},
+		},
+		{
+			name: "should not adopt volume that is published to another node",
+			req: &csi.NodeStageVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				StagingTargetPath: "/test/path",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessMode: &csi.VolumeCapability_AccessMode{
+						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+					},
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Tags: []string{publishedNodeTag("other-node")},
+						Attr: "-wi-------",
+					}, nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					assert.Fail(t, "updateLVTags should not have been called")
+					return nil
+				},
+			},
+			mounter:     &mount.FakeMounter{},
+			expectedErr: codes.FailedPrecondition,
+		},
 		{
 			name: "should fail on internal error on get lv",
 			req: &csi.NodeStageVolumeRequest{
 				VolumeId:          "test-vg/test-lv",
 				StagingTargetPath: "/test/path",
+				PublishContext:    map[string]string{publishedNodeKey: "test-node"},
 				VolumeCapability: &csi.VolumeCapability{
 					AccessMode: &csi.VolumeCapability_AccessMode{
 						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
@@ -254,6 +549,7 @@ func TestNodeStageVolume(t *testing.T) {
 			req: &csi.NodeStageVolumeRequest{
 				VolumeId:          "test-vg/test-lv",
 				StagingTargetPath: "/test/path",
+				PublishContext:    map[string]string{publishedNodeKey: "test-node"},
 				VolumeCapability: &csi.VolumeCapability{
 					AccessMode: &csi.VolumeCapability_AccessMode{
 						Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
@@ -265,6 +561,7 @@ func TestNodeStageVolume(t *testing.T) {
 					return &lvm.LogicalVolume{
 						Name: "test-lv",
 						VG:   "test-vg",
+						Tags: []string{publishedNodeTag("test-node")},
 						Attr: "-wi-------",
 					}, nil
 				},
@@ -272,16 +569,281 @@ func TestNodeStageVolume(t *testing.T) {
 					return fmt.Errorf("some other error")
 				},
 			},
-			mounter:     &mount.FakeMounter{},
-			expectedErr: codes.Internal,
+			mounter:     &mount.FakeMounter{},
+			expectedErr: codes.Internal,
+		},
+		{
+			name: "should format and open blank encrypted volume",
+			req:  encryptedStageRequest(map[string]string{passphraseKey: "secret"}),
+			mockLVM: &mockLVM{
+				getLV: encryptedLV,
+			},
+			mounter: &mount.FakeMounter{},
+			actions: append([]testingexec.FakeCommandAction{diskFormatAction("")}, unformattedDiskActions()...),
+			encryptor: &mockEncryptor{
+				format: func(devicePath string, passphrase []byte) error {
+					assert.Equal(t, "/dev/test-vg/test-lv", devicePath)
+					assert.Equal(t, "secret", string(passphrase))
+					return nil
+				},
+				open: func(devicePath, name string, passphrase []byte) error {
+					assert.Equal(t, "/dev/test-vg/test-lv", devicePath)
+					assert.Equal(t, "test--vg-test--lv-crypt", name)
+					assert.Equal(t, "secret", string(passphrase))
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedLog: []mount.FakeAction{
+				{
+					Action: "mount",
+					Source: "/dev/mapper/test--vg-test--lv-crypt",
+					Target: "/test/path",
+					FSType: "ext4",
+				},
+			},
+		},
+		{
+			name: "should open encrypted volume without formatting it again",
+			req:  encryptedStageRequest(map[string]string{passphraseKey: "secret"}),
+			mockLVM: &mockLVM{
+				getLV: encryptedLV,
+			},
+			mounter: &mount.FakeMounter{},
+			actions: append([]testingexec.FakeCommandAction{diskFormatAction(luksDiskFormat)}, unformattedDiskActions()...),
+			encryptor: &mockEncryptor{
+				format: func(devicePath string, passphrase []byte) error {
+					assert.Fail(t, "format should not be called")
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedLog: []mount.FakeAction{
+				{
+					Action: "mount",
+					Source: "/dev/mapper/test--vg-test--lv-crypt",
+					Target: "/test/path",
+					FSType: "ext4",
+				},
+			},
+		},
+		{
+			name: "should open encrypted volume with previous passphrase while its key is rotated",
+			req:  encryptedStageRequest(map[string]string{passphraseKey: "new", previousPassphraseKey: "old"}),
+			mockLVM: &mockLVM{
+				getLV: encryptedLV,
+			},
+			mounter: &mount.FakeMounter{},
+			actions: append([]testingexec.FakeCommandAction{diskFormatAction(luksDiskFormat)}, unformattedDiskActions()...),
+			encryptor: &mockEncryptor{
+				testPassphrase: func(devicePath string, passphrase []byte) (bool, error) {
+					return string(passphrase) == "old", nil
+				},
+				open: func(devicePath, name string, passphrase []byte) error {
+					assert.Equal(t, "old", string(passphrase))
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedLog: []mount.FakeAction{
+				{
+					Action: "mount",
+					Source: "/dev/mapper/test--vg-test--lv-crypt",
+					Target: "/test/path",
+					FSType: "ext4",
+				},
+			},
+		},
+		{
+			name: "should reuse open luks mapping",
+			req:  encryptedStageRequest(map[string]string{passphraseKey: "secret"}),
+			mockLVM: &mockLVM{
+				getLV: encryptedLV,
+			},
+			mounter: &mount.FakeMounter{},
+			actions: unformattedDiskActions(),
+			encryptor: &mockEncryptor{
+				isOpen: func(name string) (bool, error) {
+					return true, nil
+				},
+				open: func(devicePath, name string, passphrase []byte) error {
+					assert.Fail(t, "open should not be called")
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedLog: []mount.FakeAction{
+				{
+					Action: "mount",
+					Source: "/dev/mapper/test--vg-test--lv-crypt",
+					Target: "/test/path",
+					FSType: "ext4",
+				},
+			},
+		},
+		{
+			name: "should fail on encrypted volume without passphrase",
+			req:  encryptedStageRequest(nil),
+			mockLVM: &mockLVM{
+				getLV: encryptedLV,
+			},
+			mounter:     &mount.FakeMounter{},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			name: "should not encrypt volume with existing data",
+			req:  encryptedStageRequest(map[string]string{passphraseKey: "secret"}),
+			mockLVM: &mockLVM{
+				getLV: encryptedLV,
+			},
+			mounter: &mount.FakeMounter{},
+			actions: []testingexec.FakeCommandAction{diskFormatAction("ext4")},
+			encryptor: &mockEncryptor{
+				format: func(devicePath string, passphrase []byte) error {
+					assert.Fail(t, "format should not be called")
+					return nil
+				},
+			},
+			expectedErr: codes.FailedPrecondition,
+		},
+		{
+			name: "should fail on internal error on luks open",
+			req:  encryptedStageRequest(map[string]string{passphraseKey: "wrong"}),
+			mockLVM: &mockLVM{
+				getLV: encryptedLV,
+			},
+			mounter: &mount.FakeMounter{},
+			actions: []testingexec.FakeCommandAction{diskFormatAction(luksDiskFormat)},
+			encryptor: &mockEncryptor{
+				open: func(devicePath, name string, passphrase []byte) error {
+					return fmt.Errorf("no key available with this passphrase")
+				},
+			},
+			expectedErr: codes.Internal,
+		},
+		{
+			name: "should format and open blank integrity volume",
+			req:  encryptedStageRequest(nil),
+			mockLVM: &mockLVM{
+				getLV: integrityLV,
+			},
+			mounter: &mount.FakeMounter{},
+			actions: append([]testingexec.FakeCommandAction{diskFormatAction("")}, unformattedDiskActions()...),
+			integrity: &mockIntegrityLayer{
+				format: func(devicePath string) error {
+					assert.Equal(t, "/dev/test-vg/test-lv", devicePath)
+					return nil
+				},
+				open: func(devicePath, name string, recalculate bool) error {
+					assert.Equal(t, "/dev/test-vg/test-lv", devicePath)
+					assert.Equal(t, "test--vg-test--lv-integrity", name)
+					assert.True(t, recalculate)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedLog: []mount.FakeAction{
+				{
+					Action: "mount",
+					Source: "/dev/mapper/test--vg-test--lv-integrity",
+					Target: "/test/path",
+					FSType: "ext4",
+				},
+			},
+		},
+		{
+			name: "should open integrity volume without formatting it again",
+			req:  encryptedStageRequest(nil),
+			mockLVM: &mockLVM{
+				getLV: integrityLV,
+			},
+			mounter: &mount.FakeMounter{},
+			actions: append([]testingexec.FakeCommandAction{diskFormatAction(integrityDiskFormat)}, unformattedDiskActions()...),
+			integrity: &mockIntegrityLayer{
+				format: func(devicePath string) error {
+					assert.Fail(t, "format should not be called")
+					return nil
+				},
+				open: func(devicePath, name string, recalculate bool) error {
+					assert.False(t, recalculate)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedLog: []mount.FakeAction{
+				{
+					Action: "mount",
+					Source: "/dev/mapper/test--vg-test--lv-integrity",
+					Target: "/test/path",
+					FSType: "ext4",
+				},
+			},
+		},
+		{
+			name: "should not add integrity to volume with existing data",
+			req:  encryptedStageRequest(nil),
+			mockLVM: &mockLVM{
+				getLV: integrityLV,
+			},
+			mounter: &mount.FakeMounter{},
+			actions: []testingexec.FakeCommandAction{diskFormatAction("ext4")},
+			integrity: &mockIntegrityLayer{
+				format: func(devicePath string) error {
+					assert.Fail(t, "format should not be called")
+					return nil
+				},
+			},
+			expectedErr: codes.FailedPrecondition,
+		},
+		{
+			name: "should open luks on top of integrity",
+			req:  encryptedStageRequest(map[string]string{passphraseKey: "secret"}),
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					lv, _ := integrityLV(vg, name)
+					lv.Tags = append(lv.Tags, encryptedTag)
+					return lv, nil
+				},
+			},
+			mounter: &mount.FakeMounter{},
+			actions: append([]testingexec.FakeCommandAction{diskFormatAction(luksDiskFormat)}, unformattedDiskActions()...),
+			integrity: &mockIntegrityLayer{
+				isOpen: func(name string) (bool, error) {
+					return true, nil
+				},
+			},
+			encryptor: &mockEncryptor{
+				open: func(devicePath, name string, passphrase []byte) error {
+					assert.Equal(t, "/dev/mapper/test--vg-test--lv-integrity", devicePath)
+					return nil
+				},
+			},
+			expectedErr: codes.OK,
+			expectedLog: []mount.FakeAction{
+				{
+					Action: "mount",
+					Source: "/dev/mapper/test--vg-test--lv-crypt",
+					Target: "/test/path",
+					FSType: "ext4",
+				},
+			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			driver := NewDriver("test-endpoint", nil, tt.mockLVM)
+			driver.nodeID = "test-node"
 			exec := &testingexec.FakeExec{CommandScript: tt.actions}
 			driver.mounter = &mount.SafeFormatAndMount{Interface: tt.mounter, Exec: exec}
+			driver.encryptor = &mockEncryptor{}
+			if tt.encryptor != nil {
+				driver.encryptor = tt.encryptor
+			}
+			driver.integrity = &mockIntegrityLayer{}
+			if tt.integrity != nil {
+				driver.integrity = tt.integrity
+			}
 			driver.resizer = &mockResizer{
 				needResize: func(devicePath, deviceMountPath string) (bool, error) {
 					return false, nil
@@ -305,12 +867,46 @@ func TestNodeStageVolume(t *testing.T) {
 	}
 }
 
+func encryptedStageRequest(secrets map[string]string) *csi.NodeStageVolumeRequest {
+	return &csi.NodeStageVolumeRequest{
+		VolumeId:          "test-vg/test-lv",
+		StagingTargetPath: "/test/path",
+		PublishContext:    map[string]string{publishedNodeKey: "test-node"},
+		VolumeCapability: &csi.VolumeCapability{
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+		Secrets: secrets,
+	}
+}
+
+func encryptedLV(vg, name string) (*lvm.LogicalVolume, error) {
+	return &lvm.LogicalVolume{
+		Name: "test-lv",
+		VG:   "test-vg",
+		Tags: []string{publishedNodeTag("test-node"), encryptedTag},
+		Attr: "-wi-a-----",
+	}, nil
+}
+
+func integrityLV(vg, name string) (*lvm.LogicalVolume, error) {
+	return &lvm.LogicalVolume{
+		Name: "test-lv",
+		VG:   "test-vg",
+		Tags: []string{publishedNodeTag("test-node"), integrityTag},
+		Attr: "-wi-a-----",
+	}, nil
+}
+
 func TestNodeExpandVolume(t *testing.T) {
 	tests := []struct {
 		name        string
 		req         *csi.NodeExpandVolumeRequest
 		mounter     *mount.FakeMounter
 		mockResizer *mockResizer
+		encryptor   *mockEncryptor
+		integrity   *mockIntegrityLayer
 		useTempDir  bool
 		expectedErr codes.Code
 	}{
@@ -397,6 +993,125 @@ func TestNodeExpandVolume(t *testing.T) {
 			useTempDir:  true,
 			expectedErr: codes.Internal,
 		},
+		{
+			name: "should resize luks mapping before the filesystem",
+			req: &csi.NodeExpandVolumeRequest{
+				VolumeId:   "test-vg/test-lv",
+				VolumePath: "/test/path",
+				Secrets:    map[string]string{passphraseKey: "secret"},
+			},
+			mounter: &mount.FakeMounter{},
+			encryptor: &mockEncryptor{
+				isOpen: func(name string) (bool, error) {
+					return true, nil
+				},
+				resize: func(name string, passphrase []byte) error {
+					assert.Equal(t, "test--vg-test--lv-crypt", name)
+					assert.Equal(t, "secret", string(passphrase))
+					return nil
+				},
+			},
+			mockResizer: &mockResizer{
+				resize: func(devicePath, deviceMountPath string) (bool, error) {
+					assert.Equal(t, "/dev/mapper/test--vg-test--lv-crypt", devicePath)
+					return true, nil
+				},
+			},
+			useTempDir:  true,
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should resize luks mapping of block volumes",
+			req: &csi.NodeExpandVolumeRequest{
+				VolumeId:   "test-vg/test-lv",
+				VolumePath: "/dev/test-vg/test-lv",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Block{
+						Block: &csi.VolumeCapability_BlockVolume{},
+					},
+				},
+			},
+			mounter: &mount.FakeMounter{},
+			encryptor: &mockEncryptor{
+				isOpen: func(name string) (bool, error) {
+					return true, nil
+				},
+			},
+			mockResizer: &mockResizer{
+				resize: func(devicePath, deviceMountPath string) (bool, error) {
+					assert.Fail(t, "resize should not have been called")
+					return false, nil
+				},
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail if luks resize fails",
+			req: &csi.NodeExpandVolumeRequest{
+				VolumeId:   "test-vg/test-lv",
+				VolumePath: "/test/path",
+			},
+			mounter: &mount.FakeMounter{},
+			encryptor: &mockEncryptor{
+				isOpen: func(name string) (bool, error) {
+					return true, nil
+				},
+				resize: func(name string, passphrase []byte) error {
+					return fmt.Errorf("resize failed")
+				},
+			},
+			useTempDir:  true,
+			expectedErr: codes.Internal,
+		},
+		{
+			name: "should resize integrity mapping below luks mapping",
+			req: &csi.NodeExpandVolumeRequest{
+				VolumeId:   "test-vg/test-lv",
+				VolumePath: "/test/path",
+				Secrets:    map[string]string{passphraseKey: "secret"},
+			},
+			mounter: &mount.FakeMounter{},
+			integrity: &mockIntegrityLayer{
+				isOpen: func(name string) (bool, error) {
+					return true, nil
+				},
+				resize: func(name string) error {
+					assert.Equal(t, "test--vg-test--lv-integrity", name)
+					return nil
+				},
+			},
+			encryptor: &mockEncryptor{
+				isOpen: func(name string) (bool, error) {
+					return true, nil
+				},
+			},
+			mockResizer: &mockResizer{
+				resize: func(devicePath, deviceMountPath string) (bool, error) {
+					assert.Equal(t, "/dev/mapper/test--vg-test--lv-crypt", devicePath)
+					return true, nil
+				},
+			},
+			useTempDir:  true,
+			expectedErr: codes.OK,
+		},
+		{
+			name: "should fail if integrity resize fails",
+			req: &csi.NodeExpandVolumeRequest{
+				VolumeId:   "test-vg/test-lv",
+				VolumePath: "/test/path",
+			},
+			mounter: &mount.FakeMounter{},
+			integrity: &mockIntegrityLayer{
+				isOpen: func(name string) (bool, error) {
+					return true, nil
+				},
+				resize: func(name string) error {
+					return fmt.Errorf("resize failed")
+				},
+			},
+			useTempDir:  true,
+			expectedErr: codes.Internal,
+		},
 	}
 
 	for _, tt := range tests {
@@ -419,6 +1134,14 @@ func TestNodeExpandVolume(t *testing.T) {
 			if tt.mockResizer != nil {
 				driver.resizer = tt.mockResizer
 			}
+			driver.encryptor = &mockEncryptor{}
+			if tt.encryptor != nil {
+				driver.encryptor = tt.encryptor
+			}
+			driver.integrity = &mockIntegrityLayer{}
+			if tt.integrity != nil {
+				driver.integrity = tt.integrity
+			}
 
 			_, err := driver.NodeExpandVolume(context.Background(), tt.req)
 			if tt.expectedErr == codes.OK {
@@ -612,14 +1335,125 @@ func TestNodeUnstageVolume(t *testing.T) {
 	}
 }
 
+func TestNodeUnstageVolumeEncrypted(t *testing.T) {
+	tests := []struct {
+		name          string
+		mounter       *mount.FakeMounter
+		integrity     bool
+		expectedCalls []string
+	}{
+		{
+			name: "should close luks mapping before deactivating lv",
+			mounter: &mount.FakeMounter{
+				MountPoints: []mount.MountPoint{
+					{
+						Device: "/dev/mapper/test--vg-test--lv-crypt",
+						Path:   "/test/path",
+					},
+				},
+			},
+			expectedCalls: []string{"close test--vg-test--lv-crypt", "deactivate test-vg/test-lv"},
+		},
+		{
+			name:          "should close luks mapping of block volume",
+			mounter:       &mount.FakeMounter{},
+			expectedCalls: []string{"close test--vg-test--lv-crypt"},
+		},
+		{
+			name: "should close luks mapping before integrity mapping",
+			mounter: &mount.FakeMounter{
+				MountPoints: []mount.MountPoint{
+					{
+						Device: "/dev/mapper/test--vg-test--lv-crypt",
+						Path:   "/test/path",
+					},
+				},
+			},
+			integrity:     true,
+			expectedCalls: []string{"close test--vg-test--lv-crypt", "close test--vg-test--lv-integrity", "deactivate test-vg/test-lv"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			driver := NewDriver("test-endpoint", nil, &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{
+						Name: "test-lv",
+						VG:   "test-vg",
+						Tags: []string{encryptedTag},
+						Attr: "-wi-a-----",
+					}, nil
+				},
+				deactivateLV: func(vg, name string) error {
+					calls = append(calls, "deactivate "+vg+"/"+name)
+					return nil
+				},
+			})
+			driver.mounter = &mount.SafeFormatAndMount{Interface: tt.mounter, Exec: &testingexec.FakeExec{}}
+			driver.encryptor = &mockEncryptor{
+				isOpen: func(name string) (bool, error) {
+					return true, nil
+				},
+				close: func(name string) error {
+					calls = append(calls, "close "+name)
+					return nil
+				},
+			}
+			driver.integrity = &mockIntegrityLayer{
+				isOpen: func(name string) (bool, error) {
+					return tt.integrity, nil
+				},
+				close: func(name string) error {
+					calls = append(calls, "close "+name)
+					return nil
+				},
+			}
+
+			_, err := driver.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+				VolumeId:          "test-vg/test-lv",
+				StagingTargetPath: "/test/path",
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedCalls, calls)
+		})
+	}
+}
+
 func TestNodeGetInfo(t *testing.T) {
-	driver := NewDriver("test-endpoint", nil, nil)
+	mockLVM := &mockLVM{
+		listVGs: func() ([]*lvm.VolumeGroup, error) {
+			return []*lvm.VolumeGroup{
+				{Name: "test-vg", Attr: "wz--n-"},
+				{Name: "partial-vg", Attr: "wz-pn-"},
+				{Name: "other-vg", Attr: "wz--n-"},
+				{Name: "invalid+vg", Attr: "wz--n-"},
+			}, nil
+		},
+	}
+	driver := NewDriver("test-endpoint", []string{"test-vg", "partial-vg", "invalid+vg"}, mockLVM)
 	driver.nodeID = "test-node-id"
 
 	resp, err := driver.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
 	assert.NoError(t, err)
 	assert.NotNil(t, resp)
 	assert.Equal(t, "test-node-id", resp.NodeId)
+	assert.Equal(t, map[string]string{topologyKeyPrefix + "test-vg": "true"}, resp.AccessibleTopology.Segments)
+}
+
+func TestNodeGetInfoListVGsError(t *testing.T) {
+	mockLVM := &mockLVM{
+		listVGs: func() ([]*lvm.VolumeGroup, error) {
+			return nil, fmt.Errorf("some error")
+		},
+	}
+	driver := NewDriver("test-endpoint", nil, mockLVM)
+
+	_, err := driver.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	st, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.Internal, st.Code())
 }
 
 func TestNodeGetVolumeStats(t *testing.T) {
@@ -627,6 +1461,8 @@ func TestNodeGetVolumeStats(t *testing.T) {
 		name            string
 		req             *csi.NodeGetVolumeStatsRequest
 		mockDeviceStats *mockDeviceStats
+		mockLVM         *mockLVM
+		integrity       *mockIntegrityLayer
 		expectedErr     codes.Code
 		expectedResp    *csi.NodeGetVolumeStatsResponse
 		useTempDir      bool
@@ -662,6 +1498,10 @@ func TestNodeGetVolumeStats(t *testing.T) {
 						Unit:      csi.VolumeUsage_INODES,
 					},
 				},
+				VolumeCondition: &csi.VolumeCondition{
+					Abnormal: false,
+					Message:  "volume is healthy",
+				},
 			},
 		},
 		{
@@ -687,6 +1527,121 @@ func TestNodeGetVolumeStats(t *testing.T) {
 						Unit:  csi.VolumeUsage_BYTES,
 					},
 				},
+				VolumeCondition: &csi.VolumeCondition{
+					Abnormal: false,
+					Message:  "volume is healthy",
+				},
+			},
+		},
+		{
+			name: "should report physical usage of vdo volume",
+			req: &csi.NodeGetVolumeStatsRequest{
+				VolumeId:   "test-vg/test-lv",
+				VolumePath: "/test/path",
+			},
+			mockDeviceStats: &mockDeviceStats{
+				isBlockDevice: func(path string) (bool, error) {
+					return true, nil
+				},
+				getBlockSizeBytes: func(devicePath string) (int64, error) {
+					return 4096, nil
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Size: 4096, Attr: "vwi-a-v---", Pool: "test-lv_vdopool"}, nil
+				},
+				getVDOPool: func(vg, name string) (*lvm.VDOPool, error) {
+					return &lvm.VDOPool{Name: name, VG: vg, Size: 2048, UsedSize: 1024, SavingPercent: 75, UsageKnown: true}, nil
+				},
+			},
+			useTempDir:  true,
+			expectedErr: codes.OK,
+			expectedResp: &csi.NodeGetVolumeStatsResponse{
+				Usage: []*csi.VolumeUsage{
+					{
+						Total: 4096,
+						Unit:  csi.VolumeUsage_BYTES,
+					},
+				},
+				VolumeCondition: &csi.VolumeCondition{
+					Abnormal: false,
+					Message:  "vdo pool 'test-lv_vdopool' uses 1024 of 2048 physical bytes for 4096 logical bytes, saving 75.00%",
+				},
+			},
+		},
+		{
+			name: "should report unknown physical usage of vdo volume",
+			req: &csi.NodeGetVolumeStatsRequest{
+				VolumeId:   "test-vg/test-lv",
+				VolumePath: "/test/path",
+			},
+			mockDeviceStats: &mockDeviceStats{
+				isBlockDevice: func(path string) (bool, error) {
+					return true, nil
+				},
+				getBlockSizeBytes: func(devicePath string) (int64, error) {
+					return 4096, nil
+				},
+			},
+			mockLVM: &mockLVM{
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					return &lvm.LogicalVolume{Name: name, VG: vg, Size: 4096, Attr: "vwi-a-v---", Pool: "test-lv_vdopool"}, nil
+				},
+				getVDOPool: func(vg, name string) (*lvm.VDOPool, error) {
+					return &lvm.VDOPool{Name: name, VG: vg, Size: 2048}, nil
+				},
+			},
+			useTempDir:  true,
+			expectedErr: codes.OK,
+			expectedResp: &csi.NodeGetVolumeStatsResponse{
+				Usage: []*csi.VolumeUsage{
+					{
+						Total: 4096,
+						Unit:  csi.VolumeUsage_BYTES,
+					},
+				},
+				VolumeCondition: &csi.VolumeCondition{
+					Abnormal: false,
+					Message:  "physical usage of vdo pool 'test-lv_vdopool' of lv 'test-lv' is unknown, it is not active on this node",
+				},
+			},
+		},
+		{
+			name: "should report mismatches of integrity mapping",
+			req: &csi.NodeGetVolumeStatsRequest{
+				VolumeId:   "test-vg/test-lv",
+				VolumePath: "/test/path",
+			},
+			mockDeviceStats: &mockDeviceStats{
+				isBlockDevice: func(path string) (bool, error) {
+					return true, nil
+				},
+				getBlockSizeBytes: func(devicePath string) (int64, error) {
+					return 1024, nil
+				},
+			},
+			integrity: &mockIntegrityLayer{
+				isOpen: func(name string) (bool, error) {
+					return name == "test--vg-test--lv-integrity", nil
+				},
+				mismatches: func(name string) (int64, error) {
+					return 3, nil
+				},
+			},
+			useTempDir:  true,
+			expectedErr: codes.OK,
+			expectedResp: &csi.NodeGetVolumeStatsResponse{
+				Usage: []*csi.VolumeUsage{
+					{
+						Total: 1024,
+						Unit:  csi.VolumeUsage_BYTES,
+					},
+				},
+				VolumeCondition: &csi.VolumeCondition{
+					Abnormal: true,
+					Message:  "3 integrity mismatches were detected on lv 'test-lv'",
+				},
 			},
 		},
 		{
@@ -725,12 +1680,24 @@ func TestNodeGetVolumeStats(t *testing.T) {
 				tt.req.VolumePath = tmpDir
 			}
 
-			driver := NewDriver("test-endpoint", nil, nil)
+			lvmMock := tt.mockLVM
+			if lvmMock == nil {
+				lvmMock = &mockLVM{
+					getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+						return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "-wi-a-----"}, nil
+					},
+				}
+			}
+			driver := NewDriver("test-endpoint", nil, lvmMock)
 			if tt.mockDeviceStats != nil {
 				driver.stats = tt.mockDeviceStats
 			} else {
 				driver.stats = &mockDeviceStats{}
 			}
+			driver.integrity = &mockIntegrityLayer{}
+			if tt.integrity != nil {
+				driver.integrity = tt.integrity
+			}
 
 			resp, err := driver.NodeGetVolumeStats(context.Background(), tt.req)
 			if tt.expectedErr == codes.OK {
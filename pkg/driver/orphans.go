@@ -0,0 +1,150 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+
+	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
+)
+
+var orphanedVolumes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "csi_shared_lvm_orphaned_volumes",
+	Help: "Number of volumes in the volume group that no PersistentVolume refers to.",
+}, []string{"vg"})
+
+func init() {
+	prometheus.MustRegister(orphanedVolumes)
+}
+
+// orphanMinAge is how old a volume has to be before it's considered orphaned, since CreateVolume returns before the
+// external-provisioner creates its PersistentVolume
+const orphanMinAge = 10 * time.Minute
+
+// VolumeLister returns the ids of the volumes of the driver that are referred to by a PersistentVolume
+type VolumeLister interface {
+	ListVolumeIDs(ctx context.Context) ([]string, error)
+}
+
+type persistentVolumeLister struct {
+	client kubernetes.Interface
+}
+
+// NewPersistentVolumeLister returns a VolumeLister that reads the PersistentVolumes from the Kubernetes API
+func NewPersistentVolumeLister(client kubernetes.Interface) VolumeLister {
+	return &persistentVolumeLister{client: client}
+}
+
+func (l *persistentVolumeLister) ListVolumeIDs(ctx context.Context) ([]string, error) {
+	pvs, err := l.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list persistent volumes: %v", err)
+	}
+	var ids []string
+	for _, pv := range pvs.Items {
+		if pv.Spec.CSI != nil && pv.Spec.CSI.Driver == DriverName {
+			ids = append(ids, pv.Spec.CSI.VolumeHandle)
+		}
+	}
+	return ids, nil
+}
+
+// RunOrphanReconciler periodically looks for volumes that no PersistentVolume refers to anymore until ctx is
+// cancelled, e.g. after a failed DeleteVolume or a PersistentVolume that was deleted by hand. Orphans are reported with
+// an event and the csi_shared_lvm_orphaned_volumes metric, and deleted once their grace period passed if enabled by
+// WithOrphanDeletion.
+func (d *Driver) RunOrphanReconciler(ctx context.Context, interval time.Duration, lister VolumeLister) {
+	klog.InfoS("Starting orphan reconciler", "interval", interval, "gracePeriod", d.orphanGracePeriod)
+	// when each orphan was first seen, keyed by volume id, so that it's only reported once
+	orphanedSince := map[string]time.Time{}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		d.reconcileOrphans(ctx, lister, orphanedSince, time.Now())
+	}, interval)
+}
+
+func (d *Driver) reconcileOrphans(ctx context.Context, lister VolumeLister, orphanedSince map[string]time.Time, now time.Time) {
+	// a volume is only an orphan if the list of PersistentVolumes is complete
+	ids, err := lister.ListVolumeIDs(ctx)
+	if err != nil {
+		klog.ErrorS(err, "Failed to list volumes referred to by persistent volumes")
+		return
+	}
+	referenced := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		referenced[id] = true
+	}
+
+	orphanedVolumes.Reset()
+	orphans := map[string]bool{}
+	complete := true
+	for _, vgName := range d.volumeGroupsToList() {
+		lvs, err := d.lvm.ListOwnedLVs(vgName)
+		if err != nil {
+			klog.ErrorS(err, "Failed to list LVs", "vg", vgName)
+			complete = false
+			continue
+		}
+		counts := map[string]int{}
+		for _, lv := range lvs {
+			// snapshots are referred to by VolumeSnapshotContents, volumes still being created have no PV yet and
+			// trashed volumes lost theirs on purpose
+			if lv.HasTag(lvm.SnapshotTag) || lv.HasTag(lvm.PopulatingTag) || isTrashed(lv) || now.Sub(lv.CreationTime) < orphanMinAge {
+				continue
+			}
+			id := fmt.Sprintf("%s/%s", lv.VG, lv.Name)
+			if referenced[id] {
+				continue
+			}
+
+			since, ok := orphanedSince[id]
+			if !ok {
+				since = now
+				orphanedSince[id] = now
+				klog.InfoS("Found orphaned volume", "vg", lv.VG, "lv", lv.Name)
+				d.event(corev1.EventTypeWarning, "OrphanedVolume", "Volume %s is not referred to by any PersistentVolume", id)
+			}
+			if d.orphanGracePeriod > 0 && now.Sub(since) >= d.orphanGracePeriod && d.deleteOrphan(ctx, lv, id) {
+				continue
+			}
+			orphans[id] = true
+			counts[lv.VG]++
+		}
+		for vg, count := range counts {
+			orphanedVolumes.WithLabelValues(vg).Set(float64(count))
+		}
+	}
+
+	// volumes that were deleted or got a PersistentVolume again
+	if !complete {
+		return
+	}
+	for id := range orphanedSince {
+		if !orphans[id] {
+			delete(orphanedSince, id)
+		}
+	}
+}
+
+// deleteOrphan deletes an orphaned volume like DeleteVolume does, and returns true if it's gone
+func (d *Driver) deleteOrphan(ctx context.Context, lv *lvm.LogicalVolume, id string) bool {
+	if nodes := publishedNodes(lv); len(nodes) > 0 {
+		klog.InfoS("Not deleting orphaned volume that is still published", "vg", lv.VG, "lv", lv.Name, "nodes", nodes)
+		return false
+	}
+	klog.InfoS("Deleting orphaned volume", "vg", lv.VG, "lv", lv.Name)
+	if _, err := d.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: id}); err != nil {
+		klog.ErrorS(err, "Failed to delete orphaned volume", "vg", lv.VG, "lv", lv.Name)
+		d.event(corev1.EventTypeWarning, "OrphanedVolumeDeletionFailed", "Failed to delete orphaned volume %s: %v", id, err)
+		return false
+	}
+	d.event(corev1.EventTypeNormal, "OrphanedVolumeDeleted", "Deleted volume %s, which was not referred to by any PersistentVolume for %s", id, d.orphanGracePeriod)
+	return true
+}
@@ -0,0 +1,174 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
+)
+
+type mockVolumeLister struct {
+	listVolumeIDs func(ctx context.Context) ([]string, error)
+}
+
+func (m *mockVolumeLister) ListVolumeIDs(ctx context.Context) ([]string, error) {
+	return m.listVolumeIDs(ctx)
+}
+
+func TestReconcileOrphans(t *testing.T) {
+	now := time.Unix(1800000000, 0)
+	created := now.Add(-48 * time.Hour)
+
+	tests := []struct {
+		name             string
+		lvs              []*lvm.LogicalVolume
+		ids              []string
+		listErr          error
+		gracePeriod      time.Duration
+		orphanedSince    map[string]time.Time
+		expectedDeleted  []string
+		expectedEvents   []string
+		expectedOrphaned map[string]time.Time
+	}{
+		{
+			name: "should report new orphan",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", CreationTime: created},
+				{Name: "test-lv2", VG: "test-vg", CreationTime: created},
+			},
+			ids: []string{"test-vg/test-lv2"},
+			expectedEvents: []string{
+				"Warning OrphanedVolume Volume test-vg/test-lv is not referred to by any PersistentVolume",
+			},
+			expectedOrphaned: map[string]time.Time{"test-vg/test-lv": now},
+		},
+		{
+			name: "should report orphan only once",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", CreationTime: created},
+			},
+			orphanedSince:    map[string]time.Time{"test-vg/test-lv": created},
+			expectedOrphaned: map[string]time.Time{"test-vg/test-lv": created},
+		},
+		{
+			name: "should skip snapshots, trashed volumes and volumes being created",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-snap", VG: "test-vg", CreationTime: created, Tags: []string{lvm.SnapshotTag}},
+				{Name: "test-lv", VG: "test-vg", CreationTime: created, Tags: []string{lvm.PopulatingTag}},
+				{Name: "test-lv2", VG: "test-vg", CreationTime: now.Add(-time.Minute)},
+				{Name: "trash-test-lv", VG: "test-vg", CreationTime: created, Tags: []string{trashedTag(now)}},
+			},
+			expectedOrphaned: map[string]time.Time{},
+		},
+		{
+			name: "should forget volume that is referred to again",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", CreationTime: created},
+			},
+			ids:              []string{"test-vg/test-lv"},
+			orphanedSince:    map[string]time.Time{"test-vg/test-lv": created},
+			expectedOrphaned: map[string]time.Time{},
+		},
+		{
+			name: "should not delete orphan by default",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", CreationTime: created},
+			},
+			orphanedSince:    map[string]time.Time{"test-vg/test-lv": created},
+			expectedOrphaned: map[string]time.Time{"test-vg/test-lv": created},
+		},
+		{
+			name: "should not delete orphan before the grace period passed",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", CreationTime: created},
+			},
+			gracePeriod:      24 * time.Hour,
+			orphanedSince:    map[string]time.Time{"test-vg/test-lv": now.Add(-time.Hour)},
+			expectedOrphaned: map[string]time.Time{"test-vg/test-lv": now.Add(-time.Hour)},
+		},
+		{
+			name: "should delete orphan after the grace period",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", CreationTime: created},
+			},
+			gracePeriod:     24 * time.Hour,
+			orphanedSince:   map[string]time.Time{"test-vg/test-lv": created},
+			expectedDeleted: []string{"test-vg/test-lv"},
+			expectedEvents: []string{
+				"Normal OrphanedVolumeDeleted Deleted volume test-vg/test-lv, which was not referred to by any PersistentVolume for 24h0m0s",
+			},
+			expectedOrphaned: map[string]time.Time{},
+		},
+		{
+			name: "should not delete published orphan",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", CreationTime: created, Tags: []string{publishedNodeTag("node-1")}},
+			},
+			gracePeriod:      24 * time.Hour,
+			orphanedSince:    map[string]time.Time{"test-vg/test-lv": created},
+			expectedOrphaned: map[string]time.Time{"test-vg/test-lv": created},
+		},
+		{
+			name: "should do nothing if persistent volumes can't be listed",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", CreationTime: created},
+			},
+			listErr:          fmt.Errorf("some error"),
+			gracePeriod:      24 * time.Hour,
+			orphanedSince:    map[string]time.Time{"test-vg/test-lv": created},
+			expectedOrphaned: map[string]time.Time{"test-vg/test-lv": created},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deleted []string
+			mockLVM := &mockLVM{
+				listOwnedLVs: func(vg string) ([]*lvm.LogicalVolume, error) {
+					assert.Equal(t, "test-vg", vg)
+					return tt.lvs, nil
+				},
+				getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+					if name == cacheLVName("test-lv") {
+						return nil, nil
+					}
+					return &lvm.LogicalVolume{Name: name, VG: vg, Attr: "-wi-------"}, nil
+				},
+				deleteLV: func(vg, name string) error {
+					deleted = append(deleted, vg+"/"+name)
+					return nil
+				},
+			}
+			lister := &mockVolumeLister{
+				listVolumeIDs: func(ctx context.Context) ([]string, error) {
+					return tt.ids, tt.listErr
+				},
+			}
+			recorder := record.NewFakeRecorder(10)
+			opts := []Option{WithEventRecorder(recorder)}
+			if tt.gracePeriod > 0 {
+				opts = append(opts, WithOrphanDeletion(tt.gracePeriod))
+			}
+			driver := NewDriver("test-endpoint", []string{"test-vg"}, mockLVM, opts...)
+
+			orphanedSince := tt.orphanedSince
+			if orphanedSince == nil {
+				orphanedSince = map[string]time.Time{}
+			}
+			driver.reconcileOrphans(context.Background(), lister, orphanedSince, now)
+
+			var events []string
+			for len(recorder.Events) > 0 {
+				events = append(events, <-recorder.Events)
+			}
+			assert.Equal(t, tt.expectedDeleted, deleted)
+			assert.Equal(t, tt.expectedEvents, events)
+			assert.Equal(t, tt.expectedOrphaned, orphanedSince)
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
+)
+
+const (
+	// lastScrubTagPrefix keeps the unix time at which the last check of a RAID volume finished
+	lastScrubTagPrefix = lvm.OwnershipTag + "/last-scrub="
+	// scrubbingTag marks RAID volumes with a check started by the RAID monitor, and scrubActivatedTag those that were
+	// activated on the controller's node for it
+	scrubbingTag      = lvm.OwnershipTag + "/scrubbing"
+	scrubActivatedTag = lvm.OwnershipTag + "/scrub-activated"
+)
+
+var raidDegradedVolumes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "csi_shared_lvm_raid_degraded_volumes",
+	Help: "Number of RAID volumes in the volume group with images on missing or failed PVs.",
+}, []string{"vg"})
+
+func init() {
+	prometheus.MustRegister(raidDegradedVolumes)
+}
+
+// RunRaidMonitor periodically repairs and scrubs the RAID volumes in the allowed volume groups until ctx is cancelled.
+// Images on missing or failed PVs are replaced on spare PVs, and every volume is checked for mismatches once per scrub
+// interval, see WithRaidMaintenance. Results are reported as events on the PersistentVolumes.
+func (d *Driver) RunRaidMonitor(ctx context.Context, interval time.Duration) {
+	klog.InfoS("Starting RAID monitor", "interval", interval, "scrubInterval", d.raidScrubInterval, "sparePVTag", d.raidSparePVTag)
+	// the last RaidDegraded event of each volume that can't be repaired, keyed by volume id, so that it's only
+	// reported again once its state changed
+	degraded := map[string]string{}
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		d.checkRaidVolumes(degraded, time.Now())
+	}, interval)
+}
+
+func (d *Driver) checkRaidVolumes(degraded map[string]string, now time.Time) {
+	// volumes are only activated on this node while they aren't published, which must not change in the meantime
+	d.publishLock.Lock()
+	defer d.publishLock.Unlock()
+
+	raidDegradedVolumes.Reset()
+	var lvs []*lvm.LogicalVolume
+	complete := true
+	for _, vgName := range d.volumeGroupsToList() {
+		vgLVs, err := d.lvm.ListRaidLVs(vgName)
+		if err != nil {
+			klog.ErrorS(err, "Failed to list RAID LVs", "vg", vgName)
+			complete = false
+			continue
+		}
+		count := 0
+		for _, lv := range vgLVs {
+			// trashed volumes have no PV to report to and are left alone until they're restored
+			if isTrashed(lv) {
+				continue
+			}
+			lvs = append(lvs, lv)
+			if !lv.HasTag(lvm.SnapshotTag) && lv.NeedsRaidRepair() {
+				count++
+			}
+		}
+		raidDegradedVolumes.WithLabelValues(vgName).Set(float64(count))
+	}
+
+	scrubbing := false
+	stillDegraded := map[string]bool{}
+	for _, lv := range lvs {
+		if lv.HasTag(lvm.SnapshotTag) {
+			continue
+		}
+		if lv.NeedsRaidRepair() {
+			id := fmt.Sprintf("%s/%s", lv.VG, lv.Name)
+			stillDegraded[id] = true
+			d.repairRaidVolume(lv, degraded, id)
+		}
+		if lv.HasTag(scrubbingTag) && d.finishRaidScrub(lv, now) {
+			scrubbing = true
+		}
+	}
+
+	// volumes that were repaired or deleted are reported again if they're degraded later on
+	if complete {
+		for id := range degraded {
+			if !stillDegraded[id] {
+				delete(degraded, id)
+			}
+		}
+	}
+
+	// a check reads every image in full, so only one volume is checked at a time
+	if scrubbing {
+		return
+	}
+	for _, lv := range lvs {
+		// the tags of a volume whose check just finished are outdated, it's due again after a whole interval anyway
+		if !lv.HasTag(scrubbingTag) && d.isRaidScrubDue(lv, now) {
+			d.startRaidScrub(lv)
+			return
+		}
+	}
+}
+
+// repairRaidVolume replaces the images of a RAID volume that are on missing or failed PVs with new ones on spare PVs.
+// Volumes that can't be repaired now are reported once per state, see reportRaidDegraded.
+func (d *Driver) repairRaidVolume(lv *lvm.LogicalVolume, degraded map[string]string, id string) {
+	if d.raidSparePVTag == "" {
+		d.reportRaidDegraded(lv, degraded, id, fmt.Sprintf("RAID volume %s is %s and automatic repair is disabled", id, lv.HealthStatus))
+		return
+	}
+	// the new images are picked up by the kernel of the node the volume is active on, so it has to be this one
+	if nodes := publishedNodes(lv); !lv.Attr.IsActive() && len(nodes) > 0 {
+		d.reportRaidDegraded(lv, degraded, id, fmt.Sprintf("RAID volume %s is %s and will be repaired once it's no longer published to node '%s'", id, lv.HealthStatus, nodes[0]))
+		return
+	}
+	delete(degraded, id)
+
+	pvs, err := d.lvm.ListPVs(lv.VG)
+	if err != nil {
+		klog.ErrorS(err, "Failed to list PVs", "vg", lv.VG)
+		return
+	}
+	if !slices.ContainsFunc(pvs, func(pv *lvm.PhysicalVolume) bool {
+		return pv.FreeSize > 0 && slices.Contains(pv.Tags, d.raidSparePVTag)
+	}) {
+		d.volumeEvent(lv, corev1.EventTypeWarning, "RaidRepairFailed", "RAID volume %s/%s is %s, but no PV tagged '%s' has free space to repair it", lv.VG, lv.Name, lv.HealthStatus, d.raidSparePVTag)
+		return
+	}
+
+	if !lv.Attr.IsActive() {
+		klog.InfoS("Activating LV", "vg", lv.VG, "lv", lv.Name)
+		if err := d.lvm.ActivateLV(lv.VG, lv.Name); err != nil {
+			klog.ErrorS(err, "Failed to activate LV", "vg", lv.VG, "lv", lv.Name)
+			return
+		}
+		defer func() {
+			if err := d.lvm.DeactivateLV(lv.VG, lv.Name); err != nil {
+				klog.ErrorS(err, "Failed to deactivate LV", "vg", lv.VG, "lv", lv.Name)
+			}
+		}()
+	}
+
+	klog.InfoS("Repairing RAID LV", "vg", lv.VG, "lv", lv.Name, "health", lv.HealthStatus, "sparePVTag", d.raidSparePVTag)
+	if err := d.lvm.RepairRaidLV(lv.VG, lv.Name, []string{d.raidSparePVTag}); err != nil {
+		klog.ErrorS(err, "Failed to repair RAID LV", "vg", lv.VG, "lv", lv.Name)
+		d.volumeEvent(lv, corev1.EventTypeWarning, "RaidRepairFailed", "Failed to repair RAID volume %s/%s: %v", lv.VG, lv.Name, err)
+		return
+	}
+	d.volumeEvent(lv, corev1.EventTypeNormal, "RaidRepaired", "Replaced the failed images of RAID volume %s/%s on PVs tagged '%s'", lv.VG, lv.Name, d.raidSparePVTag)
+}
+
+// reportRaidDegraded records a warning event for a degraded RAID volume that isn't repaired, unless the same one was
+// already recorded. The csi_shared_lvm_raid_degraded_volumes metric keeps track of it in the meantime.
+func (d *Driver) reportRaidDegraded(lv *lvm.LogicalVolume, degraded map[string]string, id, message string) {
+	if degraded[id] == message {
+		return
+	}
+	degraded[id] = message
+	klog.InfoS("RAID volume is degraded", "vg", lv.VG, "lv", lv.Name, "health", lv.HealthStatus)
+	d.volumeEvent(lv, corev1.EventTypeWarning, "RaidDegraded", "%s", message)
+}
+
+// isRaidScrubDue returns true if a RAID volume can be checked on this node and its last check is older than the scrub
+// interval. Volumes that were never checked count from their creation.
+func (d *Driver) isRaidScrubDue(lv *lvm.LogicalVolume, now time.Time) bool {
+	// LVM refuses to check RAID LVs with integrity, which verify every read instead
+	if d.raidScrubInterval <= 0 || lv.HasTag(lvm.SnapshotTag) || lv.HasTag(raidIntegrityTag) || lv.NeedsRaidRepair() {
+		return false
+	}
+	if lv.Attr.IsActive() {
+		// e.g. still synchronizing after being created or repaired
+		if lv.RaidSyncAction != "idle" {
+			return false
+		}
+	} else if len(publishedNodes(lv)) > 0 {
+		return false
+	}
+	last := lv.CreationTime
+	if scrubbed, ok := lastScrubFromTags(lv); ok {
+		last = scrubbed
+	}
+	return now.Sub(last) >= d.raidScrubInterval
+}
+
+// startRaidScrub starts a check of a RAID volume, activating it on this node if it isn't active anywhere. It stays
+// active until finishRaidScrub sees the check finish, or abortRaidScrub is called to publish it.
+func (d *Driver) startRaidScrub(lv *lvm.LogicalVolume) {
+	tags := []string{scrubbingTag}
+	activated := false
+	if !lv.Attr.IsActive() {
+		klog.InfoS("Activating LV", "vg", lv.VG, "lv", lv.Name)
+		if err := d.lvm.ActivateLV(lv.VG, lv.Name); err != nil {
+			klog.ErrorS(err, "Failed to activate LV", "vg", lv.VG, "lv", lv.Name)
+			return
+		}
+		tags = append(tags, scrubActivatedTag)
+		activated = true
+	}
+
+	klog.InfoS("Starting RAID check", "vg", lv.VG, "lv", lv.Name)
+	err := d.lvm.UpdateLVTags(lv.VG, lv.Name, tags, nil)
+	if err == nil {
+		err = d.lvm.StartRaidSyncAction(lv.VG, lv.Name, "check")
+		if err != nil {
+			if err := d.lvm.UpdateLVTags(lv.VG, lv.Name, nil, tags); err != nil {
+				klog.ErrorS(err, "Failed to update LV tags", "vg", lv.VG, "lv", lv.Name)
+			}
+		}
+	}
+	if err != nil {
+		klog.ErrorS(err, "Failed to start RAID check", "vg", lv.VG, "lv", lv.Name)
+		d.volumeEvent(lv, corev1.EventTypeWarning, "RaidScrubFailed", "Failed to start check of RAID volume %s/%s: %v", lv.VG, lv.Name, err)
+		if activated {
+			if err := d.lvm.DeactivateLV(lv.VG, lv.Name); err != nil {
+				klog.ErrorS(err, "Failed to deactivate LV", "vg", lv.VG, "lv", lv.Name)
+			}
+		}
+		return
+	}
+	d.volumeEvent(lv, corev1.EventTypeNormal, "RaidScrubStarted", "Started check of RAID volume %s/%s", lv.VG, lv.Name)
+}
+
+// finishRaidScrub reports the result of the check started by startRaidScrub once it's done, and returns false if the
+// volume isn't being checked anymore
+func (d *Driver) finishRaidScrub(lv *lvm.LogicalVolume, now time.Time) bool {
+	if lv.Attr.IsActive() && lv.RaidSyncAction == "check" {
+		return true
+	}
+	remove := []string{scrubbingTag, scrubActivatedTag}
+	if !lv.Attr.IsActive() {
+		// deactivated before the check finished, so it's checked again later
+		klog.InfoS("RAID check was interrupted", "vg", lv.VG, "lv", lv.Name)
+		if err := d.lvm.UpdateLVTags(lv.VG, lv.Name, nil, remove); err != nil {
+			klog.ErrorS(err, "Failed to update LV tags", "vg", lv.VG, "lv", lv.Name)
+		}
+		return false
+	}
+
+	if scrubbed, ok := lastScrubFromTags(lv); ok {
+		remove = append(remove, lastScrubTag(scrubbed))
+	}
+	if err := d.lvm.UpdateLVTags(lv.VG, lv.Name, []string{lastScrubTag(now)}, remove); err != nil {
+		klog.ErrorS(err, "Failed to update LV tags", "vg", lv.VG, "lv", lv.Name)
+		return true
+	}
+	if lv.HasTag(scrubActivatedTag) {
+		klog.InfoS("Deactivating LV", "vg", lv.VG, "lv", lv.Name)
+		if err := d.lvm.DeactivateLV(lv.VG, lv.Name); err != nil {
+			klog.ErrorS(err, "Failed to deactivate LV", "vg", lv.VG, "lv", lv.Name)
+		}
+	}
+
+	klog.InfoS("RAID check finished", "vg", lv.VG, "lv", lv.Name, "mismatches", lv.RaidMismatchCount)
+	if lv.RaidMismatchCount > 0 {
+		d.volumeEvent(lv, corev1.EventTypeWarning, "RaidMismatchesFound", "Check of RAID volume %s/%s found %d mismatched regions, run 'lvchange --syncaction repair %s/%s' to resynchronize them", lv.VG, lv.Name, lv.RaidMismatchCount, lv.VG, lv.Name)
+	} else {
+		d.volumeEvent(lv, corev1.EventTypeNormal, "RaidScrubCompleted", "Check of RAID volume %s/%s found no mismatches", lv.VG, lv.Name)
+	}
+	return false
+}
+
+// abortRaidScrub deactivates a RAID volume that was activated on this node by startRaidScrub, so that it can be used on
+// another node. The check is started again later.
+func (d *Driver) abortRaidScrub(lv *lvm.LogicalVolume) error {
+	klog.InfoS("Aborting RAID check", "vg", lv.VG, "lv", lv.Name)
+	if err := d.lvm.DeactivateLV(lv.VG, lv.Name); err != nil {
+		return status.Errorf(codes.Internal, "failed to deactivate lv: %v", err)
+	}
+	if err := d.lvm.UpdateLVTags(lv.VG, lv.Name, nil, []string{scrubbingTag, scrubActivatedTag}); err != nil {
+		return status.Errorf(codes.Internal, "failed to update lv tags: %v", err)
+	}
+	return nil
+}
+
+func lastScrubTag(t time.Time) string {
+	return lastScrubTagPrefix + strconv.FormatInt(t.Unix(), 10)
+}
+
+// lastScrubFromTags returns when the last check of a RAID volume finished, if it was ever checked
+func lastScrubFromTags(lv *lvm.LogicalVolume) (time.Time, bool) {
+	for _, tag := range lv.Tags {
+		if value, ok := strings.CutPrefix(tag, lastScrubTagPrefix); ok {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			return time.Unix(seconds, 0), true
+		}
+	}
+	return time.Time{}, false
+}
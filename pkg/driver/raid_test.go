@@ -0,0 +1,251 @@
+package driver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
+)
+
+func TestCheckRaidVolumes(t *testing.T) {
+	now := time.Unix(1800000000, 0)
+	created := now.Add(-48 * time.Hour)
+	sparePVs := []*lvm.PhysicalVolume{
+		{Name: "/dev/sda", VG: "test-vg"},
+		{Name: "/dev/sdc", VG: "test-vg", FreeSize: 1024, Tags: []string{"hot-spare"}},
+	}
+
+	tests := []struct {
+		name           string
+		lvs            []*lvm.LogicalVolume
+		pvs            []*lvm.PhysicalVolume
+		syncActionErr  error
+		expectedCalls  []string
+		expectedEvents []string
+	}{
+		{
+			name: "should repair degraded volume on spare pvs",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", Attr: "rwi-a-r-p-", HealthStatus: "partial", RaidSyncAction: "idle", CreationTime: now},
+			},
+			pvs:           sparePVs,
+			expectedCalls: []string{"repair test-vg/test-lv [hot-spare]"},
+			expectedEvents: []string{
+				"Normal RaidRepaired Replaced the failed images of RAID volume test-vg/test-lv on PVs tagged 'hot-spare'",
+			},
+		},
+		{
+			name: "should activate unpublished volume to repair it",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", Attr: "rwi---r-p-", HealthStatus: "partial", CreationTime: now},
+			},
+			pvs:           sparePVs,
+			expectedCalls: []string{"activate test-vg/test-lv", "repair test-vg/test-lv [hot-spare]", "deactivate test-vg/test-lv"},
+			expectedEvents: []string{
+				"Normal RaidRepaired Replaced the failed images of RAID volume test-vg/test-lv on PVs tagged 'hot-spare'",
+			},
+		},
+		{
+			name: "should not repair volume published to another node",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", Attr: "rwi---r-p-", HealthStatus: "partial", Tags: []string{publishedNodeTag("node-1")}, CreationTime: now},
+			},
+			pvs: sparePVs,
+			expectedEvents: []string{
+				"Warning RaidDegraded RAID volume test-vg/test-lv is partial and will be repaired once it's no longer published to node 'node-1'",
+			},
+		},
+		{
+			name: "should report degraded volume without spare pvs",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", Attr: "rwi-a-r-r-", HealthStatus: "refresh needed", RaidSyncAction: "idle", CreationTime: now},
+			},
+			pvs: []*lvm.PhysicalVolume{
+				{Name: "/dev/sdc", VG: "test-vg", Tags: []string{"hot-spare"}},
+			},
+			expectedEvents: []string{
+				"Warning RaidRepairFailed RAID volume test-vg/test-lv is refresh needed, but no PV tagged 'hot-spare' has free space to repair it",
+			},
+		},
+		{
+			name: "should check unpublished volume that was never checked",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", Attr: "rwi---r---", CreationTime: created},
+			},
+			expectedCalls: []string{
+				"activate test-vg/test-lv",
+				fmt.Sprintf("tags test-vg/test-lv +[%s %s] -[]", scrubbingTag, scrubActivatedTag),
+				"check test-vg/test-lv",
+			},
+			expectedEvents: []string{
+				"Normal RaidScrubStarted Started check of RAID volume test-vg/test-lv",
+			},
+		},
+		{
+			name: "should only check one volume at a time",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", Attr: "rwi-a-r---", RaidSyncAction: "idle", CreationTime: created},
+				{Name: "test-lv2", VG: "test-vg", Attr: "rwi-a-r---", RaidSyncAction: "idle", CreationTime: created},
+			},
+			expectedCalls: []string{
+				fmt.Sprintf("tags test-vg/test-lv +[%s] -[]", scrubbingTag),
+				"check test-vg/test-lv",
+			},
+			expectedEvents: []string{
+				"Normal RaidScrubStarted Started check of RAID volume test-vg/test-lv",
+			},
+		},
+		{
+			name: "should not check volume before the scrub interval passed",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", Attr: "rwi-a-r---", RaidSyncAction: "idle", CreationTime: created, Tags: []string{lastScrubTag(now.Add(-time.Hour))}},
+				{Name: "test-lv2", VG: "test-vg", Attr: "rwi-a-r---", RaidSyncAction: "idle", CreationTime: now},
+			},
+		},
+		{
+			name: "should not check volumes that are in use elsewhere, synchronizing or have integrity",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", Attr: "rwi---r---", CreationTime: created, Tags: []string{publishedNodeTag("node-1")}},
+				{Name: "test-lv2", VG: "test-vg", Attr: "rwi-a-r---", RaidSyncAction: "recover", CreationTime: created},
+				{Name: "test-lv3", VG: "test-vg", Attr: "rwi-a-r---", RaidSyncAction: "idle", CreationTime: created, Tags: []string{raidIntegrityTag}},
+			},
+		},
+		{
+			name: "should wait for running check",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", Attr: "rwi-a-r---", RaidSyncAction: "check", CreationTime: created, Tags: []string{scrubbingTag}},
+				{Name: "test-lv2", VG: "test-vg", Attr: "rwi-a-r---", RaidSyncAction: "idle", CreationTime: created},
+			},
+		},
+		{
+			name: "should report mismatches of finished check and deactivate volume",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", Attr: "rwi-a-r-m-", RaidSyncAction: "idle", RaidMismatchCount: 4, CreationTime: created, Tags: []string{scrubbingTag, scrubActivatedTag, lastScrubTag(created)}},
+			},
+			expectedCalls: []string{
+				fmt.Sprintf("tags test-vg/test-lv +[%s] -[%s %s %s]", lastScrubTag(now), scrubbingTag, scrubActivatedTag, lastScrubTag(created)),
+				"deactivate test-vg/test-lv",
+			},
+			expectedEvents: []string{
+				"Warning RaidMismatchesFound Check of RAID volume test-vg/test-lv found 4 mismatched regions, run 'lvchange --syncaction repair test-vg/test-lv' to resynchronize them",
+			},
+		},
+		{
+			name: "should forget interrupted check",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", Attr: "rwi---r---", CreationTime: created, Tags: []string{scrubbingTag, scrubActivatedTag, publishedNodeTag("node-1")}},
+			},
+			expectedCalls: []string{
+				fmt.Sprintf("tags test-vg/test-lv +[] -[%s %s]", scrubbingTag, scrubActivatedTag),
+			},
+		},
+		{
+			name: "should deactivate volume if check can't be started",
+			lvs: []*lvm.LogicalVolume{
+				{Name: "test-lv", VG: "test-vg", Attr: "rwi---r---", CreationTime: created},
+			},
+			syncActionErr: fmt.Errorf("some error"),
+			expectedCalls: []string{
+				"activate test-vg/test-lv",
+				fmt.Sprintf("tags test-vg/test-lv +[%s %s] -[]", scrubbingTag, scrubActivatedTag),
+				"check test-vg/test-lv",
+				fmt.Sprintf("tags test-vg/test-lv +[] -[%s %s]", scrubbingTag, scrubActivatedTag),
+				"deactivate test-vg/test-lv",
+			},
+			expectedEvents: []string{
+				"Warning RaidScrubFailed Failed to start check of RAID volume test-vg/test-lv: some error",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			mockLVM := &mockLVM{
+				listRaidLVs: func(vg string) ([]*lvm.LogicalVolume, error) {
+					assert.Equal(t, "test-vg", vg)
+					return tt.lvs, nil
+				},
+				listPVs: func(vg string) ([]*lvm.PhysicalVolume, error) {
+					return tt.pvs, nil
+				},
+				activateLV: func(vg, name string) error {
+					calls = append(calls, "activate "+vg+"/"+name)
+					return nil
+				},
+				deactivateLV: func(vg, name string) error {
+					calls = append(calls, "deactivate "+vg+"/"+name)
+					return nil
+				},
+				updateLVTags: func(vg, name string, add, remove []string) error {
+					calls = append(calls, fmt.Sprintf("tags %s/%s +[%s] -[%s]", vg, name, strings.Join(add, " "), strings.Join(remove, " ")))
+					return nil
+				},
+				startSyncAction: func(vg, name, action string) error {
+					calls = append(calls, action+" "+vg+"/"+name)
+					return tt.syncActionErr
+				},
+				repairRaidLV: func(vg, name string, pvTags []string) error {
+					calls = append(calls, fmt.Sprintf("repair %s/%s %v", vg, name, pvTags))
+					return nil
+				},
+			}
+			recorder := record.NewFakeRecorder(10)
+			driver := NewDriver("test-endpoint", []string{"test-vg"}, mockLVM,
+				WithEventRecorder(recorder),
+				WithRaidMaintenance(24*time.Hour, "hot-spare"),
+			)
+
+			driver.checkRaidVolumes(map[string]string{}, now)
+
+			var events []string
+			for len(recorder.Events) > 0 {
+				events = append(events, <-recorder.Events)
+			}
+			assert.Equal(t, tt.expectedCalls, calls)
+			assert.Equal(t, tt.expectedEvents, events)
+		})
+	}
+}
+
+func TestCheckRaidVolumesReportsDegradedVolumeOnce(t *testing.T) {
+	now := time.Unix(1800000000, 0)
+	lv := &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg", Attr: "rwi---r-p-", HealthStatus: "partial", Tags: []string{publishedNodeTag("node-1")}, CreationTime: now}
+	mockLVM := &mockLVM{
+		listRaidLVs: func(vg string) ([]*lvm.LogicalVolume, error) {
+			return []*lvm.LogicalVolume{lv}, nil
+		},
+	}
+	recorder := record.NewFakeRecorder(10)
+	driver := NewDriver("test-endpoint", []string{"test-vg"}, mockLVM,
+		WithEventRecorder(recorder),
+		WithRaidMaintenance(24*time.Hour, "hot-spare"),
+	)
+	degraded := map[string]string{}
+
+	driver.checkRaidVolumes(degraded, now)
+	driver.checkRaidVolumes(degraded, now.Add(time.Minute))
+	assert.Len(t, recorder.Events, 1)
+	assert.Equal(t, float64(1), testutil.ToFloat64(raidDegradedVolumes.WithLabelValues("test-vg")))
+	<-recorder.Events
+
+	// published to another node
+	lv.Tags = []string{publishedNodeTag("node-2")}
+	driver.checkRaidVolumes(degraded, now.Add(2*time.Minute))
+	assert.Equal(t, "Warning RaidDegraded RAID volume test-vg/test-lv is partial and will be repaired once it's no longer published to node 'node-2'", <-recorder.Events)
+
+	// repaired by hand, then degraded again
+	lv.Attr, lv.HealthStatus = "rwi---r---", ""
+	driver.checkRaidVolumes(degraded, now.Add(3*time.Minute))
+	assert.Empty(t, degraded)
+	assert.Equal(t, float64(0), testutil.ToFloat64(raidDegradedVolumes.WithLabelValues("test-vg")))
+	lv.Attr, lv.HealthStatus = "rwi---r-p-", "partial"
+	driver.checkRaidVolumes(degraded, now.Add(4*time.Minute))
+	assert.Len(t, recorder.Events, 1)
+}
@@ -0,0 +1,164 @@
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+
+	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
+)
+
+// RekeyVolume replaces the previous LUKS passphrase of an encrypted volume with a new one, see rotateEncryptionKey
+func (d *Driver) RekeyVolume(volumeID string, previous, passphrase []byte) error {
+	lv, err := d.getAllowedLV(volumeID)
+	if err != nil {
+		return err
+	}
+	if lv == nil || lv.HasTag(lvm.SnapshotTag) {
+		return status.Errorf(codes.NotFound, "volume '%s' not found", volumeID)
+	}
+	if !lv.HasTag(encryptedTag) {
+		return status.Errorf(codes.InvalidArgument, "volume '%s' is not encrypted", volumeID)
+	}
+	return d.rotateEncryptionKey(lv, previous, passphrase)
+}
+
+// ListEncryptedVolumes returns the sorted ids of all encrypted volumes in the allowed volume groups, leaving out the
+// ones in the trash
+func (d *Driver) ListEncryptedVolumes() ([]string, error) {
+	var ids []string
+	for _, vg := range d.volumeGroupsToList() {
+		lvs, err := d.lvm.ListOwnedLVs(vg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list lvs: %v", err)
+		}
+		for _, lv := range lvs {
+			if lv.HasTag(encryptedTag) && !lv.HasTag(lvm.SnapshotTag) && !isTrashed(lv) && d.isVolumeGroupAllowed(lv.VG) {
+				ids = append(ids, fmt.Sprintf("%s/%s", lv.VG, lv.Name))
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// rotateEncryptionKey adds a key slot for the new passphrase of an encrypted volume, checks that it opens the volume
+// and then removes the key slot of the previous passphrase. Only the LUKS header is changed, so the mapping of a
+// volume that is staged on a node keeps working. Every step is skipped if it was already done, so a failed rotation
+// can simply be retried. The LUKS header of a volume with a standalone integrity layer is on top of it, so the layer
+// is opened on this node for the rotation.
+func (d *Driver) rotateEncryptionKey(lv *lvm.LogicalVolume, previous, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return status.Errorf(codes.InvalidArgument, "a new passphrase is required to rotate the key of lv '%s'", lv.Name)
+	}
+	if bytes.Equal(previous, passphrase) {
+		return status.Errorf(codes.InvalidArgument, "the new passphrase of lv '%s' must differ from the previous one", lv.Name)
+	}
+	if err := checkEncryptionKeyRotation(lv); err != nil {
+		return err
+	}
+
+	// the header has to be reachable from this node, which doesn't interfere with the node the volume is staged on
+	if !lv.Attr.IsActive() {
+		klog.InfoS("Activating LV", "vg", lv.VG, "lv", lv.Name)
+		if err := d.lvm.ActivateLV(lv.VG, lv.Name); err != nil {
+			return status.Errorf(codes.Internal, "failed to activate lv: %v", err)
+		}
+		defer func() {
+			if err := d.lvm.DeactivateLV(lv.VG, lv.Name); err != nil {
+				klog.ErrorS(err, "Failed to deactivate LV", "vg", lv.VG, "lv", lv.Name)
+			}
+		}()
+	}
+
+	devicePath := getDevicePath(lv.VG, lv.Name)
+	if lv.HasTag(integrityTag) {
+		name := integrityMapperName(lv.VG, lv.Name)
+		open, err := d.integrity.IsOpen(name)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to check integrity mapping: %v", err)
+		}
+		if !open {
+			klog.InfoS("Opening integrity volume", "devicePath", devicePath, "name", name)
+			if err := d.integrity.Open(devicePath, name, false); err != nil {
+				return status.Errorf(codes.Internal, "failed to open integrity volume: %v", err)
+			}
+			defer func() {
+				if err := d.integrity.Close(name); err != nil {
+					klog.ErrorS(err, "Failed to close integrity volume", "name", name)
+				}
+			}()
+		}
+		devicePath = mapperDevicePath(name)
+	}
+
+	opens, err := d.encryptor.TestPassphrase(devicePath, passphrase)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to test luks passphrase: %v", err)
+	}
+	previousOpens := false
+	if len(previous) > 0 {
+		previousOpens, err = d.encryptor.TestPassphrase(devicePath, previous)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to test luks passphrase: %v", err)
+		}
+	}
+
+	if !opens {
+		if !previousOpens {
+			return status.Errorf(codes.FailedPrecondition, "neither the new nor the previous passphrase opens lv '%s'", lv.Name)
+		}
+		klog.InfoS("Adding LUKS key", "vg", lv.VG, "lv", lv.Name)
+		if err := d.encryptor.AddKey(devicePath, previous, passphrase); err != nil {
+			return status.Errorf(codes.Internal, "failed to add luks key: %v", err)
+		}
+		opens, err = d.encryptor.TestPassphrase(devicePath, passphrase)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to test luks passphrase: %v", err)
+		}
+		if !opens {
+			return status.Errorf(codes.Internal, "the new passphrase does not open lv '%s' after adding it", lv.Name)
+		}
+	}
+
+	if previousOpens {
+		klog.InfoS("Removing previous LUKS key", "vg", lv.VG, "lv", lv.Name)
+		if err := d.encryptor.RemoveKey(devicePath, previous); err != nil {
+			return status.Errorf(codes.Internal, "failed to remove luks key: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkEncryptionKeyRotation fails if the LUKS header of an encrypted volume can't be reached from this node without
+// interfering with the node it's published to. Activating a plain linear or striped LV only maps its extents, but the
+// dm targets of RAID, cache, writecache, VDO, thin and snapshot origin LVs keep state that would diverge from the one
+// on the other node. The same goes for the journal of a standalone integrity layer.
+func checkEncryptionKeyRotation(lv *lvm.LogicalVolume) error {
+	nodes := publishedNodes(lv)
+	if len(nodes) > 0 && lv.HasTag(integrityTag) {
+		return status.Errorf(codes.FailedPrecondition, "lv '%s' is published to node '%s' and its integrity layer can't be opened on this node to rotate its key, unpublish it first", lv.Name, nodes[0])
+	}
+	if lv.Attr.IsActive() || lv.Attr.IsLinear() {
+		return nil
+	}
+	if len(nodes) > 0 {
+		return status.Errorf(codes.FailedPrecondition, "lv '%s' is published to node '%s' and can't be activated on this node to rotate its key, unpublish it first", lv.Name, nodes[0])
+	}
+	return nil
+}
+
+// encryptionKeyIDFromTags returns the id of the passphrase an encrypted volume was last rotated to, if any
+func encryptionKeyIDFromTags(lv *lvm.LogicalVolume) string {
+	for _, tag := range lv.Tags {
+		if value, ok := strings.CutPrefix(tag, encryptionKeyIDTagPrefix); ok {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,278 @@
+package driver
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
+)
+
+// fakeKeySlots returns an encryptor whose LUKS header at devicePath holds the given passphrases
+func fakeKeySlots(t *testing.T, devicePath string, keys *[]string) *mockEncryptor {
+	return &mockEncryptor{
+		testPassphrase: func(path string, passphrase []byte) (bool, error) {
+			assert.Equal(t, devicePath, path)
+			return slices.Contains(*keys, string(passphrase)), nil
+		},
+		addKey: func(devicePath string, passphrase, newPassphrase []byte) error {
+			assert.Contains(t, *keys, string(passphrase))
+			*keys = append(*keys, string(newPassphrase))
+			return nil
+		},
+		removeKey: func(devicePath string, passphrase []byte) error {
+			*keys = slices.DeleteFunc(*keys, func(key string) bool { return key == string(passphrase) })
+			return nil
+		},
+	}
+}
+
+func TestRotateEncryptionKey(t *testing.T) {
+	tests := []struct {
+		name         string
+		attr         lvm.Attr
+		tags         []string
+		keys         []string
+		previous     string
+		passphrase   string
+		expectedKeys []string
+		expectedErr  codes.Code
+	}{
+		{
+			name:         "should replace previous passphrase",
+			attr:         "-wi-a-----",
+			keys:         []string{"old"},
+			previous:     "old",
+			passphrase:   "new",
+			expectedKeys: []string{"new"},
+			expectedErr:  codes.OK,
+		},
+		{
+			name:         "should activate inactive volume",
+			attr:         "-wi-------",
+			keys:         []string{"old"},
+			previous:     "old",
+			passphrase:   "new",
+			expectedKeys: []string{"new"},
+			expectedErr:  codes.OK,
+		},
+		{
+			name:         "should activate published linear volume",
+			attr:         "-wi-------",
+			tags:         []string{publishedNodeTag("node-1")},
+			keys:         []string{"old"},
+			previous:     "old",
+			passphrase:   "new",
+			expectedKeys: []string{"new"},
+			expectedErr:  codes.OK,
+		},
+		{
+			name:         "should fail if raid volume is published",
+			attr:         "rwi---r---",
+			tags:         []string{publishedNodeTag("node-1")},
+			keys:         []string{"old"},
+			previous:     "old",
+			passphrase:   "new",
+			expectedKeys: []string{"old"},
+			expectedErr:  codes.FailedPrecondition,
+		},
+		{
+			name:         "should fail if cached volume is published",
+			attr:         "Cwi---C---",
+			tags:         []string{publishedNodeTag("node-1")},
+			keys:         []string{"old"},
+			previous:     "old",
+			passphrase:   "new",
+			expectedKeys: []string{"old"},
+			expectedErr:  codes.FailedPrecondition,
+		},
+		{
+			name:         "should activate unpublished raid volume",
+			attr:         "rwi---r---",
+			keys:         []string{"old"},
+			previous:     "old",
+			passphrase:   "new",
+			expectedKeys: []string{"new"},
+			expectedErr:  codes.OK,
+		},
+		{
+			name:         "should only remove previous passphrase if new one was already added",
+			attr:         "-wi-a-----",
+			keys:         []string{"old", "new"},
+			previous:     "old",
+			passphrase:   "new",
+			expectedKeys: []string{"new"},
+			expectedErr:  codes.OK,
+		},
+		{
+			name:         "should do nothing if already rotated",
+			attr:         "-wi-a-----",
+			keys:         []string{"new"},
+			previous:     "old",
+			passphrase:   "new",
+			expectedKeys: []string{"new"},
+			expectedErr:  codes.OK,
+		},
+		{
+			name:         "should keep other key slots",
+			attr:         "-wi-a-----",
+			keys:         []string{"recovery", "old"},
+			previous:     "old",
+			passphrase:   "new",
+			expectedKeys: []string{"recovery", "new"},
+			expectedErr:  codes.OK,
+		},
+		{
+			name:         "should fail if no passphrase opens the volume",
+			attr:         "-wi-a-----",
+			keys:         []string{"other"},
+			previous:     "old",
+			passphrase:   "new",
+			expectedKeys: []string{"other"},
+			expectedErr:  codes.FailedPrecondition,
+		},
+		{
+			name:         "should fail without previous passphrase if new one doesn't open the volume",
+			attr:         "-wi-a-----",
+			keys:         []string{"old"},
+			passphrase:   "new",
+			expectedKeys: []string{"old"},
+			expectedErr:  codes.FailedPrecondition,
+		},
+		{
+			name:         "should fail if passphrases are equal",
+			attr:         "-wi-a-----",
+			keys:         []string{"old"},
+			previous:     "old",
+			passphrase:   "old",
+			expectedKeys: []string{"old"},
+			expectedErr:  codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := slices.Clone(tt.keys)
+			active := tt.attr.IsActive()
+			driver := NewDriver("test-endpoint", nil, &mockLVM{
+				activateLV: func(vg, name string) error {
+					assert.False(t, active, "active lv should not be activated again")
+					active = true
+					return nil
+				},
+				deactivateLV: func(vg, name string) error {
+					active = false
+					return nil
+				},
+			})
+			driver.encryptor = fakeKeySlots(t, "/dev/test-vg/test-lv", &keys)
+
+			lv := &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg", Attr: tt.attr, Tags: append([]string{lvm.OwnershipTag, encryptedTag}, tt.tags...)}
+			err := driver.rotateEncryptionKey(lv, []byte(tt.previous), []byte(tt.passphrase))
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+			assert.Equal(t, tt.expectedKeys, keys)
+			assert.Equal(t, tt.attr.IsActive(), active, "lv should be left in its activation state")
+		})
+	}
+}
+
+func TestRotateEncryptionKeyWithIntegrity(t *testing.T) {
+	tests := []struct {
+		name           string
+		tags           []string
+		isOpen         bool
+		expectedKeys   []string
+		expectedOpened bool
+		expectedErr    codes.Code
+	}{
+		{
+			name:           "should open integrity layer to reach the luks header",
+			expectedKeys:   []string{"new"},
+			expectedOpened: true,
+			expectedErr:    codes.OK,
+		},
+		{
+			name:         "should use integrity mapping that is already open",
+			isOpen:       true,
+			expectedKeys: []string{"new"},
+			expectedErr:  codes.OK,
+		},
+		{
+			name:         "should fail if volume is published",
+			tags:         []string{publishedNodeTag("node-1")},
+			expectedKeys: []string{"old"},
+			expectedErr:  codes.FailedPrecondition,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := []string{"old"}
+			open := tt.isOpen
+			opened := false
+			driver := NewDriver("test-endpoint", nil, &mockLVM{})
+			driver.encryptor = fakeKeySlots(t, "/dev/mapper/test--vg-test--lv-integrity", &keys)
+			driver.integrity = &mockIntegrityLayer{
+				isOpen: func(name string) (bool, error) {
+					assert.Equal(t, "test--vg-test--lv-integrity", name)
+					return open, nil
+				},
+				open: func(devicePath, name string, recalculate bool) error {
+					assert.Equal(t, "/dev/test-vg/test-lv", devicePath)
+					assert.False(t, recalculate)
+					open = true
+					opened = true
+					return nil
+				},
+				close: func(name string) error {
+					open = false
+					return nil
+				},
+			}
+
+			lv := &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg", Attr: "-wi-a-----", Tags: append([]string{lvm.OwnershipTag, encryptedTag, integrityTag}, tt.tags...)}
+			err := driver.rotateEncryptionKey(lv, []byte("old"), []byte("new"))
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+			assert.Equal(t, tt.expectedKeys, keys)
+			assert.Equal(t, tt.expectedOpened, opened)
+			assert.Equal(t, tt.isOpen, open, "integrity mapping should be left in its state")
+		})
+	}
+}
+
+func TestListEncryptedVolumes(t *testing.T) {
+	mockLVM := &mockLVM{
+		listOwnedLVs: func(vg string) ([]*lvm.LogicalVolume, error) {
+			return []*lvm.LogicalVolume{
+				{Name: "lv-b", VG: vg, Tags: []string{lvm.OwnershipTag, encryptedTag}},
+				{Name: "plain", VG: vg, Tags: []string{lvm.OwnershipTag}},
+				{Name: "lv-a", VG: vg, Tags: []string{lvm.OwnershipTag, encryptedTag}},
+				{Name: "snap", VG: vg, Tags: []string{lvm.OwnershipTag, lvm.SnapshotTag, encryptedTag}},
+				{Name: "trash-lv-c", VG: vg, Tags: []string{lvm.OwnershipTag, encryptedTag, trashedTag(time.Unix(1800000000, 0))}},
+			}, nil
+		},
+	}
+	driver := NewDriver("test-endpoint", []string{"test-vg"}, mockLVM)
+
+	ids, err := driver.ListEncryptedVolumes()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"test-vg/lv-a", "test-vg/lv-b"}, ids)
+}
@@ -0,0 +1,207 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+
+	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
+)
+
+const (
+	// trashPrefix is prepended to the names of trashed LVs, which frees their names and keeps them apart from volumes
+	trashPrefix = "trash-"
+	// trashedTagPrefix keeps the unix time at which a volume was moved to the trash
+	trashedTagPrefix = lvm.OwnershipTag + "/trashed="
+	// trashedNameTagPrefix keeps the name a volume had before it was moved to the trash
+	trashedNameTagPrefix = lvm.OwnershipTag + "/trashed-name="
+)
+
+// TrashedVolume is a volume that was deleted while the trash was enabled, see WithTrash
+type TrashedVolume struct {
+	// VolumeID is the id of the volume in the trash, which differs from the one it had before
+	VolumeID string
+	// Name is the name of the LV before it was trashed, usually the name of its former PersistentVolume
+	Name      string
+	Size      int64
+	DeletedAt time.Time
+}
+
+// trashVolume moves a volume to the trash: it's deactivated, tagged with the time of its deletion and its name, and
+// renamed, so that neither the CO nor a new volume with the same name see it anymore. The tags are added first, so that
+// a volume whose rename failed is still purged once its TTL passed.
+func (d *Driver) trashVolume(lv *lvm.LogicalVolume, now time.Time) error {
+	if lv.Attr.IsActive() {
+		klog.InfoS("Deactivating LV", "vg", lv.VG, "lv", lv.Name)
+		if err := d.lvm.DeactivateLV(lv.VG, lv.Name); err != nil {
+			return status.Errorf(codes.Internal, "failed to deactivate lv: %v", err)
+		}
+	}
+	// a retry keeps the time of the first attempt
+	if !isTrashed(lv) {
+		if err := d.lvm.UpdateLVTags(lv.VG, lv.Name, []string{trashedTag(now), trashedNameTagPrefix + lv.Name}, nil); err != nil {
+			return status.Errorf(codes.Internal, "failed to tag lv: %v", err)
+		}
+	}
+	trashName := trashPrefix + lv.Name
+	if err := d.lvm.RenameLV(lv.VG, lv.Name, trashName); err != nil {
+		return status.Errorf(codes.Internal, "failed to rename lv: %v", err)
+	}
+	klog.InfoS("LV moved to trash", "vg", lv.VG, "lv", lv.Name, "trashedLV", trashName)
+	return nil
+}
+
+// RunTrashPurger periodically removes the volumes whose TTL in the trash passed until ctx is cancelled
+func (d *Driver) RunTrashPurger(ctx context.Context, interval time.Duration) {
+	klog.InfoS("Starting trash purger", "interval", interval, "ttl", d.trashTTL)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		d.purgeTrash(time.Now())
+	}, interval)
+}
+
+func (d *Driver) purgeTrash(now time.Time) {
+	for _, vgName := range d.volumeGroupsToList() {
+		lvs, err := d.lvm.ListOwnedLVs(vgName)
+		if err != nil {
+			klog.ErrorS(err, "Failed to list LVs", "vg", vgName)
+			continue
+		}
+		for _, lv := range lvs {
+			deletedAt, ok := trashedAt(lv)
+			if !ok || now.Sub(deletedAt) < d.trashTTL {
+				continue
+			}
+			id := fmt.Sprintf("%s/%s", lv.VG, lv.Name)
+			klog.InfoS("Purging trashed volume", "vg", lv.VG, "lv", lv.Name, "deletedAt", deletedAt)
+			if err := d.removeVolume(lv.VG, lv.Name, lv); err != nil {
+				klog.ErrorS(err, "Failed to purge trashed volume", "vg", lv.VG, "lv", lv.Name)
+				d.event(corev1.EventTypeWarning, "TrashPurgeFailed", "Failed to purge volume %s from the trash: %v", id, err)
+				continue
+			}
+			d.event(corev1.EventTypeNormal, "TrashedVolumePurged", "Purged volume %s, which was deleted at %s", id, deletedAt.UTC().Format(time.RFC3339))
+		}
+	}
+}
+
+// ListTrashedVolumes returns the volumes in the trash of the allowed volume groups, sorted by their ids
+func (d *Driver) ListTrashedVolumes() ([]*TrashedVolume, error) {
+	var volumes []*TrashedVolume
+	for _, vg := range d.volumeGroupsToList() {
+		lvs, err := d.lvm.ListOwnedLVs(vg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list lvs: %v", err)
+		}
+		for _, lv := range lvs {
+			deletedAt, ok := trashedAt(lv)
+			if !ok || lv.HasTag(lvm.SnapshotTag) || !d.isVolumeGroupAllowed(lv.VG) {
+				continue
+			}
+			volumes = append(volumes, &TrashedVolume{
+				VolumeID:  fmt.Sprintf("%s/%s", lv.VG, lv.Name),
+				Name:      trashedName(lv),
+				Size:      lv.Size,
+				DeletedAt: deletedAt,
+			})
+		}
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].VolumeID < volumes[j].VolumeID
+	})
+	return volumes, nil
+}
+
+// RestoreVolume takes a volume out of the trash under the given name, or the name it had before if empty, and returns
+// its new id. The trash tag is removed before the rename, so that a volume whose rename failed is never purged.
+func (d *Driver) RestoreVolume(volumeID, name string) (string, error) {
+	lv, err := d.getTrashedLV(volumeID)
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = trashedName(lv)
+	}
+	existing, err := d.lvm.GetLV(lv.VG, name)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to get lv: %v", err)
+	}
+	if existing != nil {
+		return "", status.Errorf(codes.AlreadyExists, "lv '%s/%s' already exists", lv.VG, name)
+	}
+
+	var remove []string
+	for _, tag := range lv.Tags {
+		if strings.HasPrefix(tag, trashedTagPrefix) || strings.HasPrefix(tag, trashedNameTagPrefix) {
+			remove = append(remove, tag)
+		}
+	}
+	if err := d.lvm.UpdateLVTags(lv.VG, lv.Name, nil, remove); err != nil {
+		return "", status.Errorf(codes.Internal, "failed to untag lv: %v", err)
+	}
+	if err := d.lvm.RenameLV(lv.VG, lv.Name, name); err != nil {
+		return "", status.Errorf(codes.Internal, "failed to rename lv: %v", err)
+	}
+	return fmt.Sprintf("%s/%s", lv.VG, name), nil
+}
+
+// PurgeTrashedVolume removes a volume from the trash before its TTL passed
+func (d *Driver) PurgeTrashedVolume(volumeID string) error {
+	lv, err := d.getTrashedLV(volumeID)
+	if err != nil {
+		return err
+	}
+	return d.removeVolume(lv.VG, lv.Name, lv)
+}
+
+// getTrashedLV returns the LV of a volume in the trash of an allowed volume group
+func (d *Driver) getTrashedLV(volumeID string) (*lvm.LogicalVolume, error) {
+	lv, err := d.getAllowedLV(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if lv == nil || lv.HasTag(lvm.SnapshotTag) || !isTrashed(lv) {
+		return nil, status.Errorf(codes.NotFound, "volume '%s' is not in the trash", volumeID)
+	}
+	return lv, nil
+}
+
+func trashedTag(t time.Time) string {
+	return trashedTagPrefix + strconv.FormatInt(t.Unix(), 10)
+}
+
+// trashedAt returns when a volume was moved to the trash, if it was
+func trashedAt(lv *lvm.LogicalVolume) (time.Time, bool) {
+	for _, tag := range lv.Tags {
+		if value, ok := strings.CutPrefix(tag, trashedTagPrefix); ok {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			return time.Unix(seconds, 0), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// trashedName returns the name a trashed volume had before it was moved to the trash
+func trashedName(lv *lvm.LogicalVolume) string {
+	for _, tag := range lv.Tags {
+		if value, ok := strings.CutPrefix(tag, trashedNameTagPrefix); ok {
+			return value
+		}
+	}
+	return strings.TrimPrefix(lv.Name, trashPrefix)
+}
+
+func isTrashed(lv *lvm.LogicalVolume) bool {
+	_, ok := trashedAt(lv)
+	return ok
+}
@@ -0,0 +1,226 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/cienijr/csi-shared-lvm/pkg/lvm"
+)
+
+// recordingLVM returns a mock that records the changes to the given LVs, which it looks up by name
+func recordingLVM(lvs []*lvm.LogicalVolume, calls *[]string) *mockLVM {
+	return &mockLVM{
+		getLV: func(vg, name string) (*lvm.LogicalVolume, error) {
+			for _, lv := range lvs {
+				if lv.VG == vg && lv.Name == name {
+					return lv, nil
+				}
+			}
+			return nil, nil
+		},
+		listOwnedLVs: func(vg string) ([]*lvm.LogicalVolume, error) {
+			return lvs, nil
+		},
+		deactivateLV: func(vg, name string) error {
+			*calls = append(*calls, "deactivate "+vg+"/"+name)
+			return nil
+		},
+		updateLVTags: func(vg, name string, add, remove []string) error {
+			*calls = append(*calls, fmt.Sprintf("tags %s/%s +[%s] -[%s]", vg, name, strings.Join(add, " "), strings.Join(remove, " ")))
+			return nil
+		},
+		renameLV: func(vg, name, newName string) error {
+			*calls = append(*calls, "rename "+vg+"/"+name+" "+newName)
+			return nil
+		},
+		deleteLV: func(vg, name string) error {
+			*calls = append(*calls, "delete "+vg+"/"+name)
+			return nil
+		},
+	}
+}
+
+func TestDeleteVolumeTrash(t *testing.T) {
+	deletedAt := time.Unix(1800000000, 0)
+
+	tests := []struct {
+		name          string
+		lv            *lvm.LogicalVolume
+		expectedCalls []string
+		expectedTags  string
+	}{
+		{
+			name: "should move volume to trash",
+			lv:   &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg", Attr: "-wi-------"},
+			expectedCalls: []string{
+				"tags test-vg/test-lv +[" + trashedTagPrefix,
+				"rename test-vg/test-lv trash-test-lv",
+			},
+			expectedTags: " " + trashedNameTagPrefix + "test-lv] -[]",
+		},
+		{
+			name: "should deactivate active volume",
+			lv:   &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg", Attr: "-wi-a-----"},
+			expectedCalls: []string{
+				"deactivate test-vg/test-lv",
+				"tags test-vg/test-lv +[" + trashedTagPrefix,
+				"rename test-vg/test-lv trash-test-lv",
+			},
+		},
+		{
+			name: "should keep deletion time of failed rename",
+			lv:   &lvm.LogicalVolume{Name: "test-lv", VG: "test-vg", Attr: "-wi-------", Tags: []string{trashedTag(deletedAt)}},
+			expectedCalls: []string{
+				"rename test-vg/test-lv trash-test-lv",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			driver := NewDriver("test-endpoint", nil, recordingLVM([]*lvm.LogicalVolume{tt.lv}, &calls), WithTrash(time.Hour))
+
+			_, err := driver.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "test-vg/test-lv"})
+			assert.NoError(t, err)
+			// the deletion time is only known roughly
+			assert.Len(t, calls, len(tt.expectedCalls))
+			for i, call := range calls {
+				assert.True(t, strings.HasPrefix(call, tt.expectedCalls[i]), "unexpected call %q", call)
+				if strings.HasPrefix(call, "tags ") && tt.expectedTags != "" {
+					assert.True(t, strings.HasSuffix(call, tt.expectedTags), "unexpected tags %q", call)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteVolumeTrashWithThinSnapshot(t *testing.T) {
+	var calls []string
+	mockLVM := recordingLVM([]*lvm.LogicalVolume{{Name: "test-lv", VG: "test-vg", Attr: "Vwi---tz--", Pool: "test-pool"}}, &calls)
+	mockLVM.listSnapshots = func(vg string) ([]*lvm.LogicalVolume, error) {
+		return []*lvm.LogicalVolume{{Name: "snap-1", VG: vg, Attr: "Vwi---tz-k", Pool: "test-pool", Origin: "test-lv"}}, nil
+	}
+	driver := NewDriver("test-endpoint", nil, mockLVM, WithTrash(time.Hour))
+
+	_, err := driver.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "test-vg/test-lv"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Empty(t, calls)
+}
+
+func TestPurgeTrash(t *testing.T) {
+	now := time.Unix(1800000000, 0)
+	lvs := []*lvm.LogicalVolume{
+		{Name: "trash-old", VG: "test-vg", Attr: "-wi-------", Tags: []string{trashedTag(now.Add(-2 * time.Hour)), trashedNameTagPrefix + "old"}},
+		{Name: "trash-new", VG: "test-vg", Attr: "-wi-------", Tags: []string{trashedTag(now.Add(-time.Minute)), trashedNameTagPrefix + "new"}},
+		{Name: "test-lv", VG: "test-vg", Attr: "-wi-------"},
+		{Name: "old_cache", VG: "test-vg", Attr: "Cwi---C---"},
+	}
+	var calls []string
+	recorder := record.NewFakeRecorder(10)
+	driver := NewDriver("test-endpoint", []string{"test-vg"}, recordingLVM(lvs, &calls),
+		WithEventRecorder(recorder),
+		WithTrash(time.Hour),
+	)
+
+	driver.purgeTrash(now)
+
+	assert.Equal(t, []string{"delete test-vg/old_cache", "delete test-vg/trash-old"}, calls)
+	assert.Equal(t, "Normal TrashedVolumePurged Purged volume test-vg/trash-old, which was deleted at 2027-01-15T06:00:00Z", <-recorder.Events)
+}
+
+func TestListTrashedVolumes(t *testing.T) {
+	deletedAt := time.Unix(1800000000, 0)
+	lvs := []*lvm.LogicalVolume{
+		{Name: "trash-lv-b", VG: "test-vg", Size: 2048, Tags: []string{trashedTag(deletedAt), trashedNameTagPrefix + "lv-b"}},
+		{Name: "test-lv", VG: "test-vg", Size: 1024},
+		{Name: "trash-trash-lv-a", VG: "test-vg", Size: 1024, Tags: []string{trashedTag(deletedAt), trashedNameTagPrefix + "trash-lv-a"}},
+	}
+	driver := NewDriver("test-endpoint", []string{"test-vg"}, recordingLVM(lvs, nil))
+
+	volumes, err := driver.ListTrashedVolumes()
+	assert.NoError(t, err)
+	assert.Equal(t, []*TrashedVolume{
+		{VolumeID: "test-vg/trash-lv-b", Name: "lv-b", Size: 2048, DeletedAt: deletedAt},
+		{VolumeID: "test-vg/trash-trash-lv-a", Name: "trash-lv-a", Size: 1024, DeletedAt: deletedAt},
+	}, volumes)
+}
+
+func TestRestoreVolume(t *testing.T) {
+	tag := trashedTag(time.Unix(1800000000, 0))
+	nameTag := trashedNameTagPrefix + "test-lv"
+
+	tests := []struct {
+		name             string
+		volumeID         string
+		newName          string
+		expectedVolumeID string
+		expectedCalls    []string
+		expectedErr      codes.Code
+	}{
+		{
+			name:             "should restore volume under its previous name",
+			volumeID:         "test-vg/trash-test-lv",
+			expectedVolumeID: "test-vg/test-lv",
+			expectedCalls: []string{
+				"tags test-vg/trash-test-lv +[] -[" + tag + " " + nameTag + "]",
+				"rename test-vg/trash-test-lv test-lv",
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name:             "should restore volume under new name",
+			volumeID:         "test-vg/trash-test-lv",
+			newName:          "restored-lv",
+			expectedVolumeID: "test-vg/restored-lv",
+			expectedCalls: []string{
+				"tags test-vg/trash-test-lv +[] -[" + tag + " " + nameTag + "]",
+				"rename test-vg/trash-test-lv restored-lv",
+			},
+			expectedErr: codes.OK,
+		},
+		{
+			name:        "should fail if name is taken",
+			volumeID:    "test-vg/trash-test-lv",
+			newName:     "other-lv",
+			expectedErr: codes.AlreadyExists,
+		},
+		{
+			name:        "should fail if volume is not in the trash",
+			volumeID:    "test-vg/other-lv",
+			expectedErr: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lvs := []*lvm.LogicalVolume{
+				{Name: "trash-test-lv", VG: "test-vg", Tags: []string{lvm.OwnershipTag, tag, nameTag}},
+				{Name: "other-lv", VG: "test-vg", Tags: []string{lvm.OwnershipTag}},
+			}
+			var calls []string
+			driver := NewDriver("test-endpoint", nil, recordingLVM(lvs, &calls))
+
+			volumeID, err := driver.RestoreVolume(tt.volumeID, tt.newName)
+			if tt.expectedErr == codes.OK {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				st, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.expectedErr, st.Code())
+			}
+			assert.Equal(t, tt.expectedVolumeID, volumeID)
+			assert.Equal(t, tt.expectedCalls, calls)
+		})
+	}
+}
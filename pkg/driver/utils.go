@@ -2,12 +2,19 @@ package driver
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/container-storage-interface/spec/lib/go/csi"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// topologyKeyPrefix prefixes the topology key of each volume group. Nodes report one key for every VG they can see,
+// so that volumes are only used on nodes that have access to the shared device backing their VG.
+const topologyKeyPrefix = "vg." + DriverName + "/"
+
 func getVGAndLVNames(volumeID string) (string, string, error) {
 	parts := strings.Split(volumeID, "/")
 	if len(parts) != 2 {
@@ -16,10 +23,125 @@ func getVGAndLVNames(volumeID string) (string, string, error) {
 	return parts[0], parts[1], nil
 }
 
-func getDevicePath(volumeID string) (string, error) {
-	vgName, lvName, err := getVGAndLVNames(volumeID)
-	if err != nil {
-		return "", err
+func getDevicePath(vgName, lvName string) string {
+	return fmt.Sprintf("/dev/%s/%s", vgName, lvName)
+}
+
+// luksMapperName returns the device-mapper name of the LUKS mapping of a volume
+func luksMapperName(vgName, lvName string) string {
+	return mapperName(vgName, lvName, "crypt")
+}
+
+// integrityMapperName returns the device-mapper name of the standalone dm-integrity mapping of a volume
+func integrityMapperName(vgName, lvName string) string {
+	return mapperName(vgName, lvName, "integrity")
+}
+
+// mapperName returns the name of a device-mapper device stacked on a volume. Dashes are escaped like LVM does for its
+// own mappings, and the suffix keeps the name from clashing with the mapping of the LV itself.
+func mapperName(vgName, lvName, suffix string) string {
+	escape := func(s string) string { return strings.ReplaceAll(s, "-", "--") }
+	return escape(vgName) + "-" + escape(lvName) + "-" + suffix
+}
+
+func mapperDevicePath(name string) string {
+	return "/dev/mapper/" + name
+}
+
+// isVolumeGroupAllowed returns true if the driver is allowed to manage the given volume group
+func (d *Driver) isVolumeGroupAllowed(vgName string) bool {
+	if len(d.allowedVolumeGroups) == 0 {
+		return true
+	}
+	for _, vg := range d.allowedVolumeGroups {
+		if vg == vgName {
+			return true
+		}
+	}
+	return false
+}
+
+// volumeGroupsToList returns the volume groups that should be scanned when listing resources.
+// An empty name means all volume groups visible to the host.
+func (d *Driver) volumeGroupsToList() []string {
+	if len(d.allowedVolumeGroups) > 0 {
+		return d.allowedVolumeGroups
+	}
+	return []string{""}
+}
+
+// paginate returns the [start, end) range of ids that should be returned for the given token, along with the
+// token for the next page. ids must be sorted. Tokens are ids themselves, so that pages stay stable if entries are
+// created between calls. A token whose entry no longer exists is rejected with Aborted, as the CSI spec requires.
+func paginate(ids []string, startingToken string, maxEntries int32) (int, int, string, error) {
+	start := 0
+	if startingToken != "" {
+		var found bool
+		start, found = sort.Find(len(ids), func(i int) int { return strings.Compare(startingToken, ids[i]) })
+		if !found {
+			return 0, 0, "", status.Errorf(codes.Aborted, "invalid starting token '%s'", startingToken)
+		}
+	}
+
+	end := len(ids)
+	if maxEntries > 0 && start+int(maxEntries) < end {
+		end = start + int(maxEntries)
+	}
+
+	nextToken := ""
+	if end < len(ids) {
+		nextToken = ids[end]
+	}
+	return start, end, nextToken, nil
+}
+
+// volumeGroupTopologyKey returns the topology key of a volume group, or an empty string if its name can't be used as
+// a node label, in which case the VG is not constrained to any topology
+func volumeGroupTopologyKey(vgName string) string {
+	key := topologyKeyPrefix + vgName
+	if len(validation.IsQualifiedName(key)) > 0 {
+		return ""
+	}
+	return key
+}
+
+// volumeGroupTopology returns the topology that volumes in the given volume group are accessible from
+func volumeGroupTopology(vgName string) []*csi.Topology {
+	key := volumeGroupTopologyKey(vgName)
+	if key == "" {
+		return nil
+	}
+	return []*csi.Topology{
+		{
+			Segments: map[string]string{key: "true"},
+		},
+	}
+}
+
+// isVolumeGroupAccessible returns true if a volume in the given volume group satisfies the accessibility requirements,
+// i.e. if it's visible from any of the requisite topologies, or from any of the preferred ones if none is requisite
+func isVolumeGroupAccessible(vgName string, requirements *csi.TopologyRequirement) bool {
+	topologies := requirements.GetRequisite()
+	if len(topologies) == 0 {
+		topologies = requirements.GetPreferred()
+	}
+	if len(topologies) == 0 {
+		return true
+	}
+	for _, topology := range topologies {
+		if isVolumeGroupInTopology(vgName, topology) {
+			return true
+		}
+	}
+	return false
+}
+
+// isVolumeGroupInTopology returns true if the given volume group is visible from the given topology segment. A nil
+// topology matches every volume group.
+func isVolumeGroupInTopology(vgName string, topology *csi.Topology) bool {
+	key := volumeGroupTopologyKey(vgName)
+	if topology == nil || key == "" {
+		return true
 	}
-	return fmt.Sprintf("/dev/%s/%s", vgName, lvName), nil
+	return topology.GetSegments()[key] == "true"
 }
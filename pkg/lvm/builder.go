@@ -1,18 +1,112 @@
 package lvm
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
-func buildLvcreateCmd(vg, name string, size int64, tags []string) (string, []string) {
-	args := []string{"--name", name, "--wipesignatures", "y", "--yes", "--size", fmt.Sprintf("%db", size), "--setautoactivation", "n"}
+func buildLvcreateCmd(vg, name string, size int64, tags []string, opts LVOptions) (string, []string) {
+	args := []string{"--name", name, "--wipesignatures", "y", "--yes"}
+	target := vg
+	if opts.ThinPool != "" {
+		args = append(args, "--type", "thin", "--virtualsize", fmt.Sprintf("%db", size))
+		target = fmt.Sprintf("%s/%s", vg, opts.ThinPool)
+	} else if opts.Type == "vdo" {
+		args = append(args, "--type", "vdo", "--size", fmt.Sprintf("%db", opts.VDOPhysicalSize), "--virtualsize", fmt.Sprintf("%db", size))
+		args = append(args, "--compression", yesNo(opts.VDOCompression), "--deduplication", yesNo(opts.VDODeduplication))
+		target = fmt.Sprintf("%s/%s", vg, opts.VDOPool)
+	} else {
+		if opts.Type != "" {
+			args = append(args, "--type", opts.Type)
+		}
+		if opts.Mirrors > 0 {
+			args = append(args, "--mirrors", fmt.Sprintf("%d", opts.Mirrors))
+		}
+		args = append(args, stripeArgs(opts)...)
+		if opts.RaidIntegrity {
+			args = append(args, "--raidintegrity", "y")
+		}
+		args = append(args, "--size", fmt.Sprintf("%db", size))
+	}
+	allocArgs, pvs := allocationArgs(opts)
+	args = append(args, allocArgs...)
+	args = append(args, "--setautoactivation", "n")
+	for _, tag := range tags {
+		args = append(args, "--addtag", tag)
+	}
+	args = append(args, target)
+	args = append(args, pvs...)
+	return "lvcreate", args
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "y"
+	}
+	return "n"
+}
+
+// buildLvcreateSnapshotCmd builds a COW snapshot of size bytes, or a thin snapshot if size is 0
+func buildLvcreateSnapshotCmd(vg, name, origin string, size int64, tags []string) (string, []string) {
+	args := []string{"--snapshot", "--name", name, "--yes"}
+	if size > 0 {
+		args = append(args, "--size", fmt.Sprintf("%db", size))
+	} else {
+		// thin snapshots skip activation by default, which would make them unusable by lvchange -ay
+		args = append(args, "--setactivationskip", "n")
+	}
+	args = append(args, "--setautoactivation", "n")
 	for _, tag := range tags {
 		args = append(args, "--addtag", tag)
 	}
-	args = append(args, vg)
+	args = append(args, fmt.Sprintf("%s/%s", vg, origin))
 	return "lvcreate", args
 }
 
+// lvsReportArgs are shared by every lvs invocation, so that all of them can be handled by parseLvsLine.
+// Fields are separated by '|' since some of them (e.g. origin) may be empty.
+func lvsReportArgs() []string {
+	return []string{"--noheadings", "--nosuffix", "--units", "b", "--separator", "|", "--config", `report/time_format="%s"`, "-o", "lv_name,vg_name,lv_size,lv_attr,lv_tags,origin,origin_size,lv_time,pool_lv,data_percent,metadata_percent,copy_percent,raid_sync_action,integritymismatches,raid_mismatch_count,lv_health_status"}
+}
+
 func buildLvsCmd(vg, name string) (string, []string) {
-	args := []string{"--noheadings", "--nosuffix", "--units", "b", "-o", "lv_name,lv_size,lv_attr,lv_tags", fmt.Sprintf("%s/%s", vg, name)}
+	args := append(lvsReportArgs(), fmt.Sprintf("%s/%s", vg, name))
+	return "lvs", args
+}
+
+// buildLvsSelectCmd lists the LVs matching an lvs selection criteria, in a single VG or in all of them if vg is empty
+func buildLvsSelectCmd(vg, selector string) (string, []string) {
+	args := append(lvsReportArgs(), "--select", selector)
+	if vg != "" {
+		args = append(args, vg)
+	}
+	return "lvs", args
+}
+
+// buildLvsOwnedCmd lists every LV created by the driver, snapshots included
+func buildLvsOwnedCmd(vg string) (string, []string) {
+	return buildLvsSelectCmd(vg, fmt.Sprintf("lv_tags={%s}", OwnershipTag))
+}
+
+func buildLvsSnapshotsCmd(vg string) (string, []string) {
+	return buildLvsSelectCmd(vg, fmt.Sprintf("lv_tags={%s}", SnapshotTag))
+}
+
+func buildLvsThinPoolsCmd(vg string) (string, []string) {
+	return buildLvsSelectCmd(vg, "segtype=thin-pool")
+}
+
+func buildLvsThinVolumesCmd(vg, pool string) (string, []string) {
+	return buildLvsSelectCmd(vg, fmt.Sprintf("pool_lv=%s", pool))
+}
+
+// buildLvsRaidCmd lists the RAID LVs created by the driver
+func buildLvsRaidCmd(vg string) (string, []string) {
+	return buildLvsSelectCmd(vg, fmt.Sprintf("lv_tags={%s} && segtype=~^raid", OwnershipTag))
+}
+
+func buildLvsVdoPoolCmd(vg, name string) (string, []string) {
+	args := []string{"--noheadings", "--nosuffix", "--units", "b", "--separator", "|", "-o", "lv_name,vg_name,lv_size,vdo_used_size,vdo_saving_percent", fmt.Sprintf("%s/%s", vg, name)}
 	return "lvs", args
 }
 
@@ -21,11 +115,50 @@ func buildLvremoveCmd(vg, name string) (string, []string) {
 	return "lvremove", args
 }
 
-func buildLvextendCmd(vg, name string, size int64) (string, []string) {
-	args := []string{"-L", fmt.Sprintf("%db", size), fmt.Sprintf("%s/%s", vg, name)}
+func buildLvrenameCmd(vg, name, newName string) (string, []string) {
+	args := []string{vg, name, newName}
+	return "lvrename", args
+}
+
+// buildLvextendCmd extends an LV, with the stripe geometry and allocation constraints of opts if they're set
+func buildLvextendCmd(vg, name string, size int64, opts LVOptions) (string, []string) {
+	args := []string{"-L", fmt.Sprintf("%db", size)}
+	args = append(args, stripeArgs(opts)...)
+	allocArgs, pvs := allocationArgs(opts)
+	args = append(args, allocArgs...)
+	args = append(args, fmt.Sprintf("%s/%s", vg, name))
+	args = append(args, pvs...)
 	return "lvextend", args
 }
 
+// allocationArgs returns the arguments restricting where the extents of an LV are allocated, and the PV arguments
+// that must follow the LV
+func allocationArgs(opts LVOptions) (args, pvs []string) {
+	if opts.AllocPolicy != "" {
+		args = append(args, "--alloc", opts.AllocPolicy)
+	}
+	for _, tag := range opts.PVTags {
+		pvs = append(pvs, "@"+tag)
+	}
+	// RAID images are not allocated on PVs sharing a tag of the cling tag list. Stripes must not be kept apart like
+	// that, since all PVs of a tier usually share its tag.
+	if len(pvs) > 0 && strings.HasPrefix(opts.Type, "raid") {
+		args = append(args, "--config", fmt.Sprintf("allocation/cling_tag_list=[\"%s\"]", strings.Join(pvs, `","`)))
+	}
+	return args, pvs
+}
+
+func stripeArgs(opts LVOptions) []string {
+	var args []string
+	if opts.Stripes > 0 {
+		args = append(args, "--stripes", fmt.Sprintf("%d", opts.Stripes))
+	}
+	if opts.StripeSize > 0 {
+		args = append(args, "--stripesize", fmt.Sprintf("%dk", opts.StripeSize/1024))
+	}
+	return args
+}
+
 func buildLvchangeActivateCmd(vg, name string) (string, []string) {
 	args := []string{"-ay", fmt.Sprintf("%s/%s", vg, name)}
 	return "lvchange", args
@@ -36,7 +169,103 @@ func buildLvchangeDeactivateCmd(vg, name string) (string, []string) {
 	return "lvchange", args
 }
 
+func buildLvchangeTagsCmd(vg, name string, add, remove []string) (string, []string) {
+	var args []string
+	for _, tag := range add {
+		args = append(args, "--addtag", tag)
+	}
+	for _, tag := range remove {
+		args = append(args, "--deltag", tag)
+	}
+	args = append(args, fmt.Sprintf("%s/%s", vg, name))
+	return "lvchange", args
+}
+
+// buildLvchangeCmd applies the non-empty fields of changes to an LV
+func buildLvchangeCmd(vg, name string, changes LVChanges) (string, []string) {
+	var args []string
+	if changes.ReadAhead != "" {
+		args = append(args, "--readahead", changes.ReadAhead)
+	}
+	if changes.CacheMode != "" {
+		args = append(args, "--cachemode", changes.CacheMode)
+	}
+	args = append(args, fmt.Sprintf("%s/%s", vg, name))
+	return "lvchange", args
+}
+
+// buildLvconvertRaid1Cmd converts an LV to raid1 with the given number of additional images
+func buildLvconvertRaid1Cmd(vg, name string, mirrors int) (string, []string) {
+	args := []string{"--yes", "--type", "raid1", "--mirrors", fmt.Sprintf("%d", mirrors), fmt.Sprintf("%s/%s", vg, name)}
+	return "lvconvert", args
+}
+
+// buildLvchangeSyncActionCmd starts a check or repair of the images of a RAID LV, which runs in the background
+func buildLvchangeSyncActionCmd(vg, name, action string) (string, []string) {
+	args := []string{"--syncaction", action, fmt.Sprintf("%s/%s", vg, name)}
+	return "lvchange", args
+}
+
+// buildLvconvertRepairCmd replaces the failed images of a RAID LV with new ones on the PVs with any of the given tags
+func buildLvconvertRepairCmd(vg, name string, pvTags []string) (string, []string) {
+	args := []string{"--repair", "--yes", fmt.Sprintf("%s/%s", vg, name)}
+	for _, tag := range pvTags {
+		args = append(args, "@"+tag)
+	}
+	return "lvconvert", args
+}
+
+func buildLvcreateCacheCmd(vg, name string, size int64, opts CacheOptions) (string, []string) {
+	args := []string{"--name", name, "--yes"}
+	if !opts.IsWritecache() {
+		args = append(args, "--type", "cache-pool")
+	}
+	args = append(args, "--size", fmt.Sprintf("%db", size), "--setautoactivation", "n", vg)
+	for _, tag := range opts.PVTags {
+		args = append(args, "@"+tag)
+	}
+	return "lvcreate", args
+}
+
+// buildLvconvertCacheCmd attaches a cache pool, or a cache volume for dm-writecache, to an LV
+func buildLvconvertCacheCmd(vg, name, cache string, opts CacheOptions) (string, []string) {
+	args := []string{"--yes"}
+	if opts.IsWritecache() {
+		args = append(args, "--type", "writecache", "--cachevol", fmt.Sprintf("%s/%s", vg, cache))
+	} else {
+		args = append(args, "--type", "cache", "--cachepool", fmt.Sprintf("%s/%s", vg, cache), "--cachemode", opts.Mode)
+	}
+	args = append(args, fmt.Sprintf("%s/%s", vg, name))
+	return "lvconvert", args
+}
+
+func buildLvconvertSplitCacheCmd(vg, name string) (string, []string) {
+	args := []string{"--yes", "--splitcache", fmt.Sprintf("%s/%s", vg, name)}
+	return "lvconvert", args
+}
+
+func buildLvconvertUncacheCmd(vg, name string) (string, []string) {
+	args := []string{"--yes", "--uncache", fmt.Sprintf("%s/%s", vg, name)}
+	return "lvconvert", args
+}
+
+// vgsReportArgs are shared by every vgs invocation, so that all of them can be handled by parseVgsLine
+func vgsReportArgs() []string {
+	return []string{"--noheadings", "--nosuffix", "--units", "b", "-o", "vg_name,vg_free,vg_attr,vg_extent_size"}
+}
+
 func buildVgsCmg(name string) (string, []string) {
-	args := []string{"--noheadings", "--nosuffix", "--units", "b", "-o", "vg_name,vg_free", name}
+	args := append(vgsReportArgs(), name)
 	return "vgs", args
 }
+
+func buildVgsListCmd() (string, []string) {
+	return "vgs", vgsReportArgs()
+}
+
+// buildPvsSegmentsCmd lists every segment of the PVs of a VG, free areas included, so that they can be grouped by
+// parsePvsSegmentsOutput. Segment sizes are reported in extents.
+func buildPvsSegmentsCmd(vg string) (string, []string) {
+	args := []string{"--segments", "--noheadings", "--nosuffix", "--units", "b", "--separator", "|", "-o", "pv_name,vg_name,pv_free,pv_tags,vg_extent_size,pvseg_size,segtype", "--select", fmt.Sprintf("vg_name=%s", vg)}
+	return "pvs", args
+}
@@ -14,6 +14,7 @@ func TestBuildLvcreateCmd(t *testing.T) {
 		lv           string
 		size         int64
 		tags         []string
+		opts         LVOptions
 		expectedCmd  string
 		expectedArgs []string
 	}{
@@ -35,11 +36,135 @@ func TestBuildLvcreateCmd(t *testing.T) {
 			expectedCmd:  "lvcreate",
 			expectedArgs: strings.Fields("--name test-lv --wipesignatures y --yes --size 1073741824b --setautoactivation n test-vg"),
 		},
+		{
+			name:         "should create thin lv in pool",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			size:         1024 * 1024 * 1024,
+			tags:         []string{"test-tag"},
+			opts:         LVOptions{ThinPool: "test-pool"},
+			expectedCmd:  "lvcreate",
+			expectedArgs: strings.Fields("--name test-lv --wipesignatures y --yes --type thin --virtualsize 1073741824b --setautoactivation n --addtag test-tag test-vg/test-pool"),
+		},
+		{
+			name:         "should create striped lv",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			size:         1024 * 1024 * 1024,
+			opts:         LVOptions{Stripes: 2, StripeSize: 128 * 1024},
+			expectedCmd:  "lvcreate",
+			expectedArgs: strings.Fields("--name test-lv --wipesignatures y --yes --stripes 2 --stripesize 128k --size 1073741824b --setautoactivation n test-vg"),
+		},
+		{
+			name:         "should create raid1 lv",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			size:         1024 * 1024 * 1024,
+			opts:         LVOptions{Type: "raid1", Mirrors: 1},
+			expectedCmd:  "lvcreate",
+			expectedArgs: strings.Fields("--name test-lv --wipesignatures y --yes --type raid1 --mirrors 1 --size 1073741824b --setautoactivation n test-vg"),
+		},
+		{
+			name:         "should create raid lv with integrity",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			size:         1024 * 1024 * 1024,
+			opts:         LVOptions{Type: "raid1", Mirrors: 1, RaidIntegrity: true},
+			expectedCmd:  "lvcreate",
+			expectedArgs: strings.Fields("--name test-lv --wipesignatures y --yes --type raid1 --mirrors 1 --raidintegrity y --size 1073741824b --setautoactivation n test-vg"),
+		},
+		{
+			name:         "should create raid lv with images on distinct tagged pvs",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			size:         1024 * 1024 * 1024,
+			opts:         LVOptions{Type: "raid5", Stripes: 2, PVTags: []string{"array-a", "array-b", "array-c"}},
+			expectedCmd:  "lvcreate",
+			expectedArgs: strings.Fields(`--name test-lv --wipesignatures y --yes --type raid5 --stripes 2 --size 1073741824b --config allocation/cling_tag_list=["@array-a","@array-b","@array-c"] --setautoactivation n test-vg @array-a @array-b @array-c`),
+		},
+		{
+			name:         "should create vdo lv with its pool",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			size:         10 * 1024 * 1024 * 1024,
+			opts:         LVOptions{Type: "vdo", VDOPool: "test-lv_vdopool", VDOPhysicalSize: 5 * 1024 * 1024 * 1024, VDOCompression: true},
+			expectedCmd:  "lvcreate",
+			expectedArgs: strings.Fields("--name test-lv --wipesignatures y --yes --type vdo --size 5368709120b --virtualsize 10737418240b --compression y --deduplication n --setautoactivation n test-vg/test-lv_vdopool"),
+		},
+		{
+			name:         "should create lv on tagged pvs with an allocation policy",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			size:         1024 * 1024 * 1024,
+			opts:         LVOptions{PVTags: []string{"ssd"}, AllocPolicy: "cling"},
+			expectedCmd:  "lvcreate",
+			expectedArgs: strings.Fields(`--name test-lv --wipesignatures y --yes --size 1073741824b --alloc cling --setautoactivation n test-vg @ssd`),
+		},
+		{
+			name:         "should create striped lv on pvs with a single tag",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			size:         1024 * 1024 * 1024,
+			opts:         LVOptions{Stripes: 2, PVTags: []string{"ssd"}},
+			expectedCmd:  "lvcreate",
+			expectedArgs: strings.Fields(`--name test-lv --wipesignatures y --yes --stripes 2 --size 1073741824b --setautoactivation n test-vg @ssd`),
+		},
+		{
+			name:         "should keep raid images on pvs with distinct tags",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			size:         1024 * 1024 * 1024,
+			opts:         LVOptions{Type: "raid1", Mirrors: 1, PVTags: []string{"rack-a", "rack-b"}},
+			expectedCmd:  "lvcreate",
+			expectedArgs: strings.Fields(`--name test-lv --wipesignatures y --yes --type raid1 --mirrors 1 --size 1073741824b --config allocation/cling_tag_list=["@rack-a","@rack-b"] --setautoactivation n test-vg @rack-a @rack-b`),
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cmd, args := buildLvcreateCmd(tt.vg, tt.lv, tt.size, tt.tags)
+			cmd, args := buildLvcreateCmd(tt.vg, tt.lv, tt.size, tt.tags, tt.opts)
+			assert.Equal(t, tt.expectedCmd, cmd)
+			assert.Equal(t, tt.expectedArgs, args)
+		})
+	}
+}
+
+func TestBuildLvcreateSnapshotCmd(t *testing.T) {
+	tests := []struct {
+		name         string
+		vg           string
+		lv           string
+		origin       string
+		size         int64
+		tags         []string
+		expectedCmd  string
+		expectedArgs []string
+	}{
+		{
+			name:         "should create snapshot with correct command",
+			vg:           "test-vg",
+			lv:           "test-snap",
+			origin:       "test-lv",
+			size:         512 * 1024 * 1024,
+			tags:         []string{"test-tag", "test-tag2"},
+			expectedCmd:  "lvcreate",
+			expectedArgs: strings.Fields("--snapshot --name test-snap --yes --size 536870912b --setautoactivation n --addtag test-tag --addtag test-tag2 test-vg/test-lv"),
+		},
+		{
+			name:         "should create thin snapshot when size is zero",
+			vg:           "test-vg",
+			lv:           "test-snap",
+			origin:       "test-lv",
+			size:         0,
+			tags:         []string{"test-tag"},
+			expectedCmd:  "lvcreate",
+			expectedArgs: strings.Fields("--snapshot --name test-snap --yes --setactivationskip n --setautoactivation n --addtag test-tag test-vg/test-lv"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, args := buildLvcreateSnapshotCmd(tt.vg, tt.lv, tt.origin, tt.size, tt.tags)
 			assert.Equal(t, tt.expectedCmd, cmd)
 			assert.Equal(t, tt.expectedArgs, args)
 		})
@@ -59,7 +184,7 @@ func TestBuildLvsCmd(t *testing.T) {
 			vg:           "test-vg",
 			lv:           "test-lv",
 			expectedCmd:  "lvs",
-			expectedArgs: strings.Fields("--noheadings --nosuffix --units b -o lv_name,lv_size,lv_attr,lv_tags test-vg/test-lv"),
+			expectedArgs: append(strings.Fields(`--noheadings --nosuffix --units b --separator | --config report/time_format="%s" -o lv_name,vg_name,lv_size,lv_attr,lv_tags,origin,origin_size,lv_time,pool_lv,data_percent,metadata_percent,copy_percent,raid_sync_action,integritymismatches,raid_mismatch_count,lv_health_status`), "test-vg/test-lv"),
 		},
 	}
 
@@ -72,6 +197,84 @@ func TestBuildLvsCmd(t *testing.T) {
 	}
 }
 
+func TestBuildLvsOwnedCmd(t *testing.T) {
+	tests := []struct {
+		name         string
+		vg           string
+		expectedCmd  string
+		expectedArgs []string
+	}{
+		{
+			name:         "should list owned lvs of a vg",
+			vg:           "test-vg",
+			expectedCmd:  "lvs",
+			expectedArgs: append(lvsReportArgs(), "--select", "lv_tags={csi-shared-lvm.cienijr.github.com}", "test-vg"),
+		},
+		{
+			name:         "should list owned lvs of all vgs",
+			vg:           "",
+			expectedCmd:  "lvs",
+			expectedArgs: append(lvsReportArgs(), "--select", "lv_tags={csi-shared-lvm.cienijr.github.com}"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, args := buildLvsOwnedCmd(tt.vg)
+			assert.Equal(t, tt.expectedCmd, cmd)
+			assert.Equal(t, tt.expectedArgs, args)
+		})
+	}
+}
+
+func TestBuildLvsSnapshotsCmd(t *testing.T) {
+	tests := []struct {
+		name         string
+		vg           string
+		expectedCmd  string
+		expectedArgs []string
+	}{
+		{
+			name:         "should list snapshots of a vg",
+			vg:           "test-vg",
+			expectedCmd:  "lvs",
+			expectedArgs: append(lvsReportArgs(), "--select", "lv_tags={csi-shared-lvm.cienijr.github.com/snapshot}", "test-vg"),
+		},
+		{
+			name:         "should list snapshots of all vgs",
+			vg:           "",
+			expectedCmd:  "lvs",
+			expectedArgs: append(lvsReportArgs(), "--select", "lv_tags={csi-shared-lvm.cienijr.github.com/snapshot}"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, args := buildLvsSnapshotsCmd(tt.vg)
+			assert.Equal(t, tt.expectedCmd, cmd)
+			assert.Equal(t, tt.expectedArgs, args)
+		})
+	}
+}
+
+func TestBuildLvsThinPoolsCmd(t *testing.T) {
+	cmd, args := buildLvsThinPoolsCmd("test-vg")
+	assert.Equal(t, "lvs", cmd)
+	assert.Equal(t, append(lvsReportArgs(), "--select", "segtype=thin-pool", "test-vg"), args)
+}
+
+func TestBuildLvsThinVolumesCmd(t *testing.T) {
+	cmd, args := buildLvsThinVolumesCmd("test-vg", "test-pool")
+	assert.Equal(t, "lvs", cmd)
+	assert.Equal(t, append(lvsReportArgs(), "--select", "pool_lv=test-pool", "test-vg"), args)
+}
+
+func TestBuildLvsRaidCmd(t *testing.T) {
+	cmd, args := buildLvsRaidCmd("test-vg")
+	assert.Equal(t, "lvs", cmd)
+	assert.Equal(t, append(lvsReportArgs(), "--select", "lv_tags={csi-shared-lvm.cienijr.github.com} && segtype=~^raid", "test-vg"), args)
+}
+
 func TestBuildLvremoveCmd(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -104,6 +307,7 @@ func TestBuildLvextendCmd(t *testing.T) {
 		vg           string
 		lv           string
 		size         int64
+		opts         LVOptions
 		expectedCmd  string
 		expectedArgs []string
 	}{
@@ -115,11 +319,38 @@ func TestBuildLvextendCmd(t *testing.T) {
 			expectedCmd:  "lvextend",
 			expectedArgs: strings.Fields("-L 2147483648b test-vg/test-lv"),
 		},
+		{
+			name:         "should resize striped lv with its stripe geometry",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			size:         2147483648,
+			opts:         LVOptions{Stripes: 4, StripeSize: 64 * 1024},
+			expectedCmd:  "lvextend",
+			expectedArgs: strings.Fields("-L 2147483648b --stripes 4 --stripesize 64k test-vg/test-lv"),
+		},
+		{
+			name:         "should resize lv on its tagged pvs with its allocation policy",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			size:         2147483648,
+			opts:         LVOptions{PVTags: []string{"ssd"}, AllocPolicy: "contiguous"},
+			expectedCmd:  "lvextend",
+			expectedArgs: strings.Fields(`-L 2147483648b --alloc contiguous test-vg/test-lv @ssd`),
+		},
+		{
+			name:         "should resize raid lv keeping its images on pvs with distinct tags",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			size:         2147483648,
+			opts:         LVOptions{Type: "raid", PVTags: []string{"rack-a", "rack-b"}},
+			expectedCmd:  "lvextend",
+			expectedArgs: strings.Fields(`-L 2147483648b --config allocation/cling_tag_list=["@rack-a","@rack-b"] test-vg/test-lv @rack-a @rack-b`),
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cmd, args := buildLvextendCmd(tt.vg, tt.lv, tt.size)
+			cmd, args := buildLvextendCmd(tt.vg, tt.lv, tt.size, tt.opts)
 			assert.Equal(t, tt.expectedCmd, cmd)
 			assert.Equal(t, tt.expectedArgs, args)
 		})
@@ -178,6 +409,50 @@ func TestBuildLvchangeDeactivateCmd(t *testing.T) {
 	}
 }
 
+func TestBuildLvchangeTagsCmd(t *testing.T) {
+	tests := []struct {
+		name         string
+		vg           string
+		lv           string
+		add          []string
+		remove       []string
+		expectedCmd  string
+		expectedArgs []string
+	}{
+		{
+			name:         "should add and remove tags",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			add:          []string{"tag1", "tag2"},
+			remove:       []string{"tag3"},
+			expectedCmd:  "lvchange",
+			expectedArgs: strings.Fields("--addtag tag1 --addtag tag2 --deltag tag3 test-vg/test-lv"),
+		},
+		{
+			name:         "should only remove tags",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			remove:       []string{"tag1"},
+			expectedCmd:  "lvchange",
+			expectedArgs: strings.Fields("--deltag tag1 test-vg/test-lv"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, args := buildLvchangeTagsCmd(tt.vg, tt.lv, tt.add, tt.remove)
+			assert.Equal(t, tt.expectedCmd, cmd)
+			assert.Equal(t, tt.expectedArgs, args)
+		})
+	}
+}
+
+func TestBuildVgsListCmd(t *testing.T) {
+	cmd, args := buildVgsListCmd()
+	assert.Equal(t, "vgs", cmd)
+	assert.Equal(t, strings.Fields("--noheadings --nosuffix --units b -o vg_name,vg_free,vg_attr,vg_extent_size"), args)
+}
+
 func TestBuildVgsCmg(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -189,7 +464,7 @@ func TestBuildVgsCmg(t *testing.T) {
 			name:         "should get vg successfully",
 			vg:           "test-vg",
 			expectedCmd:  "vgs",
-			expectedArgs: strings.Fields("--noheadings --nosuffix --units b -o vg_name,vg_free test-vg"),
+			expectedArgs: strings.Fields("--noheadings --nosuffix --units b -o vg_name,vg_free,vg_attr,vg_extent_size test-vg"),
 		},
 	}
 
@@ -201,3 +476,141 @@ func TestBuildVgsCmg(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildLvchangeCmd(t *testing.T) {
+	tests := []struct {
+		name         string
+		vg           string
+		lv           string
+		changes      LVChanges
+		expectedCmd  string
+		expectedArgs []string
+	}{
+		{
+			name:         "should change read ahead and cache mode",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			changes:      LVChanges{ReadAhead: "256", CacheMode: "writeback"},
+			expectedCmd:  "lvchange",
+			expectedArgs: strings.Fields("--readahead 256 --cachemode writeback test-vg/test-lv"),
+		},
+		{
+			name:         "should only change read ahead",
+			vg:           "test-vg",
+			lv:           "test-lv",
+			changes:      LVChanges{ReadAhead: "auto"},
+			expectedCmd:  "lvchange",
+			expectedArgs: strings.Fields("--readahead auto test-vg/test-lv"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, args := buildLvchangeCmd(tt.vg, tt.lv, tt.changes)
+			assert.Equal(t, tt.expectedCmd, cmd)
+			assert.Equal(t, tt.expectedArgs, args)
+		})
+	}
+}
+
+func TestBuildLvconvertRaid1Cmd(t *testing.T) {
+	cmd, args := buildLvconvertRaid1Cmd("test-vg", "test-lv", 2)
+	assert.Equal(t, "lvconvert", cmd)
+	assert.Equal(t, strings.Fields("--yes --type raid1 --mirrors 2 test-vg/test-lv"), args)
+}
+
+func TestBuildLvrenameCmd(t *testing.T) {
+	cmd, args := buildLvrenameCmd("test-vg", "test-lv", "test-lv2")
+	assert.Equal(t, "lvrename", cmd)
+	assert.Equal(t, strings.Fields("test-vg test-lv test-lv2"), args)
+}
+
+func TestBuildLvchangeSyncActionCmd(t *testing.T) {
+	cmd, args := buildLvchangeSyncActionCmd("test-vg", "test-lv", "check")
+	assert.Equal(t, "lvchange", cmd)
+	assert.Equal(t, strings.Fields("--syncaction check test-vg/test-lv"), args)
+}
+
+func TestBuildLvconvertRepairCmd(t *testing.T) {
+	cmd, args := buildLvconvertRepairCmd("test-vg", "test-lv", []string{"spare-a", "spare-b"})
+	assert.Equal(t, "lvconvert", cmd)
+	assert.Equal(t, strings.Fields("--repair --yes test-vg/test-lv @spare-a @spare-b"), args)
+}
+
+func TestBuildLvcreateCacheCmd(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         CacheOptions
+		expectedArgs []string
+	}{
+		{
+			name:         "should create cache pool on tagged pvs",
+			opts:         CacheOptions{Mode: "writeback", PVTags: []string{"nvme"}},
+			expectedArgs: strings.Fields("--name test-lv_cache --yes --type cache-pool --size 1073741824b --setautoactivation n test-vg @nvme"),
+		},
+		{
+			name:         "should create plain cache volume for writecache",
+			opts:         CacheOptions{Mode: "writecache", PVTags: []string{"nvme"}},
+			expectedArgs: strings.Fields("--name test-lv_cache --yes --size 1073741824b --setautoactivation n test-vg @nvme"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, args := buildLvcreateCacheCmd("test-vg", "test-lv_cache", 1024*1024*1024, tt.opts)
+			assert.Equal(t, "lvcreate", cmd)
+			assert.Equal(t, tt.expectedArgs, args)
+		})
+	}
+}
+
+func TestBuildLvconvertCacheCmd(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         CacheOptions
+		expectedArgs []string
+	}{
+		{
+			name:         "should attach cache pool with its cache mode",
+			opts:         CacheOptions{Mode: "writethrough"},
+			expectedArgs: strings.Fields("--yes --type cache --cachepool test-vg/test-lv_cache --cachemode writethrough test-vg/test-lv"),
+		},
+		{
+			name:         "should attach writecache cache volume",
+			opts:         CacheOptions{Mode: "writecache"},
+			expectedArgs: strings.Fields("--yes --type writecache --cachevol test-vg/test-lv_cache test-vg/test-lv"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, args := buildLvconvertCacheCmd("test-vg", "test-lv", "test-lv_cache", tt.opts)
+			assert.Equal(t, "lvconvert", cmd)
+			assert.Equal(t, tt.expectedArgs, args)
+		})
+	}
+}
+
+func TestBuildLvconvertSplitCacheCmd(t *testing.T) {
+	cmd, args := buildLvconvertSplitCacheCmd("test-vg", "test-lv")
+	assert.Equal(t, "lvconvert", cmd)
+	assert.Equal(t, strings.Fields("--yes --splitcache test-vg/test-lv"), args)
+}
+
+func TestBuildLvconvertUncacheCmd(t *testing.T) {
+	cmd, args := buildLvconvertUncacheCmd("test-vg", "test-lv")
+	assert.Equal(t, "lvconvert", cmd)
+	assert.Equal(t, strings.Fields("--yes --uncache test-vg/test-lv"), args)
+}
+
+func TestBuildLvsVdoPoolCmd(t *testing.T) {
+	cmd, args := buildLvsVdoPoolCmd("test-vg", "test-lv_vdopool")
+	assert.Equal(t, "lvs", cmd)
+	assert.Equal(t, strings.Fields("--noheadings --nosuffix --units b --separator | -o lv_name,vg_name,lv_size,vdo_used_size,vdo_saving_percent test-vg/test-lv_vdopool"), args)
+}
+
+func TestBuildPvsSegmentsCmd(t *testing.T) {
+	cmd, args := buildPvsSegmentsCmd("test-vg")
+	assert.Equal(t, "pvs", cmd)
+	assert.Equal(t, strings.Fields("--segments --noheadings --nosuffix --units b --separator | -o pv_name,vg_name,pv_free,pv_tags,vg_extent_size,pvseg_size,segtype --select vg_name=test-vg"), args)
+}
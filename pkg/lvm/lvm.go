@@ -8,16 +8,39 @@ import (
 
 const (
 	OwnershipTag = "csi-shared-lvm.cienijr.github.com"
+	SnapshotTag  = OwnershipTag + "/snapshot"
+	// PopulatingTag marks volumes whose content is still being copied from a content source
+	PopulatingTag = OwnershipTag + "/populating"
 )
 
 type LVM interface {
 	GetLV(vg, name string) (*LogicalVolume, error)
-	CreateLV(vg, name string, size int64, tags []string) error
+	ListOwnedLVs(vg string) ([]*LogicalVolume, error)
+	CreateLV(vg, name string, size int64, tags []string, opts LVOptions) error
 	DeleteLV(vg, name string) error
-	ResizeLV(vg, name string, size int64) error
+	RenameLV(vg, name, newName string) error
+	ResizeLV(vg, name string, size int64, opts LVOptions) error
 	ActivateLV(vg, name string) error
 	DeactivateLV(vg, name string) error
 	GetVG(name string) (*VolumeGroup, error)
+	ListVGs() ([]*VolumeGroup, error)
+	ListPVs(vg string) ([]*PhysicalVolume, error)
+	CreateSnapshot(vg, name, origin string, size int64, tags []string) error
+	ListSnapshots(vg string) ([]*LogicalVolume, error)
+	DeleteSnapshot(vg, name string) error
+	ListThinPools(vg string) ([]*LogicalVolume, error)
+	ListThinVolumes(vg, pool string) ([]*LogicalVolume, error)
+	UpdateLVTags(vg, name string, add, remove []string) error
+	ChangeLV(vg, name string, changes LVChanges) error
+	ConvertLVToRaid1(vg, name string, mirrors int) error
+	CreateCacheLV(vg, name string, size int64, opts CacheOptions) error
+	AttachCache(vg, name, cache string, opts CacheOptions) error
+	SplitCache(vg, name string) error
+	Uncache(vg, name string) error
+	GetVDOPool(vg, name string) (*VDOPool, error)
+	ListRaidLVs(vg string) ([]*LogicalVolume, error)
+	StartRaidSyncAction(vg, name, action string) error
+	RepairRaidLV(vg, name string, pvTags []string) error
 }
 type client struct {
 }
@@ -26,8 +49,8 @@ func NewLVM() LVM {
 	return &client{}
 }
 
-func (c *client) CreateLV(vg, name string, size int64, tags []string) error {
-	command, args := buildLvcreateCmd(vg, name, size, tags)
+func (c *client) CreateLV(vg, name string, size int64, tags []string, opts LVOptions) error {
+	command, args := buildLvcreateCmd(vg, name, size, tags, opts)
 	cmd := exec.Command(command, args...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
@@ -44,7 +67,17 @@ func (c *client) GetLV(vg, name string) (*LogicalVolume, error) {
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 	err := cmd.Run()
-	return parseLvsOutput(vg, stdout.String(), stderr.String(), err)
+	return parseLvsOutput(stdout.String(), stderr.String(), err)
+}
+
+func (c *client) ListOwnedLVs(vg string) ([]*LogicalVolume, error) {
+	command, args := buildLvsOwnedCmd(vg)
+	cmd := exec.Command(command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return parseLvsListOutput(stdout.String(), stderr.String(), err)
 }
 
 func (c *client) DeleteLV(vg, name string) error {
@@ -58,8 +91,19 @@ func (c *client) DeleteLV(vg, name string) error {
 	return nil
 }
 
-func (c *client) ResizeLV(vg, name string, size int64) error {
-	command, args := buildLvextendCmd(vg, name, size)
+func (c *client) RenameLV(vg, name, newName string) error {
+	command, args := buildLvrenameCmd(vg, name, newName)
+	cmd := exec.Command(command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to rename lv: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func (c *client) ResizeLV(vg, name string, size int64, opts LVOptions) error {
+	command, args := buildLvextendCmd(vg, name, size, opts)
 	cmd := exec.Command(command, args...)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
@@ -100,3 +144,200 @@ func (c *client) GetVG(name string) (*VolumeGroup, error) {
 	err := cmd.Run()
 	return parseVgsOutput(stdout.String(), stderr.String(), err)
 }
+
+func (c *client) ListVGs() ([]*VolumeGroup, error) {
+	command, args := buildVgsListCmd()
+	cmd := exec.Command(command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return parseVgsListOutput(stdout.String(), stderr.String(), err)
+}
+
+func (c *client) ListPVs(vg string) ([]*PhysicalVolume, error) {
+	command, args := buildPvsSegmentsCmd(vg)
+	cmd := exec.Command(command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return parsePvsSegmentsOutput(stdout.String(), stderr.String(), err)
+}
+
+func (c *client) CreateSnapshot(vg, name, origin string, size int64, tags []string) error {
+	command, args := buildLvcreateSnapshotCmd(vg, name, origin, size, tags)
+	cmd := exec.Command(command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to create snapshot: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func (c *client) ListSnapshots(vg string) ([]*LogicalVolume, error) {
+	command, args := buildLvsSnapshotsCmd(vg)
+	cmd := exec.Command(command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return parseLvsListOutput(stdout.String(), stderr.String(), err)
+}
+
+func (c *client) DeleteSnapshot(vg, name string) error {
+	command, args := buildLvremoveCmd(vg, name)
+	cmd := exec.Command(command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func (c *client) ListThinPools(vg string) ([]*LogicalVolume, error) {
+	command, args := buildLvsThinPoolsCmd(vg)
+	cmd := exec.Command(command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return parseLvsListOutput(stdout.String(), stderr.String(), err)
+}
+
+func (c *client) ListThinVolumes(vg, pool string) ([]*LogicalVolume, error) {
+	command, args := buildLvsThinVolumesCmd(vg, pool)
+	cmd := exec.Command(command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return parseLvsListOutput(stdout.String(), stderr.String(), err)
+}
+
+func (c *client) UpdateLVTags(vg, name string, add, remove []string) error {
+	command, args := buildLvchangeTagsCmd(vg, name, add, remove)
+	cmd := exec.Command(command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to update lv tags: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func (c *client) ChangeLV(vg, name string, changes LVChanges) error {
+	command, args := buildLvchangeCmd(vg, name, changes)
+	cmd := exec.Command(command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to change lv: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func (c *client) ConvertLVToRaid1(vg, name string, mirrors int) error {
+	command, args := buildLvconvertRaid1Cmd(vg, name, mirrors)
+	cmd := exec.Command(command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to convert lv: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// CreateCacheLV creates the LV that caches another one: a cache pool for dm-cache, or a plain LV used as cache volume
+// by dm-writecache
+func (c *client) CreateCacheLV(vg, name string, size int64, opts CacheOptions) error {
+	command, args := buildLvcreateCacheCmd(vg, name, size, opts)
+	cmd := exec.Command(command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to create cache lv: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func (c *client) AttachCache(vg, name, cache string, opts CacheOptions) error {
+	command, args := buildLvconvertCacheCmd(vg, name, cache, opts)
+	cmd := exec.Command(command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to attach cache: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// SplitCache flushes the cache of an LV and detaches it, keeping the cache LV so that it can be attached again
+func (c *client) SplitCache(vg, name string) error {
+	command, args := buildLvconvertSplitCacheCmd(vg, name)
+	cmd := exec.Command(command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to split cache: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// Uncache flushes the cache of an LV, detaches it and removes the cache LV
+func (c *client) Uncache(vg, name string) error {
+	command, args := buildLvconvertUncacheCmd(vg, name)
+	cmd := exec.Command(command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to uncache lv: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func (c *client) GetVDOPool(vg, name string) (*VDOPool, error) {
+	command, args := buildLvsVdoPoolCmd(vg, name)
+	cmd := exec.Command(command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return parseVdoPoolOutput(stdout.String(), stderr.String(), err)
+}
+
+func (c *client) ListRaidLVs(vg string) ([]*LogicalVolume, error) {
+	command, args := buildLvsRaidCmd(vg)
+	cmd := exec.Command(command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	return parseLvsListOutput(stdout.String(), stderr.String(), err)
+}
+
+// StartRaidSyncAction starts a check or repair of a RAID LV. It returns as soon as the kernel started it, the progress
+// is reported by the RaidSyncAction and SyncPercent of the LV.
+func (c *client) StartRaidSyncAction(vg, name, action string) error {
+	command, args := buildLvchangeSyncActionCmd(vg, name, action)
+	cmd := exec.Command(command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to start raid sync action: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+func (c *client) RepairRaidLV(vg, name string, pvTags []string) error {
+	command, args := buildLvconvertRepairCmd(vg, name, pvTags)
+	cmd := exec.Command(command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to repair raid lv: %v, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -19,7 +20,7 @@ type exitError interface {
 
 var _ exitError = &exec.ExitError{}
 
-func parseLvsOutput(vg, stdout, stderr string, err error) (*LogicalVolume, error) {
+func parseLvsOutput(stdout, stderr string, err error) (*LogicalVolume, error) {
 	if err != nil {
 		if isNotFound(err, stderr) {
 			return nil, nil
@@ -32,30 +33,141 @@ func parseLvsOutput(vg, stdout, stderr string, err error) (*LogicalVolume, error
 		return nil, nil // LV doesn't exist
 	}
 
-	fields := strings.Fields(output)
-	if len(fields) < 3 {
-		return nil, fmt.Errorf("failed to parse lvs output: %s", output)
+	return parseLvsLine(output)
+}
+
+func parseLvsListOutput(stdout, stderr string, err error) ([]*LogicalVolume, error) {
+	if err != nil {
+		if isNotFound(err, stderr) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list lvs: %v, stderr: %s", err, stderr)
+	}
+
+	var lvs []*LogicalVolume
+	for _, line := range strings.Split(stdout, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lv, err := parseLvsLine(line)
+		if err != nil {
+			return nil, err
+		}
+		lvs = append(lvs, lv)
+	}
+	return lvs, nil
+}
+
+// parseLvsLine parses a single line of output produced by an lvs command built with lvsReportArgs
+func parseLvsLine(line string) (*LogicalVolume, error) {
+	fields := strings.Split(line, "|")
+	if len(fields) < 16 {
+		return nil, fmt.Errorf("failed to parse lvs output: %s", line)
 	}
 
-	size, err := parseLVSize(fields[1])
+	size, err := parseLVSize(fields[2])
 	if err != nil {
 		return nil, err
 	}
 
 	var tags []string
-	if len(fields) > 3 {
-		tags = strings.Split(fields[3], ",")
+	if fields[4] != "" {
+		tags = strings.Split(fields[4], ",")
+	}
+
+	var originSize int64
+	if fields[6] != "" {
+		originSize, err = parseLVSize(fields[6])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var creationTime time.Time
+	if fields[7] != "" {
+		seconds, err := strconv.ParseInt(fields[7], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse lv creation time: %v", err)
+		}
+		creationTime = time.Unix(seconds, 0)
+	}
+
+	var dataPercent float64
+	if fields[9] != "" {
+		dataPercent, err = strconv.ParseFloat(fields[9], 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse lv data percent: %v", err)
+		}
+	}
+
+	var metadataPercent float64
+	if fields[10] != "" {
+		metadataPercent, err = strconv.ParseFloat(fields[10], 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse lv metadata percent: %v", err)
+		}
+	}
+
+	var syncPercent float64
+	if fields[11] != "" {
+		syncPercent, err = strconv.ParseFloat(fields[11], 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse lv sync percent: %v", err)
+		}
+	}
+
+	var integrityMismatches int64
+	if fields[13] != "" {
+		integrityMismatches, err = strconv.ParseInt(fields[13], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse lv integrity mismatches: %v", err)
+		}
+	}
+
+	raidMismatchCount, err := parseRaidMismatchCount(fields[14])
+	if err != nil {
+		return nil, err
 	}
 
 	return &LogicalVolume{
-		Name: fields[0],
-		VG:   vg,
-		Size: size,
-		Tags: tags,
-		Attr: Attr(fields[2]),
+		Name:                fields[0],
+		VG:                  fields[1],
+		Size:                size,
+		Tags:                tags,
+		Attr:                Attr(fields[3]),
+		Origin:              fields[5],
+		OriginSize:          originSize,
+		CreationTime:        creationTime,
+		Pool:                fields[8],
+		DataPercent:         dataPercent,
+		MetadataPercent:     metadataPercent,
+		UsageKnown:          fields[9] != "",
+		SyncPercent:         syncPercent,
+		RaidSyncAction:      fields[12],
+		IntegrityMismatches: integrityMismatches,
+		RaidMismatchCount:   raidMismatchCount,
+		HealthStatus:        parseHealthStatus(fields[15]),
 	}, nil
 }
 
+// parseRaidMismatchCount parses the raid_mismatch_count field, which is empty for LVs that aren't active RAID LVs
+func parseRaidMismatchCount(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	count, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse lv raid mismatch count: %v", err)
+	}
+	return count, nil
+}
+
+// parseHealthStatus parses the lv_health_status field, which LVM pads with spaces in some versions
+func parseHealthStatus(value string) string {
+	return strings.TrimSpace(value)
+}
+
 func parseLVSize(sizeStr string) (int64, error) {
 	return strconv.ParseInt(strings.TrimSuffix(sizeStr, "B"), 10, 64)
 }
@@ -95,9 +207,34 @@ func parseVgsOutput(stdout, stderr string, err error) (*VolumeGroup, error) {
 		return nil, nil
 	}
 
-	fields := strings.Fields(output)
-	if len(fields) < 2 {
-		return nil, fmt.Errorf("failed to parse vgs output: %s", output)
+	return parseVgsLine(output)
+}
+
+func parseVgsListOutput(stdout, stderr string, err error) ([]*VolumeGroup, error) {
+	if err != nil {
+		return nil, fmt.Errorf("failed to list vgs: %v, stderr: %s", err, stderr)
+	}
+
+	var vgs []*VolumeGroup
+	for _, line := range strings.Split(stdout, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		vg, err := parseVgsLine(line)
+		if err != nil {
+			return nil, err
+		}
+		vgs = append(vgs, vg)
+	}
+	return vgs, nil
+}
+
+// parseVgsLine parses a single line of output produced by a vgs command built with vgsReportArgs
+func parseVgsLine(line string) (*VolumeGroup, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("failed to parse vgs output: %s", line)
 	}
 
 	freeSize, err := parseLVSize(fields[1])
@@ -105,8 +242,117 @@ func parseVgsOutput(stdout, stderr string, err error) (*VolumeGroup, error) {
 		return nil, err
 	}
 
+	extentSize, err := parseLVSize(fields[3])
+	if err != nil {
+		return nil, err
+	}
+
 	return &VolumeGroup{
-		Name:     fields[0],
-		FreeSize: freeSize,
+		Name:       fields[0],
+		FreeSize:   freeSize,
+		Attr:       VGAttr(fields[2]),
+		ExtentSize: extentSize,
+	}, nil
+}
+
+// parsePvsSegmentsOutput parses the output of buildPvsSegmentsCmd, returning one PV per distinct pv_name
+func parsePvsSegmentsOutput(stdout, stderr string, err error) ([]*PhysicalVolume, error) {
+	if err != nil {
+		if isNotFound(err, stderr) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list pvs: %v, stderr: %s", err, stderr)
+	}
+
+	var pvs []*PhysicalVolume
+	byName := make(map[string]*PhysicalVolume)
+	for _, line := range strings.Split(stdout, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "|")
+		if len(fields) < 7 {
+			return nil, fmt.Errorf("failed to parse pvs output: %s", line)
+		}
+
+		pv, ok := byName[fields[0]]
+		if !ok {
+			freeSize, err := parseLVSize(fields[2])
+			if err != nil {
+				return nil, err
+			}
+			var tags []string
+			if fields[3] != "" {
+				tags = strings.Split(fields[3], ",")
+			}
+			pv = &PhysicalVolume{
+				Name:     fields[0],
+				VG:       fields[1],
+				FreeSize: freeSize,
+				Tags:     tags,
+			}
+			byName[pv.Name] = pv
+			pvs = append(pvs, pv)
+		}
+
+		if fields[6] != "free" {
+			continue
+		}
+		extentSize, err := parseLVSize(fields[4])
+		if err != nil {
+			return nil, err
+		}
+		extents, err := strconv.ParseInt(fields[5], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse pv segment size: %v", err)
+		}
+		pv.LargestFreeSegment = max(pv.LargestFreeSegment, extents*extentSize)
+	}
+	return pvs, nil
+}
+
+func parseVdoPoolOutput(stdout, stderr string, err error) (*VDOPool, error) {
+	if err != nil {
+		if isNotFound(err, stderr) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get vdo pool: %v, stderr: %s", err, stderr)
+	}
+
+	output := strings.TrimSpace(stdout)
+	if output == "" {
+		return nil, nil
+	}
+
+	fields := strings.Split(output, "|")
+	if len(fields) < 5 {
+		return nil, fmt.Errorf("failed to parse vdo pool output: %s", output)
+	}
+	size, err := parseLVSize(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	var usedSize int64
+	if fields[3] != "" {
+		usedSize, err = parseLVSize(fields[3])
+		if err != nil {
+			return nil, err
+		}
+	}
+	var savingPercent float64
+	if fields[4] != "" {
+		savingPercent, err = strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse vdo saving percent: %v", err)
+		}
+	}
+	return &VDOPool{
+		Name:          fields[0],
+		VG:            fields[1],
+		Size:          size,
+		UsedSize:      usedSize,
+		SavingPercent: savingPercent,
+		UsageKnown:    fields[3] != "",
 	}, nil
 }
@@ -3,6 +3,7 @@ package lvm
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -22,7 +23,6 @@ func (m *mockExitError) ExitCode() int {
 func TestParseLVSOutput(t *testing.T) {
 	tests := []struct {
 		name        string
-		vg          string
 		stdout      string
 		stderr      string
 		err         error
@@ -31,8 +31,7 @@ func TestParseLVSOutput(t *testing.T) {
 	}{
 		{
 			name:   "should parse lvs output successfully",
-			vg:     "test-vg",
-			stdout: "  test-lv 1073741824B -wi-a----- test-tag",
+			stdout: "  test-lv|test-vg|1073741824B|-wi-a-----|test-tag|||||||||||",
 			expectedLV: &LogicalVolume{
 				Name: "test-lv",
 				VG:   "test-vg",
@@ -43,8 +42,7 @@ func TestParseLVSOutput(t *testing.T) {
 		},
 		{
 			name:   "should parse lvs output successfully with multiple tags",
-			vg:     "test-vg",
-			stdout: "  test-lv 1073741824B -wi------- test-tag,test-tag2,test-tag3",
+			stdout: "  test-lv|test-vg|1073741824B|-wi-------|test-tag,test-tag2,test-tag3|||||||||||",
 			expectedLV: &LogicalVolume{
 				Name: "test-lv",
 				VG:   "test-vg",
@@ -55,8 +53,7 @@ func TestParseLVSOutput(t *testing.T) {
 		},
 		{
 			name:   "should parse lvs output successfully with no tags",
-			vg:     "test-vg",
-			stdout: "  test-lv 1073741824B -wi-ao----",
+			stdout: "  test-lv|test-vg|1073741824B|-wi-ao----||||||||||||",
 			expectedLV: &LogicalVolume{
 				Name: "test-lv",
 				VG:   "test-vg",
@@ -64,9 +61,91 @@ func TestParseLVSOutput(t *testing.T) {
 				Attr: "-wi-ao----",
 			},
 		},
+		{
+			name:   "should parse lvs output successfully for a snapshot",
+			stdout: "  test-snap|test-vg|536870912B|swi-a-s---|test-tag|test-lv|1073741824B|1700000000||||||||",
+			expectedLV: &LogicalVolume{
+				Name:         "test-snap",
+				VG:           "test-vg",
+				Size:         536870912,
+				Tags:         []string{"test-tag"},
+				Attr:         "swi-a-s---",
+				Origin:       "test-lv",
+				OriginSize:   1073741824,
+				CreationTime: time.Unix(1700000000, 0),
+			},
+		},
+		{
+			name:   "should parse lvs output successfully for a thin volume",
+			stdout: "  test-lv|test-vg|1073741824B|Vwi-a-tz--|test-tag||||test-pool|12.50||||||",
+			expectedLV: &LogicalVolume{
+				Name:        "test-lv",
+				VG:          "test-vg",
+				Size:        1073741824,
+				Tags:        []string{"test-tag"},
+				Attr:        "Vwi-a-tz--",
+				Pool:        "test-pool",
+				DataPercent: 12.5,
+				UsageKnown:  true,
+			},
+		},
+		{
+			name:   "should parse lvs output successfully for a thin pool",
+			stdout: "  test-pool|test-vg|10737418240B|twi-aotz--||||||45.00|3.20|||||",
+			expectedLV: &LogicalVolume{
+				Name:            "test-pool",
+				VG:              "test-vg",
+				Size:            10737418240,
+				Attr:            "twi-aotz--",
+				DataPercent:     45,
+				MetadataPercent: 3.2,
+				UsageKnown:      true,
+			},
+		},
+		{
+			name:   "should parse lvs output successfully for a raid volume",
+			stdout: "  test-lv|test-vg|1073741824B|rwi-a-r---|test-tag|||||||42.50|recover|||",
+			expectedLV: &LogicalVolume{
+				Name:           "test-lv",
+				VG:             "test-vg",
+				Size:           1073741824,
+				Tags:           []string{"test-tag"},
+				Attr:           "rwi-a-r---",
+				SyncPercent:    42.5,
+				RaidSyncAction: "recover",
+			},
+		},
+		{
+			name:   "should parse lvs output successfully for a raid volume with integrity",
+			stdout: "  test-lv|test-vg|1073741824B|rwi-a-r---|test-tag|||||||100.00|idle|3||",
+			expectedLV: &LogicalVolume{
+				Name:                "test-lv",
+				VG:                  "test-vg",
+				Size:                1073741824,
+				Tags:                []string{"test-tag"},
+				Attr:                "rwi-a-r---",
+				SyncPercent:         100,
+				RaidSyncAction:      "idle",
+				IntegrityMismatches: 3,
+			},
+		},
+		{
+			name:   "should parse lvs output successfully for a degraded raid volume with mismatches",
+			stdout: "  test-lv|test-vg|1073741824B|rwi-a-r-p-|test-tag|||||||100.00|idle||128|partial  ",
+			expectedLV: &LogicalVolume{
+				Name:              "test-lv",
+				VG:                "test-vg",
+				Size:              1073741824,
+				Tags:              []string{"test-tag"},
+				Attr:              "rwi-a-r-p-",
+				SyncPercent:       100,
+				RaidSyncAction:    "idle",
+				RaidMismatchCount: 128,
+				HealthStatus:      "partial",
+			},
+		},
 		{
 			name:        "should return nil if lv not found",
-			vg:          "test-vg",
 			stdout:      "",
 			stderr:      `  Failed to find logical volume "test-vg/test-lv"`,
 			err:         &mockExitError{exitCode: 5},
@@ -74,7 +153,6 @@ func TestParseLVSOutput(t *testing.T) {
 		},
 		{
 			name:        "should return nil if vg not found",
-			vg:          "test-vg",
 			stdout:      "",
 			stderr:      `  Volume group "test-vg" not found`,
 			err:         &mockExitError{exitCode: 5},
@@ -82,7 +160,6 @@ func TestParseLVSOutput(t *testing.T) {
 		},
 		{
 			name:        "should return error if command fails",
-			vg:          "test-vg",
 			stdout:      "",
 			stderr:      "some error output",
 			err:         fmt.Errorf("some error"),
@@ -90,7 +167,6 @@ func TestParseLVSOutput(t *testing.T) {
 		},
 		{
 			name:        "should return error on malformed output",
-			vg:          "test-vg",
 			stdout:      "malformed",
 			expectedErr: fmt.Errorf("failed to parse lvs output: malformed"),
 		},
@@ -98,7 +174,7 @@ func TestParseLVSOutput(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			lv, err := parseLvsOutput(tt.vg, tt.stdout, tt.stderr, tt.err)
+			lv, err := parseLvsOutput(tt.stdout, tt.stderr, tt.err)
 			if tt.expectedErr != nil {
 				assert.Error(t, err)
 				assert.Equal(t, tt.expectedErr, err)
@@ -110,6 +186,72 @@ func TestParseLVSOutput(t *testing.T) {
 	}
 }
 
+func TestParseLVSListOutput(t *testing.T) {
+	tests := []struct {
+		name        string
+		stdout      string
+		stderr      string
+		err         error
+		expectedLVs []*LogicalVolume
+		expectedErr error
+	}{
+		{
+			name:   "should parse lvs output successfully with multiple lines",
+			stdout: "  test-lv|test-vg|1073741824B|-wi-a-----|test-tag|||||||||||\n  test-lv2|test-vg2|2147483648B|-wi-------||||||||||||\n",
+			expectedLVs: []*LogicalVolume{
+				{
+					Name: "test-lv",
+					VG:   "test-vg",
+					Size: 1073741824,
+					Tags: []string{"test-tag"},
+					Attr: "-wi-a-----",
+				},
+				{
+					Name: "test-lv2",
+					VG:   "test-vg2",
+					Size: 2147483648,
+					Attr: "-wi-------",
+				},
+			},
+		},
+		{
+			name:        "should return nil if output is empty",
+			stdout:      "\n",
+			expectedLVs: nil,
+		},
+		{
+			name:        "should return nil if vg not found",
+			stderr:      `  Volume group "test-vg" not found`,
+			err:         &mockExitError{exitCode: 5},
+			expectedLVs: nil,
+		},
+		{
+			name:        "should return error if command fails",
+			stderr:      "some error output",
+			err:         fmt.Errorf("some error"),
+			expectedErr: fmt.Errorf("failed to list lvs: some error, stderr: some error output"),
+		},
+		{
+			name:        "should return error on malformed output",
+			stdout:      "  test-lv|test-vg|1073741824B|-wi-a-----|test-tag|||||||||||\nmalformed",
+			expectedErr: fmt.Errorf("failed to parse lvs output: malformed"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lvs, err := parseLvsListOutput(tt.stdout, tt.stderr, tt.err)
+			if tt.expectedErr != nil {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedLVs, lvs)
+			}
+		})
+	}
+}
+
 func TestParseAttr(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -137,6 +279,55 @@ func TestParseAttr(t *testing.T) {
 	}
 }
 
+func TestParseThinAttr(t *testing.T) {
+	assert.True(t, Attr("twi-aotz--").IsThinPool())
+	assert.False(t, Attr("twi-aotz--").IsThinVolume())
+	assert.True(t, Attr("Vwi-a-tz--").IsThinVolume())
+	assert.False(t, Attr("Vwi-a-tz--").IsThinPool())
+	assert.False(t, Attr("-wi-a-----").IsThinPool())
+	assert.False(t, Attr("-wi-a-----").IsThinVolume())
+}
+
+func TestParseHealthAttr(t *testing.T) {
+	assert.Empty(t, Attr("-wi-a-----").HealthProblem())
+	assert.Empty(t, Attr("rwi-a-r-w-").HealthProblem())
+	assert.NotEmpty(t, Attr("-wi-a---p-").HealthProblem())
+	assert.NotEmpty(t, Attr("rwi-a-r-r-").HealthProblem())
+	assert.NotEmpty(t, Attr("twi-aotzD-").HealthProblem())
+}
+
+func TestParseSegmentTypeAttr(t *testing.T) {
+	assert.True(t, Attr("-wi-a-----").IsLinear())
+	assert.False(t, Attr("rwi-a-r---").IsLinear())
+	assert.True(t, Attr("rwi-a-r---").IsRaid())
+	assert.True(t, Attr("Cwi-a-C---").IsCached())
+	assert.False(t, Attr("-wi-a-----").IsCached())
+	assert.True(t, Attr("vwi-a-v---").IsVDO())
+	assert.False(t, Attr("dwi-------").IsVDO())
+}
+
+func TestParseVGAttr(t *testing.T) {
+	assert.False(t, VGAttr("wz--n-").IsPartial())
+	assert.True(t, VGAttr("wz-pn-").IsPartial())
+	assert.Equal(t, "normal", VGAttr("wz--n-").AllocationPolicy())
+	assert.Equal(t, "contiguous", VGAttr("wz--c-").AllocationPolicy())
+	assert.Equal(t, "cling", VGAttr("wz--l-").AllocationPolicy())
+	assert.Equal(t, "anywhere", VGAttr("wz--a-").AllocationPolicy())
+	// the exported flag comes before the allocation policy
+	assert.Equal(t, "normal", VGAttr("wzx-n-").AllocationPolicy())
+}
+
+func TestThinPoolFreeSize(t *testing.T) {
+	pool := &LogicalVolume{Size: 1000, DataPercent: 25, UsageKnown: true}
+	free, ok := pool.ThinPoolFreeSize()
+	assert.True(t, ok)
+	assert.Equal(t, int64(750), free)
+
+	// inactive pools report no usage
+	_, ok = (&LogicalVolume{Size: 1000}).ThinPoolFreeSize()
+	assert.False(t, ok)
+}
+
 func TestParseVGSOutput(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -148,10 +339,22 @@ func TestParseVGSOutput(t *testing.T) {
 	}{
 		{
 			name:   "should parse vgs output successfully",
-			stdout: "  test-vg 1073741824",
+			stdout: "  test-vg 1073741824 wz--n- 4194304",
 			expectedVG: &VolumeGroup{
-				Name:     "test-vg",
-				FreeSize: 1073741824,
+				Name:       "test-vg",
+				FreeSize:   1073741824,
+				Attr:       "wz--n-",
+				ExtentSize: 4194304,
+			},
+		},
+		{
+			name:   "should parse vgs output successfully for a partial vg",
+			stdout: "  test-vg 1073741824 wz-pn- 4194304",
+			expectedVG: &VolumeGroup{
+				Name:       "test-vg",
+				FreeSize:   1073741824,
+				Attr:       "wz-pn-",
+				ExtentSize: 4194304,
 			},
 		},
 		{
@@ -191,3 +394,164 @@ func TestParseVGSOutput(t *testing.T) {
 		})
 	}
 }
+
+func TestParseVGSListOutput(t *testing.T) {
+	tests := []struct {
+		name        string
+		stdout      string
+		stderr      string
+		err         error
+		expectedVGs []*VolumeGroup
+		expectedErr error
+	}{
+		{
+			name:   "should parse vgs output successfully with multiple lines",
+			stdout: "  test-vg 1073741824 wz--n- 4194304\n  test-vg2 0 wz-pn- 1048576\n",
+			expectedVGs: []*VolumeGroup{
+				{Name: "test-vg", FreeSize: 1073741824, Attr: "wz--n-", ExtentSize: 4194304},
+				{Name: "test-vg2", FreeSize: 0, Attr: "wz-pn-", ExtentSize: 1048576},
+			},
+		},
+		{
+			name:   "should return empty list if there are no vgs",
+			stdout: "",
+		},
+		{
+			name:        "should return error if command fails",
+			stderr:      "some other error",
+			err:         fmt.Errorf("some error"),
+			expectedErr: fmt.Errorf("failed to list vgs: some error, stderr: some other error"),
+		},
+		{
+			name:        "should return error on malformed output",
+			stdout:      "  test-vg 1073741824 wz--n- 4194304\nmalformed",
+			expectedErr: fmt.Errorf("failed to parse vgs output: malformed"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vgs, err := parseVgsListOutput(tt.stdout, tt.stderr, tt.err)
+			if tt.expectedErr != nil {
+				assert.Equal(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedVGs, vgs)
+			}
+		})
+	}
+}
+
+func TestParsePVSSegmentsOutput(t *testing.T) {
+	tests := []struct {
+		name        string
+		stdout      string
+		stderr      string
+		err         error
+		expectedPVs []*PhysicalVolume
+		expectedErr error
+	}{
+		{
+			name: "should group segments by pv",
+			stdout: "  /dev/sda|test-vg|314572800|fast|4194304|25|linear\n" +
+				"  /dev/sda|test-vg|314572800|fast|4194304|50|free\n" +
+				"  /dev/sda|test-vg|314572800|fast|4194304|25|free\n" +
+				"  /dev/sdb|test-vg|0||4194304|100|linear\n",
+			expectedPVs: []*PhysicalVolume{
+				{Name: "/dev/sda", VG: "test-vg", FreeSize: 314572800, Tags: []string{"fast"}, LargestFreeSegment: 209715200},
+				{Name: "/dev/sdb", VG: "test-vg", FreeSize: 0},
+			},
+		},
+		{
+			name:        "should return nil if vg not found",
+			stderr:      `  Volume group "test-vg" not found`,
+			err:         &mockExitError{exitCode: 5},
+			expectedPVs: nil,
+		},
+		{
+			name:        "should return error if command fails",
+			stderr:      "some error output",
+			err:         fmt.Errorf("some error"),
+			expectedErr: fmt.Errorf("failed to list pvs: some error, stderr: some error output"),
+		},
+		{
+			name:        "should return error on malformed output",
+			stdout:      "malformed",
+			expectedErr: fmt.Errorf("failed to parse pvs output: malformed"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvs, err := parsePvsSegmentsOutput(tt.stdout, tt.stderr, tt.err)
+			if tt.expectedErr != nil {
+				assert.Equal(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedPVs, pvs)
+			}
+		})
+	}
+}
+
+func TestParseVdoPoolOutput(t *testing.T) {
+	tests := []struct {
+		name         string
+		stdout       string
+		stderr       string
+		err          error
+		expectedPool *VDOPool
+		expectedErr  error
+	}{
+		{
+			name:   "should parse vdo pool output successfully",
+			stdout: "  test-lv_vdopool|test-vg|10737418240|4294967296|62.50",
+			expectedPool: &VDOPool{
+				Name:          "test-lv_vdopool",
+				VG:            "test-vg",
+				Size:          10737418240,
+				UsedSize:      4294967296,
+				SavingPercent: 62.5,
+				UsageKnown:    true,
+			},
+		},
+		{
+			name:   "should parse vdo pool output of inactive pool",
+			stdout: "  test-lv_vdopool|test-vg|10737418240||",
+			expectedPool: &VDOPool{
+				Name: "test-lv_vdopool",
+				VG:   "test-vg",
+				Size: 10737418240,
+			},
+		},
+		{
+			name:         "should return nil if vdo pool not found",
+			stderr:       `  Failed to find logical volume "test-vg/test-lv_vdopool"`,
+			err:          &mockExitError{exitCode: 5},
+			expectedPool: nil,
+		},
+		{
+			name:        "should return error if command fails",
+			stderr:      "some error output",
+			err:         fmt.Errorf("some error"),
+			expectedErr: fmt.Errorf("failed to get vdo pool: some error, stderr: some error output"),
+		},
+		{
+			name:        "should return error on malformed output",
+			stdout:      "malformed",
+			expectedErr: fmt.Errorf("failed to parse vdo pool output: malformed"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, err := parseVdoPoolOutput(tt.stdout, tt.stderr, tt.err)
+			if tt.expectedErr != nil {
+				assert.Equal(t, tt.expectedErr, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedPool, pool)
+			}
+		})
+	}
+}
@@ -1,19 +1,202 @@
 package lvm
 
+import (
+	"slices"
+	"time"
+)
+
 type Attr string
 type LogicalVolume struct {
+	Name         string
+	VG           string
+	Size         int64
+	Tags         []string
+	Attr         Attr
+	Origin       string
+	OriginSize   int64
+	CreationTime time.Time
+	Pool         string
+	// DataPercent and MetadataPercent are the usage of a thin pool or COW snapshot. LVM only reports them for LVs that
+	// are active on this host, UsageKnown is false otherwise.
+	DataPercent     float64
+	MetadataPercent float64
+	UsageKnown      bool
+	// SyncPercent is how much of a RAID LV is in sync, and RaidSyncAction what it is currently doing, e.g. idle or
+	// recover
+	SyncPercent    float64
+	RaidSyncAction string
+	// IntegrityMismatches is the number of checksum mismatches detected by the integrity layers of a RAID LV
+	IntegrityMismatches int64
+	// RaidMismatchCount is the number of regions whose images differed in the last check of a RAID LV
+	RaidMismatchCount int64
+	// HealthStatus describes the health of an LV, e.g. "partial" if some of its PVs are missing, and is empty if it's
+	// healthy
+	HealthStatus string
+}
+
+// LVOptions describes how an LV should be allocated. The zero value creates a linear LV.
+type LVOptions struct {
+	// ThinPool is the name of the thin pool that backs a thin LV
+	ThinPool string
+	// Type is the segment type of the LV, e.g. raid1, or empty for the default
+	Type string
+	// Mirrors and Stripes are the number of additional images and of data stripes of a RAID or striped LV, zero for
+	// the defaults
+	Mirrors int
+	Stripes int
+	// StripeSize is the size in bytes of each stripe, zero for the default
+	StripeSize int64
+	// PVTags restricts allocation to the PVs with any of these tags, and keeps the images of a RAID LV on PVs with
+	// distinct tags
+	PVTags []string
+	// AllocPolicy overrides the allocation policy of the VG: contiguous, cling, normal or anywhere
+	AllocPolicy string
+	// VDOPool is the name of the VDO pool backing a VDO LV, created along with it with VDOPhysicalSize bytes. The size
+	// of the LV itself is its logical size.
+	VDOPool          string
+	VDOPhysicalSize  int64
+	VDOCompression   bool
+	VDODeduplication bool
+	// RaidIntegrity adds a dm-integrity layer to each image of a RAID LV, so that corrupted sectors are detected and
+	// repaired from another image
+	RaidIntegrity bool
+}
+
+// VDOPool is the pool of a VDO LV, holding the deduplicated and compressed data of its logical blocks
+type VDOPool struct {
 	Name string
 	VG   string
-	Size int64
-	Tags []string
-	Attr Attr
+	// Size is the physical size of the pool, and UsedSize how much of it is used. LVM only reports UsedSize and
+	// SavingPercent for pools that are active on this host, UsageKnown is false otherwise.
+	Size          int64
+	UsedSize      int64
+	SavingPercent float64
+	UsageKnown    bool
+}
+
+// CacheOptions describes the cache of an LV, allocated on faster PVs of its VG
+type CacheOptions struct {
+	// Mode is writethrough or writeback for a dm-cache pool, or writecache for a dm-writecache cache volume
+	Mode string
+	// PVTags restricts the cache to the PVs with any of these tags
+	PVTags []string
+}
+
+// IsWritecache returns true if the cache is a dm-writecache cache volume rather than a dm-cache pool
+func (o CacheOptions) IsWritecache() bool {
+	return o.Mode == "writecache"
+}
+
+// LVChanges describes modifications of the attributes of an existing LV. Empty fields are left unchanged.
+type LVChanges struct {
+	// ReadAhead is either "auto" or a number of 512-byte sectors
+	ReadAhead string
+	// CacheMode is the cache mode of a cached LV: writethrough, writeback or passthrough
+	CacheMode string
 }
 
 func (a Attr) IsActive() bool {
 	return rune(a[4]) == 'a'
 }
 
+func (a Attr) IsThinPool() bool {
+	return rune(a[0]) == 't'
+}
+
+func (a Attr) IsThinVolume() bool {
+	return rune(a[0]) == 'V'
+}
+
+// IsLinear returns true for plain LVs, which are neither thin, cached, RAID nor snapshots
+func (a Attr) IsLinear() bool {
+	return rune(a[0]) == '-'
+}
+
+func (a Attr) IsCached() bool {
+	return rune(a[0]) == 'C'
+}
+
+func (a Attr) IsVDO() bool {
+	return rune(a[0]) == 'v'
+}
+
+func (a Attr) IsRaid() bool {
+	return rune(a[0]) == 'r' || rune(a[0]) == 'R'
+}
+
+// HealthProblem describes the problem reported by the health bit of the LV attributes, or returns an empty string
+// if there is none
+func (a Attr) HealthProblem() string {
+	switch rune(a[8]) {
+	case 'p':
+		return "partial, one or more of its physical volumes are missing"
+	case 'r':
+		return "refresh needed, one or more of its RAID images failed"
+	case 'm':
+		return "RAID mismatches found"
+	case 'F':
+		return "failed"
+	case 'D':
+		return "thin pool out of data space"
+	case 'M':
+		return "thin pool metadata is read only"
+	case 'E':
+		return "integrity or writecache errors found"
+	default:
+		return ""
+	}
+}
+
+// NeedsRaidRepair returns true if images of a RAID LV are on missing or failed PVs, so that they have to be replaced
+func (lv *LogicalVolume) NeedsRaidRepair() bool {
+	return lv.Attr.IsRaid() && (lv.HealthStatus == "partial" || lv.HealthStatus == "refresh needed")
+}
+
+// ThinPoolFreeSize returns the data space that is still available in a thin pool, or false if its usage is unknown
+func (lv *LogicalVolume) ThinPoolFreeSize() (int64, bool) {
+	if !lv.UsageKnown {
+		return 0, false
+	}
+	return int64(float64(lv.Size) * (100 - lv.DataPercent) / 100), true
+}
+
+func (lv *LogicalVolume) HasTag(tag string) bool {
+	return slices.Contains(lv.Tags, tag)
+}
+
+type VGAttr string
+
 type VolumeGroup struct {
+	Name       string
+	FreeSize   int64
+	Attr       VGAttr
+	ExtentSize int64
+}
+
+// IsPartial returns true if one or more physical volumes of the volume group are missing
+func (a VGAttr) IsPartial() bool {
+	return rune(a[3]) == 'p'
+}
+
+// AllocationPolicy returns the allocation policy of the volume group: contiguous, cling, normal or anywhere
+func (a VGAttr) AllocationPolicy() string {
+	switch rune(a[4]) {
+	case 'c':
+		return "contiguous"
+	case 'l':
+		return "cling"
+	case 'a':
+		return "anywhere"
+	default:
+		return "normal"
+	}
+}
+
+type PhysicalVolume struct {
 	Name     string
+	VG       string
 	FreeSize int64
+	Tags     []string
+	// LargestFreeSegment is the size of the largest contiguous free area of the PV
+	LargestFreeSegment int64
 }